@@ -61,21 +61,9 @@ func (cache *LRUCache) Size() int {
 }
 
 // Get returns the CacheData of the entry saved under the given key.
+// Entries with variants only return the variant for requests without the headers they vary by (see GetVariant).
 func (cache *LRUCache) Get(key string) *CacheData {
-	// Write lock is used since Get will also rearrange the order of entries
-	cache.mutex.Lock()
-	defer cache.mutex.Unlock()
-
-	entry, exists := cache.entries[key]
-
-	if !exists {
-		return nil
-	}
-
-	// Set fetched entry as head
-	cache.moveToMRU(entry)
-
-	return entry.Data()
+	return cache.GetVariant(key, noHeaders)
 }
 
 // Set saves an entry with the given CacheData under the given key in the cache.
@@ -90,6 +78,14 @@ func (cache *LRUCache) Set(key string, data *CacheData) {
 		return
 	}
 
+	cache.add(key, data)
+}
+
+// add saves a new entry with the given CacheData under the given key as the MRU entry, and evicts the LRU entry if the cache is full.
+func (cache *LRUCache) add(key string, data *CacheData) *CacheEntry {
+	// No need to lock mutex here, this is not an atomic operation
+	// which means that the calling operations (Set, SetVariant) will lock the mutex
+
 	// Ready the data for saving
 	entry := newEntry(key, data)
 
@@ -106,6 +102,8 @@ func (cache *LRUCache) Set(key string, data *CacheData) {
 	if len(cache.entries) > cache.capacity { // we don't use Size, since that has its own lock
 		cache.evictLRU()
 	}
+
+	return entry
 }
 
 // Bust will remove all entries saved under the given keys from the cache.
@@ -155,6 +153,23 @@ func (cache *LRUCache) Bust(keys ...string) {
 // The patterns are hydrated with URL parameters from paramMap before being compiled as regex.
 // If an empty slice of patterns is passed, all keys are returned (matching everything).
 func (cache *LRUCache) Match(patterns []string, paramMap map[string]string) []string {
+	return cache.match(patterns, paramMap, func(key string) (string, bool) {
+		return key, true
+	})
+}
+
+// MatchNamespace is like Match, but only returns keys in the given namespace (see NamespacedKey).
+// The patterns are matched against the keys without the namespace, so e.g. ^GET:/users matches the key users|GET:/users.
+func (cache *LRUCache) MatchNamespace(namespace string, patterns []string, paramMap map[string]string) []string {
+	return cache.match(patterns, paramMap, func(key string) (string, bool) {
+		keyNamespace, rest := SplitNamespace(key)
+		return rest, keyNamespace == namespace
+	})
+}
+
+// match returns the keys of the entries that match the given patterns.
+// filter returns the part of a key that is matched against the patterns, and false if the key should not be matched at all.
+func (cache *LRUCache) match(patterns []string, paramMap map[string]string, filter func(key string) (string, bool)) []string {
 	cache.mutex.RLock()
 	defer cache.mutex.RUnlock()
 
@@ -177,7 +192,8 @@ func (cache *LRUCache) Match(patterns []string, paramMap map[string]string) []st
 		}
 
 		for key := range cache.entries {
-			if patternExp.MatchString(key) {
+			matchedKey, ok := filter(key)
+			if ok && patternExp.MatchString(matchedKey) {
 				keys.Add(key)
 			}
 		}
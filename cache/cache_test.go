@@ -1,7 +1,9 @@
 package cache
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/magnus-bb/cache-me-ousside/internal/logger"
 	"github.com/stretchr/testify/assert"
@@ -16,7 +18,7 @@ var testData = CacheData{
 }
 
 func init() {
-	logger.Initialize("")
+	logger.Initialize("", logger.RotationConfig{})
 }
 
 func TestRequiredCapacity(t *testing.T) {
@@ -163,6 +165,24 @@ func TestMatch(t *testing.T) {
 	assert.ElementsMatch(t, expectedMatches, matches, "Expected cache.Match to return the the keys %v, but returned %v", expectedMatches, matches)
 }
 
+func TestMatchNamespace(t *testing.T) {
+	cache, _ := New(4, "")
+
+	cache.Set("GET:/users/1", &testData)
+	cache.Set(NamespacedKey("users", "GET:/users/1"), &testData)
+	cache.Set(NamespacedKey("users", "GET:/users/2"), &testData)
+	cache.Set("GET:/search?q=a|b", &testData) // a separator in the URL is not a namespace
+
+	matches := cache.MatchNamespace("users", []string{"^GET:/users/1$"}, nil)
+	assert.ElementsMatch(t, []string{"users|GET:/users/1"}, matches, "Expected cache.MatchNamespace to match patterns against keys without their namespace")
+
+	matches = cache.MatchNamespace("", []string{"^GET:/"}, nil)
+	assert.ElementsMatch(t, []string{"GET:/users/1", "GET:/search?q=a|b"}, matches, "Expected cache.MatchNamespace to only match keys in the given namespace")
+
+	matches = cache.MatchNamespace("users", nil, nil)
+	assert.Len(t, matches, 2, "Expected cache.MatchNamespace to match all keys in the namespace when no patterns are passed")
+}
+
 func TestBust(t *testing.T) {
 	cache, _ := New(5, "")
 
@@ -193,6 +213,153 @@ func TestBust(t *testing.T) {
 	sanityCheck(t, cache, expectedKeys)
 }
 
+func TestVariants(t *testing.T) {
+	assert := assert.New(t)
+	cache, _ := New(5, "")
+
+	headers := func(values map[string]string) func(string) string {
+		return func(name string) string { return values[name] }
+	}
+	gzipJSON := headers(map[string]string{"Accept-Encoding": "gzip, br", "Accept": "application/json"})
+
+	vary, cacheable := ParseVary("accept-encoding, Accept,accept-encoding")
+	assert.True(cacheable)
+	assert.Equal([]string{"Accept", "Accept-Encoding"}, vary, "Expected Vary to be parsed into sorted canonical names")
+
+	_, cacheable = ParseVary("Accept, *")
+	assert.False(cacheable, "Expected responses that vary by * not to be cacheable")
+
+	jsonData := CacheData{Body: []byte("json"), Vary: vary}
+	xmlData := CacheData{Body: []byte("xml"), Vary: vary}
+	cache.SetVariant("GET:/test1", &jsonData, gzipJSON)
+	cache.SetVariant("GET:/test1", &xmlData, headers(map[string]string{"Accept-Encoding": "gzip, br", "Accept": "application/xml"}))
+	cache.Set("GET:/test2", &testData)
+
+	sanityCheck(t, cache, []string{"GET:/test1", "GET:/test2"}) // variants share the entry of their URL
+
+	assert.Equal(&testData, cache.GetVariant("GET:/test2", gzipJSON), "Expected entries without Vary to be returned to all requests")
+
+	assert.Equal(&jsonData, cache.GetVariant("GET:/test1", headers(map[string]string{"Accept-Encoding": "gzip,br", "Accept": "application/json"})),
+		"Expected the variant with the same header values, ignoring whitespace between values")
+	assert.Equal(&xmlData, cache.GetVariant("GET:/test1", headers(map[string]string{"Accept-Encoding": "gzip, br", "Accept": "application/xml"})))
+	assert.Nil(cache.GetVariant("GET:/test1", headers(map[string]string{"Accept": "application/json"})), "Expected no variant for other header values")
+
+	sanityCheck(t, cache, []string{"GET:/test2", "GET:/test1"}) // a found variant moves its entry to MRU
+
+	cache.Bust("GET:/test1")
+	assert.Nil(cache.GetVariant("GET:/test1", gzipJSON), "Expected busting the URL to remove all of its variants")
+	sanityCheck(t, cache, []string{"GET:/test2"})
+}
+
+func TestVariantsReplacedWithoutVary(t *testing.T) {
+	assert := assert.New(t)
+	cache, _ := New(5, "")
+
+	english := func(string) string { return "en" }
+	danish := func(string) string { return "da" }
+
+	cache.SetVariant("GET:/test", &CacheData{Body: []byte("en"), Vary: []string{"Accept-Language"}}, english)
+
+	// The API has stopped sending Vary, so its response is the same for all requests
+	plain := CacheData{Body: []byte("all")}
+	cache.SetVariant("GET:/test", &plain, danish)
+
+	assert.Equal(&plain, cache.GetVariant("GET:/test", english), "Expected a response without Vary to replace the variants of the entry")
+	assert.Equal(&plain, cache.GetVariant("GET:/test", danish), "Expected a response without Vary to be returned to all requests")
+	assert.Nil(cache.Entries()["GET:/test"].Vary(), "Expected the entry to no longer vary")
+}
+
+func TestMaxVariants(t *testing.T) {
+	cache, _ := New(1, "")
+
+	for i := 0; i <= MaxVariants; i++ {
+		accept := fmt.Sprint(i)
+		cache.SetVariant("GET:/test1", &CacheData{Vary: []string{"Accept"}}, func(string) string { return accept })
+	}
+
+	assert.Len(t, cache.Entries()["GET:/test1"].Variants(), MaxVariants, "Expected no more than MaxVariants variants of an entry")
+}
+
+func TestNotModified(t *testing.T) {
+	data := CacheData{ETag: `W/"abc"`, LastModified: "Mon, 02 Jan 2006 15:04:05 GMT"}
+
+	tests := []struct {
+		ifNoneMatch     string
+		ifModifiedSince string
+		notModified     bool
+	}{
+		{`"abc"`, "", true},
+		{`"xyz", W/"abc"`, "", true},
+		{"*", "", true},
+		{`"xyz"`, "Mon, 02 Jan 2006 15:04:05 GMT", false}, // If-Modified-Since is ignored when If-None-Match is sent
+		{"", "Mon, 02 Jan 2006 15:04:05 GMT", true},
+		{"", "Tue, 03 Jan 2006 15:04:05 GMT", true},
+		{"", "Sun, 01 Jan 2006 15:04:05 GMT", false},
+		{"", "not a date", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.notModified, data.NotModified(tt.ifNoneMatch, tt.ifModifiedSince),
+			"Expected NotModified(%q, %q) to be %t", tt.ifNoneMatch, tt.ifModifiedSince, tt.notModified)
+	}
+}
+
+func TestRevalidate(t *testing.T) {
+	assert := assert.New(t)
+	cache, _ := New(5, "")
+
+	now := time.Now()
+	expired := &CacheData{
+		Headers: map[string]string{"Content-Type": "application/json", "Etag": `"v1"`, "Cache-Control": "max-age=60"},
+		Body:    []byte("body"),
+		ETag:    `"v1"`,
+		Expires: now,
+	}
+	cache.Set("GET:/test1", expired)
+
+	assert.True(expired.Expired(now), "Expected data to be expired at its expiry")
+	assert.False(expired.Expired(now.Add(-time.Second)))
+	assert.False((&CacheData{}).Expired(now), "Expected data without an expiry never to expire")
+	assert.True(expired.HasValidators())
+
+	revalidated := expired.Revalidated(map[string]string{"Cache-Control": "max-age=120", "Content-Length": "0", "Etag": `"v1"`}, now.Add(time.Minute))
+	assert.Equal("max-age=120", revalidated.Headers["Cache-Control"], "Expected headers of the 304 response to replace the stored ones")
+	assert.Equal("application/json", revalidated.Headers["Content-Type"])
+	assert.NotContains(revalidated.Headers, "Content-Length", "Expected headers about the empty body of the 304 response to be left out")
+	assert.Equal("max-age=60", expired.Headers["Cache-Control"], "Expected the expired data not to change")
+	assert.Equal([]byte("body"), revalidated.Body)
+
+	assert.True(cache.Replace("GET:/test1", expired, revalidated))
+	assert.Equal(revalidated, cache.Get("GET:/test1"))
+	assert.False(cache.Replace("GET:/test1", expired, revalidated), "Expected data that has already been replaced not to be replaced again")
+	assert.False(cache.Replace("GET:/test2", expired, revalidated), "Expected nothing to be replaced for missing keys")
+}
+
+func TestReplaceWithVary(t *testing.T) {
+	assert := assert.New(t)
+	cache, _ := New(5, "")
+
+	english := func(string) string { return "en" }
+	danish := func(string) string { return "da" }
+
+	expired := &CacheData{Body: []byte("all")}
+	cache.Set("GET:/test", expired)
+
+	// The API has started sending Vary, so the refetched response must not be served to all requests
+	refetched := &CacheData{Body: []byte("en"), Vary: []string{"Accept-Language"}}
+	assert.False(cache.Replace("GET:/test", expired, refetched), "Expected data with other Vary headers than the entry not to be replaced")
+	assert.Equal(expired, cache.Get("GET:/test"))
+
+	cache.SetVariant("GET:/test", refetched, english)
+	assert.Equal(refetched, cache.GetVariant("GET:/test", english))
+	assert.Nil(cache.GetVariant("GET:/test", danish), "Expected the variant not to be served to requests with other header values")
+
+	revalidated := &CacheData{Body: []byte("en"), Vary: []string{"Accept-Language"}}
+	assert.True(cache.Replace("GET:/test", refetched, revalidated), "Expected variants with the Vary headers of the entry to be replaced")
+	assert.Equal(revalidated, cache.GetVariant("GET:/test", english))
+}
+
 func TestSetInitEmpty(t *testing.T) {
 	set := make(Set[string])
 
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+//* CONDITIONAL REQUESTS
+// Entries keep the validators (ETag and Last-Modified) of their responses, so expired entries can be revalidated with the API,
+// and clients that already have a response can be answered with 304 Not Modified
+
+// Expired returns true if the data has an expiry that is not after now.
+func (data *CacheData) Expired(now time.Time) bool {
+	return !data.Expires.IsZero() && !now.Before(data.Expires)
+}
+
+// HasValidators returns true if the data can be revalidated, because its response had an ETag or a Last-Modified header.
+func (data *CacheData) HasValidators() bool {
+	return data.ETag != "" || data.LastModified != ""
+}
+
+// Revalidated returns a copy of the data that expires at expires, with the headers of a 304 Not Modified response to a revalidation.
+// The copy is used instead of changing the data, since other requests may be reading it.
+func (data *CacheData) Revalidated(headers map[string]string, expires time.Time) *CacheData {
+	revalidated := *data
+	revalidated.Expires = expires
+
+	revalidated.Headers = make(map[string]string, len(data.Headers))
+	for name, value := range data.Headers {
+		revalidated.Headers[name] = value
+	}
+
+	// A 304 response describes the stored response, so its headers replace the stored ones, except the ones that describe its own (empty) body
+	for name, value := range headers {
+		switch http.CanonicalHeaderKey(name) {
+		case "Content-Length", "Content-Type", "Content-Encoding", "Transfer-Encoding":
+			continue
+		case "Etag":
+			revalidated.ETag = value
+		case "Last-Modified":
+			revalidated.LastModified = value
+		}
+
+		revalidated.Headers[name] = value
+	}
+
+	return &revalidated
+}
+
+// NotModified returns true if a client that sent the If-None-Match and If-Modified-Since headers (empty if they were not sent)
+// already has the response of the data, so it can be answered with 304 Not Modified.
+// If-Modified-Since is ignored when If-None-Match is sent, and ETags are compared weakly, so W/"a" and "a" are the same.
+func (data *CacheData) NotModified(ifNoneMatch, ifModifiedSince string) bool {
+	if ifNoneMatch != "" {
+		if data.ETag == "" {
+			return false
+		}
+
+		for _, tag := range strings.Split(ifNoneMatch, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || weakETag(tag) == weakETag(data.ETag) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if ifModifiedSince == "" || data.LastModified == "" {
+		return false
+	}
+
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(data.LastModified)
+	if err != nil {
+		return false
+	}
+
+	return !modified.After(since)
+}
+
+// weakETag returns the ETag without its weak indicator.
+func weakETag(tag string) string {
+	return strings.TrimPrefix(tag, "W/")
+}
+
+// Replace saves the CacheData replacement in place of old in the entry under the given key, whether it is the data or a variant of the entry.
+// It returns false if old is no longer in the entry, e.g. because it has been busted or replaced by another request,
+// or if the replacement varies by other headers than the entry, in which case it must be saved with SetVariant instead.
+func (cache *LRUCache) Replace(key string, old, replacement *CacheData) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	entry, exists := cache.entries[key]
+	if !exists || !sameNames(entry.vary, replacement.Vary) {
+		return false
+	}
+
+	if entry.data == old {
+		entry.data = replacement
+		return true
+	}
+
+	for variantKey, variant := range entry.variants {
+		if variant == old {
+			entry.variants[variantKey] = replacement
+			return true
+		}
+	}
+
+	return false
+}
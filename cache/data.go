@@ -2,6 +2,7 @@ package cache
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -19,6 +20,13 @@ func NewCacheDataFromJSON(jsonData []byte) CacheData {
 type CacheData struct {
 	Headers map[string]string // we don't need to stringify headers
 	Body    []byte            `json:"body"`
+	// Vary are the names of the request headers that the response varies by, from its Vary header (see ParseVary).
+	Vary []string `json:"vary,omitempty"`
+	// ETag and LastModified are the validators of the response, from its ETag and Last-Modified headers, which are empty if it had none.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	// Expires is when the response should be revalidated with the API, or zero if it never expires.
+	Expires time.Time `json:"expires"`
 }
 
 // SetHeaders will add all of the CacheData headers to the fiber context of a route handler.
@@ -17,7 +17,12 @@ type CacheEntry struct {
 	// It is usually named after the route that is being cached.
 	key string
 	// data is an instance of CacheData, which contains both headers and body of an API response.
+	// It is nil if the responses vary by request headers, in which case they are saved in variants.
 	data *CacheData
+	// vary are the names of the request headers the responses vary by, from their Vary header.
+	vary []string
+	// variants are the responses for the different values of the request headers in vary, by their VariantKey.
+	variants map[string]*CacheData
 	// next contains a newer CacheEntry in the cache.
 	next *CacheEntry
 	// prev contains an older CacheEntry in the cache.
@@ -54,6 +59,16 @@ func (entry CacheEntry) Data() *CacheData {
 	return entry.data
 }
 
+// Vary returns the names of the request headers that the responses of the entry vary by, or nil if they do not vary.
+func (entry CacheEntry) Vary() []string {
+	return entry.vary
+}
+
+// Variants returns the responses of the entry by their VariantKey, or nil if they do not vary.
+func (entry CacheEntry) Variants() map[string]*CacheData {
+	return entry.variants
+}
+
 // Prev returns the previous entry in the cache.
 func (entry *CacheEntry) Prev() *CacheEntry {
 	return entry.prev
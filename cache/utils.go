@@ -90,6 +90,32 @@ func ToBytes(size uint64, unit string) (uint64, error) {
 	}
 }
 
+//* NAMESPACES
+// Entries from different APIs are kept apart by prefixing their keys with the name of the API
+
+// NamespaceSep separates the namespace of a key from the rest of the key, e.g. users|GET:/users/1.
+const NamespaceSep = "|"
+
+// NamespacedKey returns key in the given namespace. Keys in the empty namespace are returned as they are.
+func NamespacedKey(namespace, key string) string {
+	if namespace == "" {
+		return key
+	}
+
+	return namespace + NamespaceSep + key
+}
+
+// SplitNamespace returns the namespace of key and the rest of the key.
+// Keys start with an http method followed by a colon, so a separator after the colon is part of the URL and not a namespace.
+func SplitNamespace(key string) (namespace, rest string) {
+	namespace, rest, found := strings.Cut(key, NamespaceSep)
+	if !found || strings.Contains(namespace, ":") {
+		return "", key
+	}
+
+	return namespace, rest
+}
+
 //* REGEX ROUTES
 
 /*
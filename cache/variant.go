@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"fmt"
+	"net/textproto"
+	"sort"
+	"strings"
+
+	"github.com/magnus-bb/cache-me-ousside/internal/logger"
+)
+
+//* VARIANTS
+// Responses with a Vary header are stored as variants of the entry of their URL, keyed by the values of the request headers they vary by,
+// so they are evicted and busted together with the entry, but only served to requests with the same header values
+
+// MaxVariants is the number of variants an entry can hold. Responses for other header values are not cached once an entry is full,
+// so clients cannot fill the memory of the cache with e.g. made up Accept headers.
+const MaxVariants = 64
+
+// ParseVary returns the canonical names of the request headers in a Vary response header, sorted and without duplicates.
+// It returns false if the response varies by something other than request headers ("*"), and therefore cannot be cached.
+func ParseVary(vary string) ([]string, bool) {
+	names := make(Set[string])
+	for _, name := range strings.Split(vary, ",") {
+		name = strings.TrimSpace(name)
+		if name == "*" {
+			return nil, false
+		}
+		if name != "" {
+			names.Add(textproto.CanonicalMIMEHeaderKey(name))
+		}
+	}
+
+	sorted := names.Elements()
+	sort.Strings(sorted)
+
+	return sorted, true
+}
+
+// VariantKey returns the key of the variant for a request with the header values, where header returns the value of a request header.
+// Whitespace around comma-separated values is removed, so e.g. "gzip, br" and "gzip,br" are the same variant.
+func VariantKey(vary []string, header func(name string) string) string {
+	parts := make([]string, len(vary))
+	for i, name := range vary {
+		values := strings.Split(header(name), ",")
+		for j, value := range values {
+			values[j] = strings.TrimSpace(value)
+		}
+
+		parts[i] = name + "=" + strings.Join(values, ",")
+	}
+
+	return strings.Join(parts, "\n")
+}
+
+// noHeaders is used to look up variants for requests without headers.
+func noHeaders(string) string {
+	return ""
+}
+
+// GetVariant returns the CacheData saved under the given key for a request with the header values, where header returns the value of a request header.
+// Entries without a Vary header are returned to all requests, and entries with one are only returned if a variant with the same header values is saved.
+func (cache *LRUCache) GetVariant(key string, header func(name string) string) *CacheData {
+	// Write lock is used since GetVariant will also rearrange the order of entries
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	entry, exists := cache.entries[key]
+	if !exists {
+		return nil
+	}
+
+	data := entry.Data()
+	if len(entry.vary) > 0 {
+		data = entry.variants[VariantKey(entry.vary, header)]
+	}
+
+	if data != nil {
+		cache.moveToMRU(entry)
+	}
+
+	return data
+}
+
+// SetVariant saves the CacheData under the given key for a request with the header values, where header returns the value of a request header.
+// If data.Vary is set, the data is saved as a variant of the entry under the key, which is created if it does not exist.
+// If the entry has variants for other Vary headers, e.g. because the API has changed, they are replaced by the new variant,
+// and if data has no Vary header, e.g. because the API has stopped sending it, it replaces all variants of the entry.
+func (cache *LRUCache) SetVariant(key string, data *CacheData, header func(name string) string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	entry, exists := cache.entries[key]
+
+	if len(data.Vary) == 0 {
+		switch {
+		case !exists:
+			cache.add(key, data)
+		case len(entry.vary) > 0:
+			// Otherwise every request with new header values would miss the variants and be proxied again
+			entry.data = data
+			entry.vary = nil
+			entry.variants = nil
+		default:
+			logger.Warn(fmt.Sprintf("the key: %q already exists in the cache and has been ignored", key))
+		}
+
+		return
+	}
+
+	variantKey := VariantKey(data.Vary, header)
+
+	if !exists {
+		entry = cache.add(key, nil)
+	}
+
+	if !sameNames(entry.vary, data.Vary) {
+		entry.data = nil
+		entry.vary = data.Vary
+		entry.variants = make(map[string]*CacheData)
+	}
+
+	if _, exists := entry.variants[variantKey]; exists {
+		logger.Warn(fmt.Sprintf("the variant %q of the key: %q already exists in the cache and has been ignored", variantKey, key))
+		return
+	}
+
+	if len(entry.variants) >= MaxVariants {
+		logger.Warn(fmt.Sprintf("the key: %q already has %d variants in the cache, so the variant %q has been ignored", key, MaxVariants, variantKey))
+		return
+	}
+
+	entry.variants[variantKey] = data
+}
+
+// sameNames returns true if the sorted header names are the same.
+func sameNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
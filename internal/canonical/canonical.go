@@ -0,0 +1,54 @@
+// Package canonical rewrites request bodies into a canonical form and hashes them,
+// so bodies that only differ in formatting or in the order of their fields get the same cache key.
+package canonical
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+// JSON returns the JSON value with the keys of all objects sorted and no insignificant whitespace.
+// Numbers are kept exactly as they were written, e.g. 1.0 and 1 are not the same.
+func JSON(raw []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(value) // maps are marshaled with sorted keys
+}
+
+// Body returns the canonical form of a request body with the given Content-Type:
+// JSON with sorted keys for JSON bodies, form values sorted by name for form bodies, and the body as it is for anything else,
+// or for bodies that cannot be read as their Content-Type.
+func Body(body []byte, contentType string) []byte {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		if canonical, err := JSON(body); err == nil {
+			return canonical
+		}
+	case mediaType == "application/x-www-form-urlencoded":
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			return []byte(values.Encode()) // sorted by name, with the values of each name in the order they were sent
+		}
+	}
+
+	return body
+}
+
+// Hash returns the first 128 bits of the SHA-256 hash of data, hex encoded.
+// That keeps cache keys readable, while collisions stay out of reach.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,36 @@
+package canonical
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBody(t *testing.T) {
+	tests := []struct {
+		a, b        string
+		contentType string
+		same        bool
+	}{
+		{`{"q": "shoes", "filters": {"size": 42, "color": "red"}}`, `{"filters":{"color":"red","size":42},"q":"shoes"}`, "application/json", true},
+		{`{"q": "shoes"}`, `{"q": "shoes"}`, "application/vnd.api+json; charset=utf-8", true},
+		{`{"q": "shoes", "page": 1}`, `{"q": "shoes", "page": 2}`, "application/json", false},
+		{`{"page": 1}`, `{"page": 1.0}`, "application/json", false},
+		{`{"q": "a  b"}`, `{"q": "a b"}`, "application/json", false},
+		{`q=shoes&page=2`, `page=2&q=shoes`, "application/x-www-form-urlencoded", true},
+		{`q=shoes&q=boots`, `q=boots&q=shoes`, "application/x-www-form-urlencoded", false},
+		{`{"q": "shoes", "page": 1}`, `{"page": 1, "q": "shoes"}`, "text/plain", false},
+		{`{"q": "shoes"`, `{"q": "shoes"`, "application/json", true},
+	}
+
+	for _, tt := range tests {
+		a := Hash(Body([]byte(tt.a), tt.contentType))
+		b := Hash(Body([]byte(tt.b), tt.contentType))
+
+		if tt.same {
+			assert.Equal(t, a, b, "Expected %s and %s to have the same hash as %s", tt.a, tt.b, tt.contentType)
+		} else {
+			assert.NotEqual(t, a, b, "Expected %s and %s to have different hashes as %s", tt.a, tt.b, tt.contentType)
+		}
+	}
+}
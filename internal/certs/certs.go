@@ -0,0 +1,156 @@
+// Package certs loads the TLS certificates of the cache server from files
+// and reloads them when the files change, so certificates can be renewed without a restart.
+// It also loads the certificates that the cache server connects to upstreams with.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/magnus-bb/cache-me-ousside/internal/config"
+	"github.com/magnus-bb/cache-me-ousside/internal/logger"
+	"github.com/magnus-bb/cache-me-ousside/internal/watcher"
+)
+
+// Server holds the TLS configuration of the cache server, which is built again from the files of the ServerTLS settings when they change.
+// Connections that are already open keep the certificate they were made with.
+type Server struct {
+	settings config.ServerTLS
+
+	// current holds the *tls.Config built from the files when they were last loaded successfully.
+	current atomic.Value
+	// mu makes sure a file change and a SIGHUP do not reload at the same time.
+	mu      sync.Mutex
+	watcher *watcher.Watcher
+}
+
+// NewServer loads the certificate, key, and client CAs of settings. An error is returned if they cannot be loaded,
+// since the cache server cannot serve HTTPS without them. The files are not watched until Watch is called.
+func NewServer(settings config.ServerTLS) (*Server, error) {
+	server := &Server{settings: settings}
+
+	tlsConfig, err := server.load()
+	if err != nil {
+		return nil, err
+	}
+	server.current.Store(tlsConfig)
+
+	return server, nil
+}
+
+// Config returns a *tls.Config for a listener, which always hands out the certificate that was loaded most recently.
+func (server *Server) Config() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return server.current.Load().(*tls.Config), nil
+		},
+	}
+}
+
+// Watch starts reloading the files whenever one of them changes, until Stop is called.
+func (server *Server) Watch() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.watcher = watcher.Watch(watcher.DefaultInterval, server.Reload, server.settings.Files()...)
+}
+
+// Stop stops watching the files. It is safe to call even if the files are not watched.
+func (server *Server) Stop() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if server.watcher != nil {
+		server.watcher.Stop()
+	}
+}
+
+// Reload loads the files again and starts using them for new connections.
+// If they cannot be loaded, e.g. because the certificate has been replaced but the key has not yet,
+// the error is logged and the certificate that was loaded before is kept.
+func (server *Server) Reload() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	tlsConfig, err := server.load()
+	if err != nil {
+		logger.Error(fmt.Errorf("the TLS certificate was not reloaded, the current certificate will be kept: %w", err))
+		return
+	}
+	server.current.Store(tlsConfig)
+
+	logger.Info("the TLS certificate has been reloaded from " + server.settings.CertFile)
+}
+
+// load builds a *tls.Config from the files and the other ServerTLS settings.
+func (server *Server) load() (*tls.Config, error) {
+	settings := server.settings
+
+	certificate, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load the TLS certificate %s and key %s: %w", settings.CertFile, settings.KeyFile, err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   settings.TLSMinVersion(),
+		CipherSuites: settings.TLSCipherSuites(),
+		ClientAuth:   settings.TLSClientAuth(),
+	}
+
+	if settings.ClientCAFile != "" {
+		tlsConfig.ClientCAs, err = LoadCertPool(settings.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// ClientConfig returns the *tls.Config that the servers of an upstream are connected to with.
+// The files of settings are only loaded once, so the upstream Pools must be built again to pick up a renewed client certificate.
+func ClientConfig(settings config.UpstreamTLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         settings.ServerName, // if empty, the hostname of each server is used
+		InsecureSkipVerify: settings.InsecureSkipVerify,
+	}
+
+	if settings.CAFile != "" {
+		roots, err := LoadCertPool(settings.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	if settings.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load the client certificate %s and key %s: %w", settings.CertFile, settings.KeyFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+// LoadCertPool returns a pool with the PEM encoded certificates in the file at path.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the CA certificates: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("could not read the CA certificates: no PEM encoded certificates found in " + path)
+	}
+
+	return pool, nil
+}
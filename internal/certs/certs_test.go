@@ -0,0 +1,191 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/magnus-bb/cache-me-ousside/internal/config"
+	"github.com/magnus-bb/cache-me-ousside/internal/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.Initialize("", logger.RotationConfig{})
+}
+
+// testCert is a certificate and its key, both PEM encoded.
+type testCert struct {
+	cert, key []byte
+	parsed    *x509.Certificate
+	signer    *ecdsa.PrivateKey
+}
+
+// newTestCert creates a certificate for localhost with the serial number, signed by ca or self-signed if ca is nil.
+func newTestCert(t *testing.T, serial int64, ca *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  ca == nil,
+	}
+
+	parent, signer := template, key
+	if ca != nil {
+		parent, signer = ca.parsed, ca.signer
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _ := x509.ParseCertificate(der)
+
+	return &testCert{
+		cert:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:    pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+		parsed: parsed,
+		signer: key,
+	}
+}
+
+// write writes the certificate and key to cert.pem and key.pem in dir.
+func (cert *testCert) write(t *testing.T, dir string) {
+	if err := os.WriteFile(filepath.Join(dir, "cert.pem"), cert.cert, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "key.pem"), cert.key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handshake connects to a TLS listener with the Config of server and returns the certificate the server presented.
+func handshake(server *Server, clientConfig *tls.Config) (*x509.Certificate, error) {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", server.Config())
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// With TLS 1.3, the client finds out that its certificate was rejected on the first read
+	if _, err := conn.Read(make([]byte, 1)); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	return conn.ConnectionState().PeerCertificates[0], nil
+}
+
+func TestReload(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	newTestCert(t, 1, nil).write(t, dir)
+
+	server, err := NewServer(config.ServerTLS{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")})
+	if !assert.NoError(err) {
+		return
+	}
+
+	cert, err := handshake(server, &tls.Config{InsecureSkipVerify: true})
+	if assert.NoError(err) {
+		assert.Equal(int64(1), cert.SerialNumber.Int64())
+	}
+
+	newTestCert(t, 2, nil).write(t, dir)
+	server.Reload()
+
+	cert, err = handshake(server, &tls.Config{InsecureSkipVerify: true})
+	if assert.NoError(err) {
+		assert.Equal(int64(2), cert.SerialNumber.Int64(), "Expected new connections to get the reloaded certificate")
+	}
+
+	os.WriteFile(filepath.Join(dir, "key.pem"), newTestCert(t, 3, nil).key, 0o600) // a key that does not match the certificate
+	server.Reload()
+
+	cert, err = handshake(server, &tls.Config{InsecureSkipVerify: true})
+	if assert.NoError(err) {
+		assert.Equal(int64(2), cert.SerialNumber.Int64(), "Expected the current certificate to be kept when the files cannot be loaded")
+	}
+}
+
+func TestClientCertificates(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	ca := newTestCert(t, 1, nil)
+	newTestCert(t, 2, ca).write(t, dir)
+	os.WriteFile(filepath.Join(dir, "ca.pem"), ca.cert, 0o600)
+
+	client := newTestCert(t, 3, ca)
+	clientCert, _ := tls.X509KeyPair(client.cert, client.key)
+	roots, _ := LoadCertPool(filepath.Join(dir, "ca.pem"))
+
+	settings := config.ServerTLS{
+		CertFile:     filepath.Join(dir, "cert.pem"),
+		KeyFile:      filepath.Join(dir, "key.pem"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+	}
+	server, err := NewServer(settings)
+	if !assert.NoError(err) {
+		return
+	}
+
+	_, err = handshake(server, &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	assert.Error(err, "Expected clients without a certificate to be rejected")
+
+	_, err = handshake(server, &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: []tls.Certificate{clientCert}})
+	assert.NoError(err, "Expected clients with a certificate signed by the CA to be accepted")
+
+	settings.ClientAuth = "verify-if-given"
+	server, _ = NewServer(settings)
+
+	_, err = handshake(server, &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	assert.NoError(err, "Expected clients without a certificate to be accepted when certificates are only verified if given")
+}
+
+func TestMissingCertificate(t *testing.T) {
+	_, err := NewServer(config.ServerTLS{CertFile: "testdata/does.not.exist.pem", KeyFile: "testdata/does.not.exist.pem"})
+
+	assert.Error(t, err, "Expected an error when the certificate cannot be loaded")
+}
@@ -3,40 +3,93 @@ package commandline
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/magnus-bb/cache-me-ousside/internal/config"
+	"github.com/magnus-bb/cache-me-ousside/internal/router"
 	"github.com/urfave/cli/v2"
 )
 
-// Separator chars are used to parse cli bust route arguments, since they are complex data types serialized as strings.
+// Separator chars are used to parse cli bust route and upstream arguments, since they are complex data types serialized as strings.
 const (
-	RouteSepChar   = "=>"
-	PatternSepChar = "||"
+	RouteSepChar        = "=>"
+	PatternSepChar      = "||"
+	UpstreamNameSepChar = "="
 )
 
+// startupArgs are the arguments the Config was created from, so the Config can be created again with ReloadConf.
+var startupArgs *cliArgs
+
 // cliArgs are used to store all command line arguments to be used by the config.
 type cliArgs struct {
-	configPath   string
-	capacity     uint64
-	capacityUnit string
-	hostname     string
-	port         uint
-	apiUrl       string
-	logFilePath  string
-	cacheGET     cli.StringSlice // will contain all the paths to cache on GET requests
-	cacheHEAD    cli.StringSlice // will contain all the paths to cache on HEAD requests
-	bustGET      cli.StringSlice // first element is the path, rest are the patterns of entries to bust
-	bustHEAD     cli.StringSlice // first element is the path, rest are the patterns of entries to bust
-	bustPOST     cli.StringSlice // first element is the path, rest are the patterns of entries to bust
-	bustPUT      cli.StringSlice // first element is the path, rest are the patterns of entries to bust
-	bustDELETE   cli.StringSlice // first element is the path, rest are the patterns of entries to bust
-	bustPATCH    cli.StringSlice // first element is the path, rest are the patterns of entries to bust
-	bustTRACE    cli.StringSlice // first element is the path, rest are the patterns of entries to bust
-	bustCONNECT  cli.StringSlice // first element is the path, rest are the patterns of entries to bust
-	bustOPTIONS  cli.StringSlice // first element is the path, rest are the patterns of entries to bust
+	configPath      string
+	configFormat    string
+	allowUnknown    bool
+	capacity        uint64
+	capacityUnit    string
+	hostname        string
+	port            uint
+	tlsCertFile     string
+	tlsKeyFile      string
+	tlsMinVersion   string
+	tlsCipherSuites cli.StringSlice
+	tlsClientCAFile string
+	tlsClientAuth   string
+	apiUrl          string
+	apiReplicas     cli.StringSlice
+	balance         string
+	healthCheckPath string
+	healthInterval  time.Duration
+	healthTimeout   time.Duration
+	breakerFailures uint
+	breakerTimeout  time.Duration
+	breakerOpenMode string
+	dialTimeout     time.Duration
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	maxConnsPerHost uint
+	idleConnTimeout time.Duration
+	retries         uint
+	retryBackoff    time.Duration
+	apiCAFile       string
+	apiCertFile     string
+	apiKeyFile      string
+	apiServerName   string
+	apiInsecure     bool
+	statsPath       string
+	graphqlPaths    cli.StringSlice
+	idemRetention   time.Duration
+	idemMaxKeys     uint
+	idemWait        time.Duration
+	idemClient      string
+	upstreams       cli.StringSlice // each element is the name and url of an upstream with its routes
+	logFilePath     string
+	logMaxSize      uint64
+	logMaxAge       time.Duration
+	logMaxBackups   uint
+	logCompress     bool
+	accessLogPath   string
+	accessFormat    string
+	watchConfig     bool
+	shutdownTimeout time.Duration
+	shutdownTimeSet bool            // the flag or env var was given, so a timeout of 0 (wait for as long as it takes) overwrites the config file
+	cacheGET        cli.StringSlice // will contain all the paths to cache on GET requests
+	cacheHEAD       cli.StringSlice // will contain all the paths to cache on HEAD requests
+	cachePOST       cli.StringSlice // will contain all the paths to cache on POST requests, keyed by their body
+	cacheBodyLimit  uint64
+	cacheTTL        time.Duration
+	bustGET         cli.StringSlice // first element is the path, rest are the patterns of entries to bust
+	bustHEAD        cli.StringSlice // first element is the path, rest are the patterns of entries to bust
+	bustPOST        cli.StringSlice // first element is the path, rest are the patterns of entries to bust
+	bustPUT         cli.StringSlice // first element is the path, rest are the patterns of entries to bust
+	bustDELETE      cli.StringSlice // first element is the path, rest are the patterns of entries to bust
+	bustPATCH       cli.StringSlice // first element is the path, rest are the patterns of entries to bust
+	bustTRACE       cli.StringSlice // first element is the path, rest are the patterns of entries to bust
+	bustCONNECT     cli.StringSlice // first element is the path, rest are the patterns of entries to bust
+	bustOPTIONS     cli.StringSlice // first element is the path, rest are the patterns of entries to bust
 }
 
 /*
@@ -61,12 +114,137 @@ func (a *cliArgs) addToConfig(c *config.Config) error {
 	if a.port != 0 {
 		c.Port = a.port
 	}
+	if a.tlsCertFile != "" {
+		c.TLS.CertFile = a.tlsCertFile
+	}
+	if a.tlsKeyFile != "" {
+		c.TLS.KeyFile = a.tlsKeyFile
+	}
+	if a.tlsMinVersion != "" {
+		c.TLS.MinVersion = a.tlsMinVersion
+	}
+	if len(a.tlsCipherSuites.Value()) > 0 {
+		c.TLS.CipherSuites = a.tlsCipherSuites.Value()
+	}
+	if a.tlsClientCAFile != "" {
+		c.TLS.ClientCAFile = a.tlsClientCAFile
+	}
+	if a.tlsClientAuth != "" {
+		c.TLS.ClientAuth = a.tlsClientAuth
+	}
 	if a.apiUrl != "" {
 		c.ApiUrl = a.apiUrl
 	}
+	if len(a.apiReplicas.Value()) > 0 {
+		c.ApiReplicas = a.apiReplicas.Value()
+	}
+	if a.balance != "" {
+		c.Balance = a.balance
+	}
+	if a.healthCheckPath != "" {
+		c.HealthCheck.Path = a.healthCheckPath
+	}
+	if a.healthInterval != 0 {
+		c.HealthCheck.Interval = config.Duration(a.healthInterval)
+	}
+	if a.healthTimeout != 0 {
+		c.HealthCheck.Timeout = config.Duration(a.healthTimeout)
+	}
+	if a.breakerFailures != 0 {
+		c.CircuitBreaker.FailureThreshold = a.breakerFailures
+	}
+	if a.breakerTimeout != 0 {
+		c.CircuitBreaker.OpenTimeout = config.Duration(a.breakerTimeout)
+	}
+	if a.breakerOpenMode != "" {
+		c.CircuitBreaker.WhileOpen = a.breakerOpenMode
+	}
+	if a.dialTimeout != 0 {
+		c.Proxy.DialTimeout = config.Duration(a.dialTimeout)
+	}
+	if a.readTimeout != 0 {
+		c.Proxy.ReadTimeout = config.Duration(a.readTimeout)
+	}
+	if a.writeTimeout != 0 {
+		c.Proxy.WriteTimeout = config.Duration(a.writeTimeout)
+	}
+	if a.maxConnsPerHost != 0 {
+		c.Proxy.MaxConnsPerHost = a.maxConnsPerHost
+	}
+	if a.idleConnTimeout != 0 {
+		c.Proxy.IdleConnTimeout = config.Duration(a.idleConnTimeout)
+	}
+	if a.retries != 0 {
+		c.Proxy.Retries = a.retries
+	}
+	if a.retryBackoff != 0 {
+		c.Proxy.RetryBackoff = config.Duration(a.retryBackoff)
+	}
+	if a.apiCAFile != "" {
+		c.ApiTLS.CAFile = a.apiCAFile
+	}
+	if a.apiCertFile != "" {
+		c.ApiTLS.CertFile = a.apiCertFile
+	}
+	if a.apiKeyFile != "" {
+		c.ApiTLS.KeyFile = a.apiKeyFile
+	}
+	if a.apiServerName != "" {
+		c.ApiTLS.ServerName = a.apiServerName
+	}
+	if a.apiInsecure {
+		c.ApiTLS.InsecureSkipVerify = a.apiInsecure
+	}
+	if a.statsPath != "" {
+		c.StatsPath = a.statsPath
+	}
+	if a.idemRetention != 0 {
+		c.Idempotency.Retention = config.Duration(a.idemRetention)
+	}
+	if a.idemMaxKeys != 0 {
+		c.Idempotency.MaxKeys = a.idemMaxKeys
+	}
+	if a.idemWait != 0 {
+		c.Idempotency.Wait = config.Duration(a.idemWait)
+	}
+	if a.idemClient != "" {
+		c.Idempotency.ClientHeader = a.idemClient
+	}
+	if len(a.graphqlPaths.Value()) > 0 {
+		c.GraphQL.Endpoints = a.graphqlPaths.Value()
+	}
+	for _, args := range a.upstreams.Value() {
+		if err := parseAndSetUpstreamArgs(c, args); err != nil {
+			return err
+		}
+	}
 	if a.logFilePath != "" {
 		c.LogFilePath = a.logFilePath
 	}
+	if a.logMaxSize != 0 {
+		c.LogMaxSize = a.logMaxSize
+	}
+	if a.logMaxAge != 0 {
+		c.LogMaxAge = config.Duration(a.logMaxAge)
+	}
+	if a.logMaxBackups != 0 {
+		c.LogMaxBackups = a.logMaxBackups
+	}
+	if a.logCompress {
+		c.LogCompress = a.logCompress
+	}
+	if a.accessLogPath != "" {
+		c.AccessLogPath = a.accessLogPath
+	}
+	if a.accessFormat != "" {
+		c.AccessLogFormat = a.accessFormat
+	}
+	if a.watchConfig {
+		c.WatchConfig = a.watchConfig
+	}
+	if a.shutdownTimeSet {
+		c.ShutdownTimeout = config.Duration(a.shutdownTimeout)
+	}
 
 	if len(a.cacheGET.Value()) > 0 {
 		c.Cache["GET"] = a.cacheGET.Value()
@@ -74,6 +252,15 @@ func (a *cliArgs) addToConfig(c *config.Config) error {
 	if len(a.cacheHEAD.Value()) > 0 {
 		c.Cache["HEAD"] = a.cacheHEAD.Value()
 	}
+	if len(a.cachePOST.Value()) > 0 {
+		c.Cache["POST"] = a.cachePOST.Value()
+	}
+	if a.cacheBodyLimit != 0 {
+		c.CacheBodyLimit = a.cacheBodyLimit
+	}
+	if a.cacheTTL != 0 {
+		c.CacheTTL = config.Duration(a.cacheTTL)
+	}
 
 	if len(a.bustGET.Value()) > 0 {
 		for _, args := range a.bustGET.Value() {
@@ -161,8 +348,9 @@ func (a *cliArgs) addToConfig(c *config.Config) error {
 
 /*
 	CreateConfFromCli will parse cli arguments and flags and return a Config with the specified configuration.
-	If a configuration json file is provided with --config, any cli flags will overwrite the file's configuration.
+	If a configuration file is provided with --config, any cli flags will overwrite the file's configuration.
 	The configuration is also validated and trimmed for invalid http methods and trailing slash in the ApiUrl.
+	If a command (like print) or --help was run instead of the cache server, the returned Config is nil.
 */
 func CreateConfFromCli() (*config.Config, error) {
 	args := cliArgs{} // holds the flags that should overwrite potential config file values
@@ -187,9 +375,21 @@ func CreateConfFromCli() (*config.Config, error) {
 				Destination: &args.configPath,
 				Name:        "config",
 				Aliases:     []string{"conf", "path"},
-				Usage:       "the `PATH` to a json config file specifying the cache settings (will be overwritten by command line flags)",
+				Usage:       "the `PATH` to a JSON, YAML, or TOML config file specifying the cache settings (will be overwritten by command line flags)",
 				EnvVars:     []string{"CONFIG_PATH", "CONFIG"},
 			},
+			&cli.StringFlag{
+				Destination: &args.configFormat,
+				Name:        "config-format",
+				Usage:       "the `FORMAT` of the config file, which is either 'json', 'yaml', or 'toml'. Omit this to choose the format by the file extension",
+				EnvVars:     []string{"CONFIG_FORMAT"},
+			},
+			&cli.BoolFlag{
+				Destination: &args.allowUnknown,
+				Name:        "allow-unknown-props",
+				Usage:       "ignore properties in the config file that are not recognized instead of failing, e.g. to share a config file with a newer version",
+				EnvVars:     []string{"ALLOW_UNKNOWN_PROPS"},
+			},
 			&cli.Uint64Flag{
 				Destination: &args.capacity,
 				Name:        "capacity",
@@ -218,6 +418,42 @@ func CreateConfFromCli() (*config.Config, error) {
 				Usage:       "the `PORT` where the cache is accessible",
 				EnvVars:     []string{"PORT"},
 			},
+			&cli.PathFlag{
+				Destination: &args.tlsCertFile,
+				Name:        "tls-cert",
+				Usage:       "the `PATH` to a PEM encoded certificate to serve HTTPS with. It is reloaded when the file changes",
+				EnvVars:     []string{"TLS_CERT_FILE"},
+			},
+			&cli.PathFlag{
+				Destination: &args.tlsKeyFile,
+				Name:        "tls-key",
+				Usage:       "the `PATH` to the PEM encoded private key of the TLS certificate",
+				EnvVars:     []string{"TLS_KEY_FILE"},
+			},
+			&cli.StringFlag{
+				Destination: &args.tlsMinVersion,
+				Name:        "tls-min-version",
+				Usage:       "the lowest TLS `VERSION` clients can use. Valid VERSIONs are '1.0', '1.1', '1.2' (default), and '1.3'",
+				EnvVars:     []string{"TLS_MIN_VERSION"},
+			},
+			&cli.StringSliceFlag{
+				Destination: &args.tlsCipherSuites,
+				Name:        "tls-cipher-suite",
+				Usage:       "the `NAME` of a cipher suite clients can use with TLS 1.2 and below, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Omit this to use the secure defaults",
+				EnvVars:     []string{"TLS_CIPHER_SUITES"},
+			},
+			&cli.PathFlag{
+				Destination: &args.tlsClientCAFile,
+				Name:        "tls-client-ca",
+				Usage:       "the `PATH` to PEM encoded certificates of the CAs that client certificates must be signed by. Omit this to not ask clients for certificates",
+				EnvVars:     []string{"TLS_CLIENT_CA_FILE"},
+			},
+			&cli.StringFlag{
+				Destination: &args.tlsClientAuth,
+				Name:        "tls-client-auth",
+				Usage:       "how client certificates are verified. Valid `MODE`s are 'require' (default) and 'verify-if-given', which also lets clients without a certificate connect",
+				EnvVars:     []string{"TLS_CLIENT_AUTH"},
+			},
 			&cli.StringFlag{
 				Destination: &args.apiUrl,
 				Name:        "api-url",
@@ -225,6 +461,168 @@ func CreateConfFromCli() (*config.Config, error) {
 				Usage:       "the `URL` of the API to cache",
 				EnvVars:     []string{"API_URL", "PROXY_URL"},
 			},
+			&cli.StringSliceFlag{
+				Destination: &args.apiReplicas,
+				Name:        "api-replica",
+				Usage:       "the `URL` of another server with the same API as the API URL, which requests are balanced across. Can be used multiple times",
+				EnvVars:     []string{"API_REPLICAS"},
+			},
+			&cli.StringFlag{
+				Destination: &args.balance,
+				Name:        "balance",
+				Usage:       "how requests are balanced across the API URL and the API replicas. Valid `STRATEGY`s are 'round-robin' (default), 'least-connections', and 'consistent-hash'",
+				EnvVars:     []string{"BALANCE"},
+			},
+			&cli.StringFlag{
+				Destination: &args.healthCheckPath,
+				Name:        "health-check-path",
+				Usage:       "the `PATH` (e.g. /health) to request on the API URL and the API replicas to take failing servers out of rotation. Omit this to disable health checks",
+				EnvVars:     []string{"HEALTH_CHECK_PATH"},
+			},
+			&cli.DurationFlag{
+				Destination: &args.healthInterval,
+				Name:        "health-check-interval",
+				Usage:       "the `DURATION` (e.g. 10s) to wait between health checks of a server",
+				EnvVars:     []string{"HEALTH_CHECK_INTERVAL"},
+			},
+			&cli.DurationFlag{
+				Destination: &args.healthTimeout,
+				Name:        "health-check-timeout",
+				Usage:       "the `DURATION` (e.g. 2s) a server has to respond before a health check fails",
+				EnvVars:     []string{"HEALTH_CHECK_TIMEOUT"},
+			},
+			&cli.UintFlag{
+				Destination: &args.breakerFailures,
+				Name:        "circuit-breaker-threshold",
+				Usage:       "the `NUMBER` of requests to the API in a row that must fail to open the circuit breaker, so requests fail fast. Omit this to disable the circuit breaker",
+				EnvVars:     []string{"CIRCUIT_BREAKER_THRESHOLD"},
+			},
+			&cli.DurationFlag{
+				Destination: &args.breakerTimeout,
+				Name:        "circuit-breaker-timeout",
+				Usage:       "the `DURATION` (e.g. 30s) the circuit breaker stays open before requests can try the API again",
+				EnvVars:     []string{"CIRCUIT_BREAKER_TIMEOUT"},
+			},
+			&cli.StringFlag{
+				Destination: &args.breakerOpenMode,
+				Name:        "circuit-breaker-while-open",
+				Usage:       "how requests are handled while the circuit breaker is open. Valid `MODE`s are 'fail' (default) and 'stale', which also stops busting, so cached responses are still served",
+				EnvVars:     []string{"CIRCUIT_BREAKER_WHILE_OPEN"},
+			},
+			&cli.DurationFlag{
+				Destination: &args.dialTimeout,
+				Name:        "proxy-dial-timeout",
+				Usage:       "the `DURATION` (e.g. 3s) connecting to a server of the API can take",
+				EnvVars:     []string{"PROXY_DIAL_TIMEOUT"},
+			},
+			&cli.DurationFlag{
+				Destination: &args.readTimeout,
+				Name:        "proxy-read-timeout",
+				Usage:       "the `DURATION` (e.g. 30s) a server of the API can take to respond. Omit this to wait for as long as it takes",
+				EnvVars:     []string{"PROXY_READ_TIMEOUT"},
+			},
+			&cli.DurationFlag{
+				Destination: &args.writeTimeout,
+				Name:        "proxy-write-timeout",
+				Usage:       "the `DURATION` (e.g. 10s) sending a request to a server of the API can take. Omit this to wait for as long as it takes",
+				EnvVars:     []string{"PROXY_WRITE_TIMEOUT"},
+			},
+			&cli.UintFlag{
+				Destination: &args.maxConnsPerHost,
+				Name:        "proxy-max-conns",
+				Usage:       "the `NUMBER` of connections that can be open to each server of the API at the same time (default: 512)",
+				EnvVars:     []string{"PROXY_MAX_CONNS"},
+			},
+			&cli.DurationFlag{
+				Destination: &args.idleConnTimeout,
+				Name:        "proxy-idle-timeout",
+				Usage:       "the `DURATION` (e.g. 10s) a connection to a server of the API can be idle before it is closed. This stands in for a limit on idle connections, which the HTTP client does not have",
+				EnvVars:     []string{"PROXY_IDLE_TIMEOUT"},
+			},
+			&cli.UintFlag{
+				Destination: &args.retries,
+				Name:        "proxy-retries",
+				Usage:       "the `NUMBER` of times a GET or HEAD request is retried when the API fails with an error or a 502, 503, or 504 status",
+				EnvVars:     []string{"PROXY_RETRIES"},
+			},
+			&cli.DurationFlag{
+				Destination: &args.retryBackoff,
+				Name:        "proxy-retry-backoff",
+				Usage:       "the `DURATION` (e.g. 100ms) to wait before the first retry, which is doubled for every retry after it",
+				EnvVars:     []string{"PROXY_RETRY_BACKOFF"},
+			},
+			&cli.PathFlag{
+				Destination: &args.apiCAFile,
+				Name:        "api-ca",
+				Usage:       "the `PATH` to PEM encoded certificates of the CAs that the certificates of the API must be signed by, instead of the CAs of the system",
+				EnvVars:     []string{"API_CA_FILE"},
+			},
+			&cli.PathFlag{
+				Destination: &args.apiCertFile,
+				Name:        "api-cert",
+				Usage:       "the `PATH` to a PEM encoded client certificate to present to the API (mutual TLS)",
+				EnvVars:     []string{"API_CERT_FILE"},
+			},
+			&cli.PathFlag{
+				Destination: &args.apiKeyFile,
+				Name:        "api-key",
+				Usage:       "the `PATH` to the PEM encoded private key of the client certificate",
+				EnvVars:     []string{"API_KEY_FILE"},
+			},
+			&cli.StringFlag{
+				Destination: &args.apiServerName,
+				Name:        "api-server-name",
+				Usage:       "the `NAME` to send to the API with SNI and expect in its certificate, instead of the hostname of the API URL",
+				EnvVars:     []string{"API_SERVER_NAME"},
+			},
+			&cli.BoolFlag{
+				Destination: &args.apiInsecure,
+				Name:        "api-insecure-skip-verify",
+				Usage:       "accept any certificate from the API. Only use this for development",
+				EnvVars:     []string{"API_INSECURE_SKIP_VERIFY"},
+			},
+			&cli.StringFlag{
+				Destination: &args.statsPath,
+				Name:        "stats-path",
+				Usage:       "the `PATH` (e.g. /_stats) where the cache server responds with the state of the upstreams as JSON instead of proxying the request",
+				EnvVars:     []string{"STATS_PATH"},
+			},
+			&cli.DurationFlag{
+				Destination: &args.idemRetention,
+				Name:        "idempotency-retention",
+				Usage:       "the `DURATION` (e.g. 24h) the response to a POST, PUT, or PATCH request with an Idempotency-Key header is replayed to retries with the same key. Omit this to disable idempotency keys",
+				EnvVars:     []string{"IDEMPOTENCY_RETENTION"},
+			},
+			&cli.UintFlag{
+				Destination: &args.idemMaxKeys,
+				Name:        "idempotency-max-keys",
+				Usage:       "the `NUMBER` of idempotency keys that are kept at the same time (default: 10000)",
+				EnvVars:     []string{"IDEMPOTENCY_MAX_KEYS"},
+			},
+			&cli.DurationFlag{
+				Destination: &args.idemWait,
+				Name:        "idempotency-wait",
+				Usage:       "the `DURATION` (e.g. 10s) a retry waits for the first request with the same idempotency key before it is rejected with 409 Conflict (default: 30s)",
+				EnvVars:     []string{"IDEMPOTENCY_WAIT"},
+			},
+			&cli.StringFlag{
+				Destination: &args.idemClient,
+				Name:        "idempotency-client-header",
+				Usage:       "the `HEADER` that identifies a client, so clients cannot get the responses to each other's idempotency keys (default: Authorization)",
+				EnvVars:     []string{"IDEMPOTENCY_CLIENT_HEADER"},
+			},
+			&cli.StringSliceFlag{
+				Destination: &args.graphqlPaths,
+				Name:        "graphql-endpoint",
+				Usage:       "the `ROUTE` (e.g. /graphql) of a GraphQL endpoint, where queries are cached by their query, operation name, and variables. Can be used multiple times",
+				EnvVars:     []string{"GRAPHQL_ENDPOINTS"},
+			},
+			&cli.StringSliceFlag{
+				Destination: &args.upstreams,
+				Name:        "upstream",
+				Usage:       fmt.Sprintf("is parsed from the format '[name]%s[url]%s[route1]%s[route2]...' where requests to the routes are proxied to the url instead of the API URL", UpstreamNameSepChar, RouteSepChar, PatternSepChar),
+				EnvVars:     []string{"UPSTREAMS"},
+			},
 			&cli.PathFlag{
 				Destination: &args.logFilePath,
 				Name:        "logfile",
@@ -232,6 +630,55 @@ func CreateConfFromCli() (*config.Config, error) {
 				Usage:       "the `FILEPATH` to the log file to use for persistent logs. Omit this to output logs to stdout",
 				EnvVars:     []string{"LOGFILE_PATH", "LOGFILE"},
 			},
+			&cli.Uint64Flag{
+				Destination: &args.logMaxSize,
+				Name:        "log-max-size",
+				Usage:       "the `MEGABYTES` the log file can reach before it is rotated. Omit this to disable size-based rotation",
+				EnvVars:     []string{"LOG_MAX_SIZE"},
+			},
+			&cli.DurationFlag{
+				Destination: &args.logMaxAge,
+				Name:        "log-max-age",
+				Usage:       "the `DURATION` (e.g. 24h) the log file is written to before it is rotated. Omit this to disable age-based rotation",
+				EnvVars:     []string{"LOG_MAX_AGE"},
+			},
+			&cli.UintFlag{
+				Destination: &args.logMaxBackups,
+				Name:        "log-max-backups",
+				Usage:       "the `NUMBER` of rotated log files to keep. Omit this to keep all rotated log files",
+				EnvVars:     []string{"LOG_MAX_BACKUPS"},
+			},
+			&cli.BoolFlag{
+				Destination: &args.logCompress,
+				Name:        "log-compress",
+				Usage:       "gzip rotated log files",
+				EnvVars:     []string{"LOG_COMPRESS"},
+			},
+			&cli.StringFlag{
+				Destination: &args.accessLogPath,
+				Name:        "access-log",
+				Usage:       "the `FILEPATH` to an access log with a line for every request. Use 'stdout' or 'stderr' to write to those streams instead",
+				EnvVars:     []string{"ACCESS_LOG_PATH", "ACCESS_LOG"},
+			},
+			&cli.StringFlag{
+				Destination: &args.accessFormat,
+				Name:        "access-log-format",
+				Usage:       "the `FORMAT` of the access log. Valid formats are 'common', 'combined', and 'json'",
+				EnvVars:     []string{"ACCESS_LOG_FORMAT"},
+			},
+			&cli.BoolFlag{
+				Destination: &args.watchConfig,
+				Name:        "watch",
+				Aliases:     []string{"w"},
+				Usage:       "reload the configuration when the config file changes (it is always reloaded on SIGHUP)",
+				EnvVars:     []string{"WATCH_CONFIG"},
+			},
+			&cli.DurationFlag{
+				Destination: &args.shutdownTimeout,
+				Name:        "shutdown-timeout",
+				Usage:       "the `DURATION` (e.g. 30s) in-flight requests are given to finish when the server is shut down",
+				EnvVars:     []string{"SHUTDOWN_TIMEOUT"},
+			},
 			&cli.StringSliceFlag{
 				Destination: &args.cacheGET,
 				Name:        "cache:GET",
@@ -246,6 +693,25 @@ func CreateConfFromCli() (*config.Config, error) {
 				Usage:       "the list of `PATHS` to cache on HEAD requests",
 				EnvVars:     []string{"CACHE_HEAD"},
 			},
+			&cli.StringSliceFlag{
+				Destination: &args.cachePOST,
+				Name:        "cache:POST",
+				Aliases:     []string{"c:POST", "c:post", "c:p"},
+				Usage:       "the list of `PATHS` to cache on POST requests, which are cached by their body as well as their path",
+				EnvVars:     []string{"CACHE_POST"},
+			},
+			&cli.Uint64Flag{
+				Destination: &args.cacheBodyLimit,
+				Name:        "cache-body-limit",
+				Usage:       "the `SIZE` in kilobytes that the body of a POST request to a cached path can have, larger requests are not cached (default: 64)",
+				EnvVars:     []string{"CACHE_BODY_LIMIT"},
+			},
+			&cli.DurationFlag{
+				Destination: &args.cacheTTL,
+				Name:        "cache-ttl",
+				Usage:       "the `DURATION` (e.g. 5m) entries are fresh for, after which they are revalidated with the API or fetched again (default: entries never expire)",
+				EnvVars:     []string{"CACHE_TTL"},
+			},
 			&cli.StringSliceFlag{
 				Destination: &args.bustGET,
 				Name:        "bust:GET",
@@ -311,6 +777,124 @@ func CreateConfFromCli() (*config.Config, error) {
 			},
 		},
 
+		// Record the flags whose zero value is a valid setting, so it can be told apart from a flag that was not given
+		Before: func(c *cli.Context) error {
+			args.shutdownTimeSet = c.IsSet("shutdown-timeout")
+			return nil
+		},
+
+		Commands: []*cli.Command{
+			{
+				Name:  "print",
+				Usage: "print the configuration after all config files, environment variables, and flags have been resolved, then exit",
+				Description: "Loads and validates the configuration exactly like the cache server does on startup, including all config files that are extended and included, " +
+					"and prints it as a table. Global flags must come before the command, e.g. 'cache-me-ousside --config ./config.json print'.",
+				Action: func(c *cli.Context) error {
+					printConf, err := args.createConf()
+					if err != nil {
+						return err
+					}
+
+					fmt.Fprintln(c.App.Writer, printConf.String())
+
+					return nil
+				},
+			},
+			{
+				Name:  "validate",
+				Usage: "check the configuration for errors and likely mistakes without starting the cache server, then exit",
+				Description: "Loads and validates the configuration like on startup, then checks that all bust patterns are valid regular expressions " +
+					"that only use params of their route, and warns about bust patterns that cannot match any cached route and about routes that shadow or overlap each other. " +
+					"Exits with a non-zero status if the configuration has errors, so it can be used to check a configuration before it is deployed. " +
+					"Global flags must come before the command, e.g. 'cache-me-ousside --config ./config.json validate'.",
+				Action: func(c *cli.Context) error {
+					validateConf, err := args.createConf()
+					if err != nil {
+						return err
+					}
+
+					errorCount := 0
+					issues := validateConf.Check()
+					for _, issue := range issues {
+						if issue.Severity == config.SeverityError {
+							errorCount++
+						}
+
+						fmt.Fprintln(c.App.Writer, issue)
+					}
+
+					if errorCount > 0 {
+						return fmt.Errorf("the configuration has %d error(s) and %d warning(s)", errorCount, len(issues)-errorCount)
+					}
+
+					fmt.Fprintf(c.App.Writer, "the configuration is valid with %d warning(s)\n", len(issues))
+
+					return nil
+				},
+			},
+			{
+				Name:      "explain",
+				Usage:     "show how a request would be handled: its cache key, whether it is cached, and which bust patterns run on it, then exit",
+				ArgsUsage: "METHOD URL",
+				Description: "Routes the request like the cache server would, without proxying it to the API, and prints the cache key of the request, " +
+					"the cached route that handles it (if any), and every busting route that matches it with its patterns after the route params of the request have been inserted, " +
+					"along with the cached routes whose entries each pattern could match. " +
+					"Global flags must come before the command, and the flags of the command before the method, e.g. 'cache-me-ousside --config ./config.json explain -H \"X-Tenant: acme\" PUT /posts/12'.",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:    "header",
+						Aliases: []string{"H"},
+						Usage:   "a `HEADER` of the request (e.g. \"X-Tenant: acme\"), which is used in key templates. Can be used multiple times",
+					},
+					&cli.StringSliceFlag{
+						Name:  "cookie",
+						Usage: "a `COOKIE` of the request (e.g. lang=da), which is used in key templates. Can be used multiple times",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 2 {
+						return errors.New("explain takes a method and a URL, e.g. 'explain PUT /posts/12'")
+					}
+
+					header, err := parseExplainHeader(c.StringSlice("header"), c.StringSlice("cookie"))
+					if err != nil {
+						return err
+					}
+
+					explainConf, err := args.createConf()
+					if err != nil {
+						return err
+					}
+
+					explanation, err := router.Explain(explainConf, strings.ToUpper(c.Args().Get(0)), c.Args().Get(1), header)
+					if err != nil {
+						return err
+					}
+
+					fmt.Fprintln(c.App.Writer, explanation.String())
+
+					return nil
+				},
+			},
+			{
+				Name:  "schema",
+				Usage: "print the JSON Schema of config files, then exit",
+				Description: "The schema is generated from the configuration options and their validation rules. " +
+					"Save it and point to it with \"$schema\" (or a '# yaml-language-server: $schema=' comment in YAML files) to get completion and validation in editors, " +
+					"or use it to validate config files in CI, e.g. 'cache-me-ousside schema > config.schema.json'.",
+				Action: func(c *cli.Context) error {
+					schema, err := config.Schema()
+					if err != nil {
+						return err
+					}
+
+					_, err = c.App.Writer.Write(schema)
+
+					return err
+				},
+			},
+		},
+
 		Action: func(c *cli.Context) error {
 			if c.NArg() > 0 {
 				return errors.New("no arguments should be passed to CLI. Did you mean pass a configuration file path with --config?")
@@ -318,20 +902,15 @@ func CreateConfFromCli() (*config.Config, error) {
 
 			var err error
 
-			// If a config path option was passed, initialize config from that file
-			if args.configPath != "" {
-				conf, err = config.LoadJSON(args.configPath)
-				if err != nil {
-					return err
-				}
-
-			} else {
-				conf = config.New()
+			conf, err = args.createConf()
+			if err != nil {
+				return err
 			}
 
-			// Add / overwrite cli arguments to config
-			// will also trim and validate config
-			return args.addToConfig(conf) // return an err or nil
+			// Remember the arguments so the config can be created again when it is reloaded
+			startupArgs = &args
+
+			return nil
 		},
 	}
 
@@ -344,6 +923,52 @@ func CreateConfFromCli() (*config.Config, error) {
 	return conf, nil
 }
 
+// ReloadConf creates the Config again from the config file and the cli arguments and environment variables
+// that were passed at startup. This lets the config file change while the cache server is running.
+// The new Config is validated, just like the one returned by CreateConfFromCli.
+func ReloadConf() (*config.Config, error) {
+	if startupArgs == nil || startupArgs.configPath == "" {
+		return nil, errors.New("the configuration cannot be reloaded, since no config file was provided with --config")
+	}
+
+	return startupArgs.createConf()
+}
+
+// ConfigPath returns the path of the config file that was provided at startup, or an empty string if there is none.
+func ConfigPath() string {
+	if startupArgs == nil {
+		return ""
+	}
+
+	return startupArgs.configPath
+}
+
+// createConf creates a Config from the config file (if any) with the cli arguments added on top.
+// The Config is trimmed and validated.
+func (a *cliArgs) createConf() (*config.Config, error) {
+	var conf *config.Config
+	var err error
+
+	// If a config path option was passed, initialize config from that file
+	if a.configPath != "" {
+		conf, err = config.Load(a.configPath, config.LoadOptions{AllowUnknownProps: a.allowUnknown, Format: a.configFormat})
+		if err != nil {
+			return nil, err
+		}
+
+	} else {
+		conf = config.New()
+	}
+
+	// Add / overwrite cli arguments to config
+	// will also trim and validate config
+	if err := a.addToConfig(conf); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
 // parseAndSetBustArgs will parse / deserialize cli bust configuration args for a method and add them to the Config.
 func parseAndSetBustArgs(c *config.Config, method, args string) error {
 	// All busting args must have an arrow (=>) to separate the route from the busting pattern
@@ -375,7 +1000,65 @@ func parseAndSetBustArgs(c *config.Config, method, args string) error {
 	return nil
 }
 
+// parseAndSetUpstreamArgs parses an upstream cli argument in the format '[name]=[url]=>[route1]||[route2]...'
+// and sets the upstream on the config, replacing any upstream with the same name from the config file.
+func parseAndSetUpstreamArgs(c *config.Config, args string) error {
+	nameAndRest := strings.SplitN(args, UpstreamNameSepChar, 2)
+	if len(nameAndRest) != 2 || nameAndRest[0] == "" {
+		return newParseUpstreamArgError(args)
+	}
+
+	// The url can contain = in its query string, but not =>
+	urlAndRoutes := strings.Split(nameAndRest[1], RouteSepChar)
+	if len(urlAndRoutes) != 2 || urlAndRoutes[0] == "" || urlAndRoutes[1] == "" {
+		return newParseUpstreamArgError(args)
+	}
+
+	if c.Upstreams == nil {
+		c.Upstreams = make(config.UpstreamMap)
+	}
+
+	c.Upstreams[nameAndRest[0]] = config.Upstream{
+		Url:    urlAndRoutes[0],
+		Routes: strings.Split(urlAndRoutes[1], PatternSepChar),
+	}
+
+	return nil
+}
+
+// newParseUpstreamArgError returns a helpful error message if the upstream cli argument is invalid.
+func newParseUpstreamArgError(args string) error {
+	return fmt.Errorf("invalid upstream argument: %q.\nArgument must be in the format '[name]%s[url]%s[route]%s[route]...'", args, UpstreamNameSepChar, RouteSepChar, PatternSepChar)
+}
+
 // newParseBustArgError returns a helpful error message if the bust cli argument is invalid.
 func newParseBustArgError(method, args string) error {
 	return fmt.Errorf("invalid %s bust argument: %q.\nArgument must be in the format '[route]%s[regex-pattern]%s[regex-pattern]...'", method, args, RouteSepChar, PatternSepChar)
 }
+
+// parseExplainHeader returns the header of a request to explain from the header arguments in the format '[name]: [value]'
+// and the cookie arguments in the format '[name]=[value]', which are sent in one Cookie header.
+func parseExplainHeader(headers, cookies []string) (http.Header, error) {
+	header := make(http.Header)
+
+	for _, arg := range headers {
+		name, value, found := strings.Cut(arg, ":")
+		if !found || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header argument: %q.\nArgument must be in the format '[name]: [value]'", arg)
+		}
+
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	for _, arg := range cookies {
+		if name, _, found := strings.Cut(arg, "="); !found || name == "" {
+			return nil, fmt.Errorf("invalid cookie argument: %q.\nArgument must be in the format '[name]=[value]'", arg)
+		}
+
+	}
+	if len(cookies) > 0 {
+		header.Set("Cookie", strings.Join(cookies, "; "))
+	}
+
+	return header, nil
+}
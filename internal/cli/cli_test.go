@@ -4,6 +4,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/magnus-bb/cache-me-ousside/internal/config"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -86,6 +87,182 @@ func TestFlagsOverwriteConfigFile(t *testing.T) {
 	assert.Equal("https://test.com", conf.ApiUrl, "Expected the passed flag (--api-url) to overwrite the prop (apiUrl) specified in the config file, but got %q", conf.ApiUrl)
 }
 
+func TestReloadConf(t *testing.T) {
+	assert := assert.New(t)
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	os.Args = []string{"cmd", "--config", "./testdata/test.config.json", "--api-url", "https://test.com"}
+
+	conf, _ := CreateConfFromCli()
+	reloadedConf, err := ReloadConf()
+
+	assert.NoError(err, "Expected the config to be reloaded without any errors")
+	assert.Equal(conf, reloadedConf, "Expected the reloaded config to be created from the same config file and flags as the original config")
+	assert.Equal("./testdata/test.config.json", ConfigPath(), "Expected ConfigPath to return the path passed with --config")
+}
+
+func TestZeroShutdownTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	os.Args = []string{"cmd", "--config", "./testdata/test.config.json"}
+	conf, _ := CreateConfFromCli()
+	assert.Equal(config.DefaultShutdownTimeout, conf.ShutdownTimeout, "Expected the default shutdown timeout when the flag is not given")
+
+	os.Args = []string{"cmd", "--config", "./testdata/test.config.json", "--shutdown-timeout", "0s"}
+	conf, _ = CreateConfFromCli()
+	assert.Equal(config.Duration(0), conf.ShutdownTimeout, "Expected --shutdown-timeout 0s to overwrite the shutdown timeout")
+
+	reloadedConf, _ := ReloadConf()
+	assert.Equal(config.Duration(0), reloadedConf.ShutdownTimeout, "Expected --shutdown-timeout 0s to be kept when the config is reloaded")
+}
+
+func TestAllowUnknownProps(t *testing.T) {
+	assert := assert.New(t)
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	os.Args = []string{"cmd", "--config", "./testdata/unknown-props.config.json"}
+
+	_, err := CreateConfFromCli()
+
+	assert.Error(err, "Expected unknown properties in the config file to return an error")
+
+	os.Args = []string{"cmd", "--config", "./testdata/unknown-props.config.json", "--allow-unknown-props"}
+
+	conf, err := CreateConfFromCli()
+
+	assert.NoError(err, "Expected unknown properties in the config file to be ignored with --allow-unknown-props")
+	assert.Equal([]string{"/posts"}, conf.Cache["GET"], "Expected the known props to be loaded with --allow-unknown-props")
+}
+
+func TestConfigFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	os.Args = []string{"cmd", "--config", "./testdata/yaml.config.conf", "--config-format", "yaml"}
+
+	conf, err := CreateConfFromCli()
+
+	if assert.NoError(err, "Expected a YAML config file to be loaded with --config-format yaml") {
+		assert.EqualValues(555, conf.Capacity, "Expected the prop 'capacity' to set conf.Capacity to 555, got %d", conf.Capacity)
+		assert.Equal([]string{"/posts"}, conf.Cache["GET"], "Expected the prop cache.GET to set conf.Cache[\"GET\"] to %v, got %v", []string{"/posts"}, conf.Cache["GET"])
+	}
+}
+
+func TestPrintCommand(t *testing.T) {
+	assert := assert.New(t)
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	os.Args = []string{"cmd", "--config", "./testdata/test.config.json", "print"}
+
+	conf, err := CreateConfFromCli()
+
+	assert.NoError(err, "Expected the print command to load the configuration without errors")
+	assert.Nil(conf, "Expected no Config to be returned when the print command is run instead of the cache server")
+}
+
+func TestUpstreamArgs(t *testing.T) {
+	assert := assert.New(t)
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	os.Args = []string{"cmd",
+		"--api-url", "https://posts.example.com",
+		"--upstream", "users=https://users.example.com/?version=2=>/users||/users/*",
+		"--cache:GET", "/posts",
+	}
+
+	conf, err := CreateConfFromCli()
+
+	if assert.NoError(err, "Expected an upstream argument to be parsed without errors") {
+		expected := config.Upstream{Url: "https://users.example.com/?version=2", Routes: []string{"/users", "/users/*"}}
+		assert.Equal(expected, conf.Upstreams["users"], "Expected the upstream to be parsed from the name, url, and routes")
+	}
+
+	os.Args = []string{"cmd",
+		"--api-url", "https://posts.example.com",
+		"--upstream", "https://users.example.com=>/users",
+		"--cache:GET", "/posts",
+	}
+
+	_, err = CreateConfFromCli()
+
+	assert.Error(err, "Expected an upstream argument without a name to return an error")
+}
+
+func TestValidateCommand(t *testing.T) {
+	assert := assert.New(t)
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	os.Args = []string{"cmd", "--config", "./testdata/test.config.json", "validate"}
+
+	conf, err := CreateConfFromCli()
+
+	assert.NoError(err, "Expected the validate command to succeed for a valid configuration")
+	assert.Nil(conf, "Expected no Config to be returned when the validate command is run instead of the cache server")
+
+	os.Args = []string{"cmd", "--config", "../config/testdata/check.config.json", "validate"}
+
+	_, err = CreateConfFromCli()
+
+	if assert.Error(err, "Expected the validate command to fail for a configuration with errors") {
+		assert.Equal("the configuration has 2 error(s) and 4 warning(s)", err.Error())
+	}
+}
+
+func TestExplainCommand(t *testing.T) {
+	assert := assert.New(t)
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	os.Args = []string{"cmd", "--config", "./testdata/test.config.json", "explain", "put", "/posts/12"}
+
+	conf, err := CreateConfFromCli()
+
+	assert.NoError(err, "Expected the explain command to accept a lowercase method and a path")
+	assert.Nil(conf, "Expected no Config to be returned when the explain command is run instead of the cache server")
+
+	os.Args = []string{"cmd", "--config", "./testdata/test.config.json", "explain", "/posts/12"}
+
+	_, err = CreateConfFromCli()
+
+	assert.Error(err, "Expected the explain command to require both a method and a URL")
+
+	os.Args = []string{"cmd", "--config", "./testdata/test.config.json", "explain", "FETCH", "/posts/12"}
+
+	_, err = CreateConfFromCli()
+
+	assert.Error(err, "Expected the explain command to reject unknown HTTP methods")
+}
+
+func TestSchemaCommand(t *testing.T) {
+	assert := assert.New(t)
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	os.Args = []string{"cmd", "schema"}
+
+	conf, err := CreateConfFromCli()
+
+	assert.NoError(err, "Expected the schema command to print the JSON Schema without a config file")
+	assert.Nil(conf, "Expected no Config to be returned when the schema command is run instead of the cache server")
+}
+
 func generateArgs() []string {
 	return []string{"cmd",
 		"--capacity", "555",
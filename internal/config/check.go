@@ -0,0 +1,351 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/magnus-bb/cache-me-ousside/cache"
+)
+
+// Severities of the Issues found by Check.
+const (
+	SeverityError   = "error"   // the Config does not work, e.g. a bust pattern that cannot be compiled
+	SeverityWarning = "warning" // the Config works, but probably not as intended
+)
+
+var (
+	// patternParamRegex matches route params like :id in bust patterns, which are replaced with the params of the busting route.
+	// Non-capturing groups like (?:...) and character classes like [[:alpha:]] are regex syntax, not params.
+	patternParamRegex = regexp.MustCompile(`(?:^|[^?\[]):(\w+)`)
+
+	// routeParamRegex matches the params and wildcards of a route, e.g. :id, :id?, *, and +.
+	routeParamRegex = regexp.MustCompile(`:\w+\??|[*+]`)
+)
+
+// exampleParamValue is used for all route params when checking which cached routes a bust pattern can match.
+const exampleParamValue = "1"
+
+// exampleSegmentValue is used for the host and all headers and cookies in the example keys of routes with a key template,
+// unless the values of a request are known.
+const exampleSegmentValue = "example"
+
+// Issue is a problem in a Config that Validate does not catch, since Validate only checks the format of each prop.
+type Issue struct {
+	Severity string // SeverityError or SeverityWarning
+	Path     string // the property path of the problem, e.g. bust.PUT["/posts/:id"][0]
+	Message  string
+}
+
+// String returns the issue as a single line, e.g.:
+//
+//	error: 'bust.POST["/posts"][0]' is not a valid regular expression: error parsing regexp: missing closing ): `^GET:/posts(`
+func (issue Issue) String() string {
+	return fmt.Sprintf("%s: '%s' %s", issue.Severity, issue.Path, issue.Message)
+}
+
+// Check looks for problems in the cached routes and bust patterns that make the Config behave differently than intended:
+// bust patterns that are not valid regular expressions or use route params that are never replaced (errors),
+// bust patterns that cannot match any cached route, and cached routes that overlap other routes (warnings).
+// Issues are returned in the order of the routes in the Config.
+func (conf Config) Check() []Issue {
+	var issues []Issue
+
+	issues = append(issues, conf.checkCachedRoutes()...)
+	issues = append(issues, conf.checkBustRoutes()...)
+
+	return issues
+}
+
+// checkCachedRoutes warns about cached routes that are shadowed by earlier cached routes of the same method.
+// Fiber hands a request to the first matching route, so a later route that only matches requests an earlier route also matches is never used.
+func (conf Config) checkCachedRoutes() []Issue {
+	var issues []Issue
+
+	for _, method := range CacheableHTTPMethods {
+		routes := conf.Cache[method]
+
+		for i, route := range routes {
+			for j, earlierRoute := range routes[:i] {
+				if !routeCovers(earlierRoute, route) {
+					continue
+				}
+
+				message := fmt.Sprintf("is shadowed by the earlier route '%s' (%s), which handles all of its requests", earlierRoute, cachePath(method, j))
+				if strings.EqualFold(strings.TrimSuffix(earlierRoute, "/"), strings.TrimSuffix(route, "/")) {
+					message = fmt.Sprintf("is the same route as %s", cachePath(method, j))
+				}
+
+				issues = append(issues, Issue{SeverityWarning, cachePath(method, i), message})
+				break
+			}
+		}
+	}
+
+	return issues
+}
+
+// checkBustRoutes checks that all bust patterns can be compiled, only use params of their route, and can match a cached route.
+// It also warns about busting routes that bust a cached route on every request to it, so the route is never read from the cache.
+func (conf Config) checkBustRoutes() []Issue {
+	var issues []Issue
+
+	for _, method := range AllHTTPMethods {
+		for _, route := range sortedRoutes(conf.Bust[method]) {
+			patterns := conf.Bust[method][route]
+			routePath := joinPropPath(joinPropPath("bust", method), route)
+
+			// Busting routes only bust the entries of their own upstream
+			upstream := conf.upstreamOf(route)
+			exampleKeys, checkMatches := conf.exampleCacheKeys(upstream)
+
+			params := make(map[string]string)
+			for _, param := range routeParamRegex.FindAllString(route, -1) {
+				if strings.HasPrefix(param, ":") {
+					params[strings.TrimSuffix(strings.TrimPrefix(param, ":"), "?")] = exampleParamValue
+				}
+			}
+
+			for i, pattern := range patterns {
+				patternPath := routePath + "[" + strconv.Itoa(i) + "]"
+
+				if _, err := regexp.Compile(pattern); err != nil {
+					issues = append(issues, Issue{SeverityError, patternPath, fmt.Sprintf("is not a valid regular expression: %v", err)})
+					continue
+				}
+
+				// Key segments like {header:X-Tenant} are replaced with the values of the busting request, so their names are not route params
+				undeclared := false
+				for _, match := range patternParamRegex.FindAllStringSubmatch(bustSegmentRegex.ReplaceAllString(pattern, ""), -1) {
+					if _, found := params[match[1]]; !found {
+						issues = append(issues, Issue{SeverityError, patternPath, fmt.Sprintf("uses the route param :%s, which is never replaced, since the route '%s' has no such param", match[1], route)})
+						undeclared = true
+					}
+				}
+				if undeclared || !checkMatches {
+					continue
+				}
+
+				hydrated := HydrateKeySegments(withExampleSegments(KeyRequest{}), cache.HydrateParams(params, []string{pattern}))
+				if !matchesAny(hydrated, exampleKeys) {
+					issues = append(issues, Issue{SeverityWarning, patternPath, "does not match any cached route, so it never busts any entries"})
+				}
+			}
+
+			issues = append(issues, conf.checkSelfBusting(method, route, routePath, upstream, params)...)
+		}
+	}
+
+	return issues
+}
+
+// checkSelfBusting warns if the busting route overlaps a cached route of the same method and upstream and busts its entries.
+// Bust routes run before cached routes, so every request to the cached route would remove its own entry first.
+func (conf Config) checkSelfBusting(method, route, routePath, upstream string, params map[string]string) []Issue {
+	var issues []Issue
+
+	patterns := HydrateKeySegments(withExampleSegments(KeyRequest{}), cache.HydrateParams(params, conf.Bust[method][route]))
+
+	for i, cachedRoute := range conf.Cache[method] {
+		if (!routeCovers(route, cachedRoute) && !routeCovers(cachedRoute, route)) || conf.upstreamOf(cachedRoute) != upstream {
+			continue
+		}
+
+		if len(patterns) > 0 && !matchesAny(patterns, []string{conf.exampleKey(method, cachedRoute, nil, KeyRequest{})}) {
+			continue // an empty list of patterns busts all entries
+		}
+
+		message := fmt.Sprintf("busts the entries of the cached route '%s' (%s) on requests to it, so they are never read from the cache", cachedRoute, cachePath(method, i))
+		issues = append(issues, Issue{SeverityWarning, routePath, message})
+	}
+
+	return issues
+}
+
+// exampleCacheKeys returns an example cache entry key (without the upstream) for every cached route of the upstream,
+// where all route params have the same value, and so do all key segments.
+// It returns false if any of the cached routes has a wildcard, since any pattern might match the entries of that route.
+func (conf Config) exampleCacheKeys(upstream string) ([]string, bool) {
+	var keys []string
+
+	for _, method := range CacheableHTTPMethods {
+		for _, route := range conf.Cache[method] {
+			if conf.upstreamOf(route) != upstream {
+				continue
+			}
+
+			if strings.ContainsAny(route, "*+") {
+				return nil, false
+			}
+
+			keys = append(keys, conf.exampleKey(method, route, nil, KeyRequest{}))
+		}
+	}
+
+	return keys, true
+}
+
+// CachedRoutesMatching returns the cached routes of the upstream (empty for the ApiUrl), e.g. "GET /posts/:id", whose entries the bust pattern
+// could match, after the pattern has been hydrated with the route params and key segments of a request. It is an estimate made with an example entry key
+// for every cached route, where each route param has the value of the param with the same name in params, or "1" if there is none,
+// and the host, headers, and cookies of key templates are those of segments, or "example" if segments does not have them.
+// An error is returned if the pattern is not a valid regular expression.
+func (conf Config) CachedRoutesMatching(upstream, pattern string, params map[string]string, segments KeyRequest) ([]string, error) {
+	patternExp, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []string
+	for _, method := range CacheableHTTPMethods {
+		for _, route := range conf.Cache[method] {
+			if conf.upstreamOf(route) == upstream && patternExp.MatchString(conf.exampleKey(method, route, params, segments)) {
+				routes = append(routes, method+" "+route)
+			}
+		}
+	}
+
+	return routes, nil
+}
+
+// upstreamOf returns the name of the upstream that requests to the route are proxied to, or an empty string for the ApiUrl.
+// It is an estimate made with an example URL of the route, where all route params and wildcards have the value "1".
+func (conf Config) upstreamOf(route string) string {
+	exampleURL := routeParamRegex.ReplaceAllString(route, exampleParamValue)
+
+	for _, name := range conf.UpstreamNames() {
+		for _, upstreamRoute := range conf.Upstreams[name].Routes {
+			if routeMatcher(upstreamRoute).MatchString(exampleURL) {
+				return name
+			}
+		}
+	}
+
+	return ""
+}
+
+// exampleKey returns an example cache entry key for the cached route, made by the KeyPolicy of the route like the key of a request.
+// Named route params have the value of the param with the same name in params, and other params and wildcards have the value "1".
+// The host, headers, and cookies of key templates are those of segments, or "example" if segments does not have them.
+func (conf Config) exampleKey(method, route string, params map[string]string, segments KeyRequest) string {
+	request := withExampleSegments(segments)
+	request.Method = method
+	request.URL = routeParamRegex.ReplaceAllStringFunc(route, func(param string) string {
+		if value, found := params[strings.TrimSuffix(strings.TrimPrefix(param, ":"), "?")]; found {
+			return value
+		}
+
+		return exampleParamValue
+	})
+
+	return conf.keyPolicyOf(request.URL).Key(request)
+}
+
+// keyPolicyOf returns the KeyPolicy of the first key policy route that matches the URL, like the router picks it,
+// or the zero KeyPolicy if no route matches.
+func (conf Config) keyPolicyOf(exampleURL string) KeyPolicy {
+	for _, route := range conf.KeyPolicyRoutes() {
+		if routeMatcher(route).MatchString(exampleURL) {
+			return conf.KeyPolicies[route]
+		}
+	}
+
+	return KeyPolicy{}
+}
+
+// withExampleSegments returns the request with "example" as its host, and as the value of its headers and cookies, if it does not have them.
+func withExampleSegments(request KeyRequest) KeyRequest {
+	exampleSegment := func(string) string {
+		return exampleSegmentValue
+	}
+
+	if request.Host == "" {
+		request.Host = exampleSegmentValue
+	}
+	if request.Header == nil {
+		request.Header = exampleSegment
+	}
+	if request.Cookie == nil {
+		request.Cookie = exampleSegment
+	}
+
+	return request
+}
+
+// matchesAny returns true if any of the patterns match any of the keys.
+// Patterns that cannot be compiled are skipped, since they are reported on their own.
+func matchesAny(patterns, keys []string) bool {
+	for _, pattern := range patterns {
+		patternExp, err := regexp.Compile(pattern)
+		if err != nil {
+			continue
+		}
+
+		for _, key := range keys {
+			if patternExp.MatchString(key) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// routeCovers returns true if fiber would hand every request that matches route to coveringRoute as well.
+// Params of route are kept as they are written, so they are only matched by params and wildcards of coveringRoute.
+func routeCovers(coveringRoute, route string) bool {
+	exampleURL := strings.NewReplacer("*", "*/*", "+", "+/+").Replace(route)
+
+	return routeMatcher(coveringRoute).MatchString(exampleURL)
+}
+
+// routeMatcher returns a regular expression that matches the same paths as the fiber route.
+// Like fiber's default settings, it is case-insensitive and ignores a trailing slash.
+func routeMatcher(route string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("(?i)^")
+
+	for i := 0; i < len(route); {
+		param := routeParamRegex.FindStringIndex(route[i:])
+		if param == nil || param[0] != 0 {
+			expr.WriteString(regexp.QuoteMeta(route[i : i+1]))
+			i++
+			continue
+		}
+
+		switch token := route[i : i+param[1]]; {
+		case token == "*":
+			expr.WriteString(".*")
+		case token == "+":
+			expr.WriteString(".+")
+		case strings.HasSuffix(token, "?"):
+			expr.WriteString("[^/]*")
+		default:
+			expr.WriteString("[^/]+")
+		}
+
+		i += param[1]
+	}
+
+	expr.WriteString("/?$")
+
+	return regexp.MustCompile(expr.String())
+}
+
+// cachePath returns the property path of the cached route at index i of method, e.g. cache.GET[0].
+func cachePath(method string, i int) string {
+	return joinPropPath("cache", method) + "[" + strconv.Itoa(i) + "]"
+}
+
+// sortedRoutes returns the busting routes of a method in alphabetical order, so issues are always reported in the same order.
+func sortedRoutes(routes map[string][]string) []string {
+	sorted := make([]string, 0, len(routes))
+	for route := range routes {
+		sorted = append(sorted, route)
+	}
+	sort.Strings(sorted)
+
+	return sorted
+}
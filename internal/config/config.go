@@ -3,11 +3,13 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 
@@ -20,6 +22,28 @@ const (
 	DefaultCapacity uint64 = 500
 	DefaultHostname string = "localhost"
 	DefaultPort     uint   = 8080
+
+	DefaultAccessLogFormat string   = "common"
+	DefaultShutdownTimeout Duration = Duration(10 * time.Second)
+
+	DefaultBalance             string   = "round-robin"
+	DefaultHealthCheckInterval Duration = Duration(10 * time.Second)
+	DefaultHealthCheckTimeout  Duration = Duration(2 * time.Second)
+	DefaultHealthyThreshold    uint     = 2
+	DefaultUnhealthyThreshold  uint     = 3
+
+	DefaultBreakerOpenTimeout      Duration = Duration(30 * time.Second)
+	DefaultBreakerHalfOpenRequests uint     = 1
+	DefaultBreakerWhileOpen        string   = "fail"
+
+	DefaultDialTimeout  Duration = Duration(3 * time.Second)
+	DefaultRetryBackoff Duration = Duration(100 * time.Millisecond)
+
+	DefaultCacheBodyLimit uint64 = 64
+
+	DefaultIdempotencyMaxKeys      uint     = 10000
+	DefaultIdempotencyWait         Duration = Duration(30 * time.Second)
+	DefaultIdempotencyClientHeader string   = "Authorization"
 )
 
 var (
@@ -28,10 +52,15 @@ var (
 	AllHTTPMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "PATCH", "TRACE", "CONNECT", "OPTIONS"}
 
 	// CacheableHTTPMethods is a slice of all cacheable http methods that can be used in the cache configuration for caching.
-	// 	{"GET", "HEAD"}
-	CacheableHTTPMethods = AllHTTPMethods[0:2]
+	// POST requests are cached by their body as well as their route, see BodyKeyedHTTPMethods.
+	// 	{"GET", "HEAD", "POST"}
+	CacheableHTTPMethods = AllHTTPMethods[0:3]
+
+	// BodyKeyedHTTPMethods are the cacheable http methods whose requests are cached under a key that includes a hash of their body.
+	// 	{"POST"}
+	BodyKeyedHTTPMethods = AllHTTPMethods[2:3]
 
-	// UncacheableHTTPMethods = AllMethods[2:] // []string{"POST", "PUT", "DELETE", "PATCH", "TRACE", "CONNECT", "OPTIONS"}
+	// UncacheableHTTPMethods = AllMethods[3:] // []string{"PUT", "DELETE", "PATCH", "TRACE", "CONNECT", "OPTIONS"}
 )
 
 type (
@@ -39,8 +68,200 @@ type (
 	BustMap map[string]map[string][]string
 	// CacheMap represents a map of http methods with slices of endpoints to which requests should be cached.
 	CacheMap map[string][]string
+	// UpstreamMap represents a map of names with the Upstream of that name.
+	UpstreamMap map[string]Upstream
 )
 
+// Upstream is a named API that requests are proxied to instead of the ApiUrl, when they match one of its routes.
+type Upstream struct {
+	// Url is the url of the API, like the ApiUrl.
+	Url string `json:"url" validate:"required,url"`
+
+	// Replicas are the urls of more servers with the same API as Url, which requests are balanced across along with Url.
+	Replicas []string `json:"replicas" validate:"omitempty,dive,url"`
+
+	// Balance is how requests are balanced across Url and Replicas, like the Balance of the Config.
+	Balance string `json:"balance" validate:"omitempty,oneof=round-robin least-connections consistent-hash"`
+
+	// HealthCheck takes Url and Replicas out of rotation when they fail and puts them back when they recover.
+	HealthCheck HealthCheck `json:"healthCheck"`
+
+	// CircuitBreaker stops requests from being proxied to the API while it keeps failing.
+	CircuitBreaker CircuitBreaker `json:"circuitBreaker"`
+
+	// Proxy sets the timeouts, connection limits, and retries of the requests that are proxied to the API.
+	Proxy ProxySettings `json:"proxy"`
+
+	// TLS sets the CAs, client certificate, and server name that are used to connect to the API over HTTPS.
+	TLS UpstreamTLS `json:"tls"`
+
+	// Routes are the routes of the requests that are proxied to the API, e.g. "/users" and "/users/*".
+	Routes []string `json:"routes" validate:"required,gt=0,dive,route"`
+}
+
+// HealthCheck describes how the servers of an API are checked. Zero values are replaced by defaults with WithDefaults.
+type HealthCheck struct {
+	// Path is requested on every server of the API, e.g. "/health". Omit it to disable health checks.
+	Path string `json:"path" validate:"omitempty,startswith=/"`
+
+	// Default is "10s", it is how long to wait between checks of a server.
+	Interval Duration `json:"interval" validate:"min=0"`
+
+	// Default is "2s", it is how long a server has to respond before the check fails.
+	Timeout Duration `json:"timeout" validate:"min=0"`
+
+	// Default is 2, it is how many checks in a row must succeed before a failing server is put back into rotation.
+	HealthyThreshold uint `json:"healthyThreshold"`
+
+	// Default is 3, it is how many checks in a row must fail before a server is taken out of rotation.
+	UnhealthyThreshold uint `json:"unhealthyThreshold"`
+}
+
+// Enabled returns true if the servers should be checked.
+func (check HealthCheck) Enabled() bool {
+	return check.Path != ""
+}
+
+// WithDefaults returns a copy of the HealthCheck where all zero values (except Path) are replaced by their defaults.
+func (check HealthCheck) WithDefaults() HealthCheck {
+	if check.Interval == 0 {
+		check.Interval = DefaultHealthCheckInterval
+	}
+	if check.Timeout == 0 {
+		check.Timeout = DefaultHealthCheckTimeout
+	}
+	if check.HealthyThreshold == 0 {
+		check.HealthyThreshold = DefaultHealthyThreshold
+	}
+	if check.UnhealthyThreshold == 0 {
+		check.UnhealthyThreshold = DefaultUnhealthyThreshold
+	}
+
+	return check
+}
+
+// CircuitBreaker describes when requests to an API fail fast instead of being proxied. It is closed while the API works,
+// opens when the API has failed FailureThreshold requests in a row, and half-opens after OpenTimeout to let HalfOpenRequests
+// requests try the API, which close it again if they all succeed. Zero values are replaced by defaults with WithDefaults.
+type CircuitBreaker struct {
+	// FailureThreshold is how many requests in a row must fail (with an error or a 5xx status) to open the breaker. Omit it to disable the breaker.
+	FailureThreshold uint `json:"failureThreshold"`
+
+	// Default is "30s", it is how long the breaker stays open before requests can try the API again.
+	OpenTimeout Duration `json:"openTimeout" validate:"min=0"`
+
+	// Default is 1, it is how many requests can try the API while the breaker is half-open, and how many must succeed to close it.
+	HalfOpenRequests uint `json:"halfOpenRequests"`
+
+	/*
+		Default is "fail", it represents how requests are handled while the breaker is open, which is either:
+			"fail": requests that need the API are answered with 503 right away
+			"stale": like "fail", but busting routes do not bust any entries, so cached responses are still served, even if they might be stale
+	*/
+	WhileOpen string `json:"whileOpen" validate:"omitempty,oneof=fail stale"`
+}
+
+// Enabled returns true if the breaker can open.
+func (breaker CircuitBreaker) Enabled() bool {
+	return breaker.FailureThreshold != 0
+}
+
+// WithDefaults returns a copy of the CircuitBreaker where all zero values (except FailureThreshold) are replaced by their defaults.
+func (breaker CircuitBreaker) WithDefaults() CircuitBreaker {
+	if breaker.OpenTimeout == 0 {
+		breaker.OpenTimeout = DefaultBreakerOpenTimeout
+	}
+	if breaker.HalfOpenRequests == 0 {
+		breaker.HalfOpenRequests = DefaultBreakerHalfOpenRequests
+	}
+	if breaker.WhileOpen == "" {
+		breaker.WhileOpen = DefaultBreakerWhileOpen
+	}
+
+	return breaker
+}
+
+// ProxySettings describe how requests are proxied to the servers of an API. Zero values are replaced by defaults with WithDefaults,
+// or mean that there is no limit.
+type ProxySettings struct {
+	// Default is "3s", it is how long connecting to a server can take.
+	DialTimeout Duration `json:"dialTimeout" validate:"min=0"`
+
+	// ReadTimeout is how long a server can take to respond, including reading the full response. Omit it to wait for as long as it takes.
+	ReadTimeout Duration `json:"readTimeout" validate:"min=0"`
+
+	// WriteTimeout is how long sending a request to a server can take. Omit it to wait for as long as it takes.
+	WriteTimeout Duration `json:"writeTimeout" validate:"min=0"`
+
+	// MaxConnsPerHost is how many connections can be open to each server at the same time. Omit it to use the default of 512.
+	MaxConnsPerHost uint `json:"maxConnsPerHost"`
+
+	// IdleConnTimeout is how long a connection can be idle before it is closed. Omit it to use the default of "10s".
+	// It takes the place of a limit on idle connections, which the fasthttp client does not have, besides MaxConnsPerHost.
+	IdleConnTimeout Duration `json:"idleConnTimeout" validate:"min=0"`
+
+	// Retries is how many times a GET or HEAD request is retried when it fails with an error or a 502, 503, or 504 status.
+	// Other methods are never retried, since they might change something on the API.
+	Retries uint `json:"retries"`
+
+	// Default is "100ms", it is how long to wait before the first retry. The wait is doubled for every retry after it.
+	RetryBackoff Duration `json:"retryBackoff" validate:"min=0"`
+}
+
+// WithDefaults returns a copy of the ProxySettings where the DialTimeout and RetryBackoff are replaced by their defaults if they are zero.
+func (settings ProxySettings) WithDefaults() ProxySettings {
+	if settings.DialTimeout == 0 {
+		settings.DialTimeout = DefaultDialTimeout
+	}
+	if settings.RetryBackoff == 0 {
+		settings.RetryBackoff = DefaultRetryBackoff
+	}
+
+	return settings
+}
+
+// Backoff returns how long to wait before retry number retry (starting from 1).
+func (settings ProxySettings) Backoff(retry uint) time.Duration {
+	return settings.RetryBackoff.Std() << (retry - 1)
+}
+
+// Idempotency describes how the responses of POST, PUT, and PATCH requests with an Idempotency-Key header are replayed to retries with the same key.
+// Zero values are replaced by defaults with WithDefaults.
+type Idempotency struct {
+	// Retention is how long a response is replayed to retries with the same key, e.g. "24h". Omit it to disable idempotency keys.
+	Retention Duration `json:"retention" validate:"min=0"`
+
+	// Default is 10000, it is how many keys are kept at the same time. Requests with new keys are proxied without being stored when there are too many.
+	MaxKeys uint `json:"maxKeys"`
+
+	// Wait is how long a retry waits for the first request with the same key to finish before it is rejected with 409 Conflict. Omit it to use the default of "30s".
+	Wait Duration `json:"wait" validate:"min=0"`
+
+	// ClientHeader is the request header that identifies a client, e.g. "Authorization", so clients cannot get the responses to each other's keys.
+	// Omit it to use the default of "Authorization".
+	ClientHeader string `json:"clientHeader"`
+}
+
+// Enabled returns true if responses should be stored under the Idempotency-Key of their requests.
+func (settings Idempotency) Enabled() bool {
+	return settings.Retention > 0
+}
+
+// WithDefaults returns the settings with zero values replaced by defaults.
+func (settings Idempotency) WithDefaults() Idempotency {
+	if settings.MaxKeys == 0 {
+		settings.MaxKeys = DefaultIdempotencyMaxKeys
+	}
+	if settings.Wait == 0 {
+		settings.Wait = DefaultIdempotencyWait
+	}
+	if settings.ClientHeader == "" {
+		settings.ClientHeader = DefaultIdempotencyClientHeader
+	}
+
+	return settings
+}
+
 // New returns a Config where Bust and Cache are initialized to empty BustMap and CacheMap respectively.
 // This is done to avoid nil pointers when accessing the nested map properties.
 func New() *Config {
@@ -55,6 +276,10 @@ func New() *Config {
 		Port:     DefaultPort,
 		Cache:    make(CacheMap),
 		Bust:     bustMap,
+
+		CacheBodyLimit:  DefaultCacheBodyLimit,
+		AccessLogFormat: DefaultAccessLogFormat,
+		ShutdownTimeout: DefaultShutdownTimeout,
 	}
 
 	return conf
@@ -62,6 +287,9 @@ func New() *Config {
 
 /*
 LoadJSON returns a Config created from unmarshaling the json file at configPath.
+The file may contain line and block comments as well as trailing commas, like JSONC.
+Properties that are not part of the Config and values of the wrong type are reported as errors,
+which point to the line and column of the problem, just like errors for files that cannot be parsed.
 It will also trim invalid http methods in the configuration as well as trailing slashes in the ApiUrl.
 Be aware that you will manually have to validate the configuration like so:
 	conf, _ := LoadJSON(configPath)
@@ -70,21 +298,47 @@ Be aware that you will manually have to validate the configuration like so:
 	}
 */
 func LoadJSON(configPath string) (*Config, error) {
-	// Read the configuration json file
-	jsonFile, err := os.Open(configPath)
+	return Load(configPath, LoadOptions{Format: FormatJSON})
+}
+
+// Load works like LoadJSON, but the config file can also be written in YAML or TOML, which is chosen by the file extension
+// or by opts.Format. All formats are decoded into the same Config, and opts can make the decoding less strict.
+// The config file can extend and include other config files, which are merged before the Config is decoded.
+func Load(configPath string, opts LoadOptions) (*Config, error) {
+	layer, err := loadLayer(configPath, opts.Format, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer jsonFile.Close()
+	resolvedJSON, positions := layer.json, layer.positions
+
+	// Populate a new config with the config file values
+	var config = New()
+	unmarshalErr := json.Unmarshal(resolvedJSON, &config)
 
-	jsonByteValue, err := ioutil.ReadAll(jsonFile)
+	// Find the property path of any type errors and the properties that are not part of the Config
+	scanner, err := scanConfig(resolvedJSON)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("could not parse config file %q: %w", configPath, err)
 	}
 
-	// Populate a new config with the json file values
-	var config = New()
-	json.Unmarshal(jsonByteValue, &config)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(unmarshalErr, &typeErr) {
+		return nil, newTypeError(configPath, scanner.locate(typeErr.Offset), typeErr, positions)
+	}
+	if unmarshalErr != nil {
+		// Errors from unmarshalers like Duration's have no offset, so the value is found by decoding the values with unmarshalers one by one
+		if propPath, err := scanner.locateUnmarshalError(); propPath != "" {
+			return nil, newValueError(configPath, propPath, err, positions)
+		}
+
+		return nil, fmt.Errorf("could not load config file %q: %w", configPath, unmarshalErr)
+	}
+
+	if len(scanner.unknown) > 0 && !opts.AllowUnknownProps {
+		return nil, newUnknownPropsError(configPath, scanner.unknown, positions)
+	}
+
+	config.sources = layer.sources
 
 	// Clean the API url
 	config.TrimTrailingSlash()
@@ -112,20 +366,96 @@ type Config struct {
 	//Default is 8080, it represents the port where the server application can be accessed. E.g.:
 	Port uint `json:"port" validate:"required,min=1,max=65535"`
 
+	// TLS serves the cache server over HTTPS with a certificate that is reloaded when it changes.
+	TLS ServerTLS `json:"tls"`
+
 	// ApiUrl is required, it represents the url of the API to which all requests are proxied and cached from.
 	ApiUrl string `json:"apiUrl" validate:"required,url"`
 
+	// ApiReplicas are the urls of more servers with the same API as ApiUrl, which requests are balanced across along with ApiUrl.
+	ApiReplicas []string `json:"apiReplicas" validate:"omitempty,dive,url"`
+
+	/*
+		Default is "round-robin", it represents how requests are balanced across the ApiUrl and ApiReplicas, which is either:
+			"round-robin": every server gets a request in turn
+			"least-connections": the server with the fewest requests in flight gets the request
+			"consistent-hash": requests with the same path always go to the same server, as long as it is healthy
+	*/
+	Balance string `json:"balance" validate:"omitempty,oneof=round-robin least-connections consistent-hash"`
+
+	// HealthCheck takes the ApiUrl and ApiReplicas out of rotation when they fail and puts them back when they recover.
+	HealthCheck HealthCheck `json:"healthCheck"`
+
+	// CircuitBreaker stops requests from being proxied to the ApiUrl and ApiReplicas while they keep failing.
+	CircuitBreaker CircuitBreaker `json:"circuitBreaker"`
+
+	// Proxy sets the timeouts, connection limits, and retries of the requests that are proxied to the ApiUrl and ApiReplicas.
+	Proxy ProxySettings `json:"proxy"`
+
+	// ApiTLS sets the CAs, client certificate, and server name that are used to connect to the ApiUrl and ApiReplicas over HTTPS.
+	ApiTLS UpstreamTLS `json:"apiTls"`
+
+	// StatsPath is an optional path, e.g. "/_stats", where the cache server responds with the state of the upstreams as JSON instead of proxying the request.
+	StatsPath string `json:"statsPath" validate:"omitempty,startswith=/"`
+
 	// LogFilePath is the path to an optional log file to use instead of stdout (terminal mode).
 	LogFilePath string `json:"logFilePath" validate:"omitempty,filepath"`
 
+	// LogMaxSize is the size in megabytes the log file can reach before it is rotated. Omit or 0 to disable size-based rotation.
+	LogMaxSize uint64 `json:"logMaxSize"`
+
+	// LogMaxAge is how long the log file is written to before it is rotated, e.g. "24h". Omit to disable age-based rotation.
+	LogMaxAge Duration `json:"logMaxAge" validate:"min=0"`
+
+	// LogMaxBackups is how many rotated log files to keep. Omit or 0 to keep all rotated log files.
+	LogMaxBackups uint `json:"logMaxBackups"`
+
+	// LogCompress will gzip rotated log files.
+	LogCompress bool `json:"logCompress"`
+
+	// AccessLogPath is the path to an optional access log file with a line for every request. Use "stdout" or "stderr" to write to those streams instead.
+	AccessLogPath string `json:"accessLogPath" validate:"omitempty,filepath"`
+
+	// Default is "common", it represents the format of the access log, which is either "common", "combined", or "json".
+	AccessLogFormat string `json:"accessLogFormat" validate:"omitempty,oneof=common combined json"`
+
+	// Default is "10s", it represents how long in-flight requests are given to finish when the server is shut down. Use "0s" to wait for as long as it takes.
+	ShutdownTimeout Duration `json:"shutdownTimeout" validate:"min=0"`
+
+	// WatchConfig will reload the configuration when the configuration file changes. It is always reloaded on SIGHUP.
+	WatchConfig bool `json:"watchConfig"`
+
 	/*
-		Cache is a map of HTTP methods with slices of endpoints to which requests should be cached. E.g.:
+		Cache is a map of HTTP methods with slices of endpoints to which requests should be cached.
+		POST requests are cached under a key with a hash of their body, so only read endpoints that take their query in the body should be listed. E.g.:
 			{
 				"GET": ["/api/v1/users/:id", "/api/v1/users/:id/posts"],
 				"HEAD": ["/api/v1/users/:id", "/api/v1/users/:id/posts"],
+				"POST": ["/api/v1/search"],
 			}
 	*/
-	Cache CacheMap `json:"cache" validate:"required,gt=0,dive,keys,oneof=GET HEAD,endkeys,dive,route"`
+	Cache CacheMap `json:"cache" validate:"required,gt=0,dive,keys,oneof=GET HEAD POST,endkeys,dive,route"`
+
+	/*
+		KeyPolicies is a map of routes with how the URLs of their requests are normalized in cache keys, so requests for the same resource share an entry,
+		and optionally a template for keys that include headers and cookies, so responses that differ by them get separate entries.
+		Busting routes use the policy of their route as well, so params inserted into bust patterns match the normalized keys. E.g.:
+			{
+				"/posts": { "sortQuery": true, "ignoreParams": ["utm_*", "_"] },
+				"/posts/:id": { "lowercasePath": true, "allowParams": ["fields"] },
+				"/tenant/*": { "template": "{method}:{path}?{query}|{header:X-Tenant}" }
+			}
+		If a request matches several routes, the first route in alphabetical order is used.
+	*/
+	KeyPolicies map[string]KeyPolicy `json:"keyPolicies" validate:"omitempty,dive,keys,route,endkeys,required"`
+
+	// Default is 64, it is the size in kilobytes that the body of a POST request to a cached route can have. Larger requests are proxied without being cached.
+	// A limit of 0 uses the default.
+	CacheBodyLimit uint64 `json:"cacheBodyLimit"`
+
+	// CacheTTL is how long entries are fresh after they are saved, e.g. "5m". Expired entries with an ETag or Last-Modified header are revalidated with the API,
+	// and their lifetime is refreshed if they are unchanged, while other expired entries are fetched again. Default is "0s", where entries never expire.
+	CacheTTL Duration `json:"cacheTtl" validate:"min=0"`
 
 	/*
 		Bust is a map of HTTP methods with maps of endpoints with slices of patterns to match to cache entries to bust. E.g.:
@@ -140,6 +470,34 @@ type Config struct {
 			}
 	*/
 	Bust BustMap `json:"bust" validate:"omitempty,dive,keys,oneof=GET HEAD POST PUT DELETE PATCH TRACE CONNECT OPTIONS,endkeys,dive,keys,route"`
+
+	// Idempotency replays the responses of POST, PUT, and PATCH requests to retries with the same Idempotency-Key header.
+	Idempotency Idempotency `json:"idempotency"`
+
+	// GraphQL caches queries to GraphQL endpoints by their body and lets mutations bust them by operation or type name.
+	GraphQL GraphQL `json:"graphql"`
+
+	/*
+		Upstreams is a map of names with APIs that requests are proxied to instead of the ApiUrl when they match one of the routes of the API.
+		Cached and busting routes use the upstream of the requests they handle, and cache entries from an upstream
+		are saved under keys that start with its name, like "users|GET:/users/1", so identical paths on different APIs do not collide. E.g.:
+			{
+				"users": {
+					"url": "https://users.example.com",
+					"routes": [ "/users", "/users/*" ]
+				}
+			}
+	*/
+	Upstreams UpstreamMap `json:"upstreams" validate:"omitempty,dive,keys,upstreamname,endkeys,required"`
+
+	// sources are the paths of the config files the Config was loaded from, in the order they were merged.
+	sources []string
+}
+
+// Sources returns the paths of the config files the Config was loaded from, including the files that were extended and included,
+// in the order they were merged. It is empty if the Config was not loaded from a file.
+func (conf Config) Sources() []string {
+	return conf.sources
 }
 
 /*
@@ -175,6 +533,15 @@ func (conf Config) Address() string {
 	return fmt.Sprintf("%s:%d", conf.Hostname, conf.Port)
 }
 
+// CacheBodyLimitBytes returns the size in bytes that the body of a POST request to a cached route can have.
+func (conf Config) CacheBodyLimitBytes() int {
+	if conf.CacheBodyLimit == 0 {
+		return int(DefaultCacheBodyLimit * cache.KB)
+	}
+
+	return int(conf.CacheBodyLimit * cache.KB)
+}
+
 // LogModeString returns a human-readable string representation of how logging is configured.
 // It will be either a log file path or "terminal mode"
 func (conf Config) LogModeString() string {
@@ -185,10 +552,184 @@ func (conf Config) LogModeString() string {
 	return "terminal mode"
 }
 
-// TrimTrailingSlash mutates the ApiUrl to remove any trailing slashes.
+// AccessLogString returns a human-readable string representation of where and how the access log is written.
+func (conf Config) AccessLogString() string {
+	if conf.AccessLogPath == "" {
+		return "disabled"
+	}
+
+	return fmt.Sprintf("%s (%s)", conf.AccessLogPath, conf.AccessLogFormat)
+}
+
+// LogRotation returns the logger.RotationConfig described by the log rotation props.
+func (conf Config) LogRotation() logger.RotationConfig {
+	return logger.RotationConfig{
+		MaxSize:    conf.LogMaxSize * cache.MB,
+		MaxAge:     conf.LogMaxAge.Std(),
+		MaxBackups: conf.LogMaxBackups,
+		Compress:   conf.LogCompress,
+	}
+}
+
+// LogRotationString returns a human-readable string representation of how the log file is rotated.
+func (conf Config) LogRotationString() string {
+	var limits []string
+
+	if conf.LogMaxSize != 0 {
+		limits = append(limits, fmt.Sprintf("every %dMB", conf.LogMaxSize))
+	}
+	if conf.LogMaxAge != 0 {
+		limits = append(limits, "every "+conf.LogMaxAge.String())
+	}
+
+	if len(limits) == 0 {
+		return "never"
+	}
+
+	rotation := strings.Join(limits, " or ")
+
+	if conf.LogMaxBackups != 0 {
+		rotation += fmt.Sprintf(", keep %d", conf.LogMaxBackups)
+	}
+	if conf.LogCompress {
+		rotation += ", gzip"
+	}
+
+	return rotation
+}
+
+// RestartRequiredChanges returns the names of the props that differ between conf and newConf,
+// but only take effect when the server is restarted. Everything else can be changed by reloading the configuration.
+// conf should be the configuration the server was started with, so changes are reported on every reload until the server is restarted.
+func (conf Config) RestartRequiredChanges(newConf *Config) []string {
+	var changed []string
+
+	if conf.Capacity != newConf.Capacity || !strings.EqualFold(conf.CapacityUnit, newConf.CapacityUnit) {
+		changed = append(changed, "capacity")
+	}
+	if conf.Address() != newConf.Address() {
+		changed = append(changed, "address")
+	}
+	if !reflect.DeepEqual(conf.TLS, newConf.TLS) { // the contents of the certificate files are reloaded when they change
+		changed = append(changed, "tls")
+	}
+	if conf.ShutdownTimeout != newConf.ShutdownTimeout {
+		changed = append(changed, "shutdown timeout")
+	}
+	if conf.LogFilePath != newConf.LogFilePath || conf.LogRotation() != newConf.LogRotation() {
+		changed = append(changed, "log file")
+	}
+	if conf.AccessLogPath != newConf.AccessLogPath || conf.AccessLogFormat != newConf.AccessLogFormat {
+		changed = append(changed, "access log")
+	}
+	if conf.WatchConfig != newConf.WatchConfig {
+		changed = append(changed, "config watching")
+	}
+
+	return changed
+}
+
+// TrimTrailingSlash mutates the ApiUrl, the ApiReplicas, and the urls of the Upstreams to remove any trailing slashes.
 // This is useful so all specified endpoints and patterns can begin with a slash.
 func (conf *Config) TrimTrailingSlash() {
 	conf.ApiUrl = strings.TrimSuffix(conf.ApiUrl, "/")
+	conf.ApiReplicas = trimTrailingSlashes(conf.ApiReplicas)
+
+	for name, upstream := range conf.Upstreams {
+		upstream.Url = strings.TrimSuffix(upstream.Url, "/")
+		upstream.Replicas = trimTrailingSlashes(upstream.Replicas)
+		conf.Upstreams[name] = upstream
+	}
+}
+
+// UpstreamNames returns the names of the Upstreams in alphabetical order,
+// which is the order their routes are matched in, when a request matches the routes of several upstreams.
+func (conf Config) UpstreamNames() []string {
+	names := make([]string, 0, len(conf.Upstreams))
+	for name := range conf.Upstreams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// KeyPolicyRoutes returns the routes of the KeyPolicies in alphabetical order, which is the order they are matched in.
+func (conf Config) KeyPolicyRoutes() []string {
+	routes := make([]string, 0, len(conf.KeyPolicies))
+	for route := range conf.KeyPolicies {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	return routes
+}
+
+// UpstreamURL returns the url of the upstream with the given name, or the ApiUrl if name is empty (the default upstream).
+func (conf Config) UpstreamURL(name string) string {
+	return conf.Upstream(name).Url
+}
+
+// Upstream returns the upstream with the given name, or an Upstream made from the ApiUrl, ApiReplicas, Balance, HealthCheck,
+// CircuitBreaker, Proxy, and ApiTLS if name is empty (the default upstream). The default upstream has no routes, since it handles all requests that other upstreams do not.
+func (conf Config) Upstream(name string) Upstream {
+	if name == "" {
+		return Upstream{
+			Url:            conf.ApiUrl,
+			Replicas:       conf.ApiReplicas,
+			Balance:        conf.Balance,
+			HealthCheck:    conf.HealthCheck,
+			CircuitBreaker: conf.CircuitBreaker,
+			Proxy:          conf.Proxy,
+			TLS:            conf.ApiTLS,
+		}
+	}
+
+	return conf.Upstreams[name]
+}
+
+// URLs returns the Url and the Replicas of the upstream, which are the servers requests to it are balanced across.
+func (upstream Upstream) URLs() []string {
+	return append([]string{upstream.Url}, upstream.Replicas...)
+}
+
+// PoolString returns a human-readable string representation of how requests are balanced across the servers of the upstream
+// and how they are health checked.
+func (upstream Upstream) PoolString() string {
+	var parts []string
+
+	if len(upstream.Replicas) > 0 {
+		parts = append(parts, upstream.BalanceOrDefault())
+	}
+	if upstream.HealthCheck.Enabled() {
+		check := upstream.HealthCheck.WithDefaults()
+		parts = append(parts, fmt.Sprintf("health checks on %s every %s", check.Path, check.Interval))
+	}
+	if upstream.CircuitBreaker.Enabled() {
+		breaker := upstream.CircuitBreaker.WithDefaults()
+		parts = append(parts, fmt.Sprintf("circuit breaker after %d failures (%s)", breaker.FailureThreshold, breaker.WhileOpen))
+	}
+	if upstream.Proxy.Retries > 0 {
+		parts = append(parts, fmt.Sprintf("%d retries", upstream.Proxy.Retries))
+	}
+	if !upstream.TLS.IsZero() {
+		parts = append(parts, upstream.TLS.String())
+	}
+
+	if len(parts) == 0 {
+		return "single server"
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// BalanceOrDefault returns the Balance of the upstream, or "round-robin" if it is not set.
+func (upstream Upstream) BalanceOrDefault() string {
+	if upstream.Balance == "" {
+		return DefaultBalance
+	}
+
+	return upstream.Balance
 }
 
 // RemoveInvalidHTTPMethods removes all map keys that are not valid http methods
@@ -198,7 +739,7 @@ func (conf *Config) RemoveInvalidHTTPMethods() {
 	invalidCacheMethod := false
 	invalidBustMethod := false
 
-	// Only HEAD and GET are valid cacheable methods
+	// Only GET, HEAD, and POST are valid cacheable methods
 	for method := range conf.Cache {
 		if !contains(CacheableHTTPMethods, method) {
 
@@ -258,12 +799,58 @@ func (conf Config) String() string {
 	generalTable.SetRowLine(true)
 	generalTable.AppendBulk([][]string{
 		{"Cache address", conf.Address()},
-		{"Proxied API URL", conf.ApiUrl},
+		{"Proxied API URL", strings.Join(conf.Upstream("").URLs(), "\n")},
 		{"Capacity", conf.CapacityString()},
 		{"Log", conf.LogModeString()},
 	})
+	if len(conf.sources) > 0 {
+		generalTable.Append([]string{"Config files", strings.Join(conf.sources, "\n")})
+	}
+	if conf.LogFilePath != "" {
+		generalTable.Append([]string{"Log rotation", conf.LogRotationString()})
+	}
+	generalTable.Append([]string{"Access log", conf.AccessLogString()})
+	if len(conf.ApiReplicas) > 0 || conf.HealthCheck.Enabled() || conf.CircuitBreaker.Enabled() || conf.Proxy.Retries > 0 || !conf.ApiTLS.IsZero() {
+		generalTable.Append([]string{"API balancing", conf.Upstream("").PoolString()})
+	}
+	if conf.StatsPath != "" {
+		generalTable.Append([]string{"Stats path", conf.StatsPath})
+	}
+	if conf.TLS.Enabled() {
+		generalTable.Append([]string{"TLS", conf.TLS.String()})
+	}
+	if len(conf.Cache["POST"]) > 0 {
+		generalTable.Append([]string{"Cached body limit", fmt.Sprintf("%dKB", conf.CacheBodyLimitBytes()/int(cache.KB))})
+	}
+	if conf.CacheTTL > 0 {
+		generalTable.Append([]string{"Cache TTL", conf.CacheTTL.String()})
+	}
+	if conf.Idempotency.Enabled() {
+		idempotency := conf.Idempotency.WithDefaults()
+		generalTable.Append([]string{"Idempotency keys", fmt.Sprintf("kept for %s, up to %d keys, per %s", idempotency.Retention, idempotency.MaxKeys, idempotency.ClientHeader)})
+	}
+	if conf.GraphQL.Enabled() {
+		generalTable.Append([]string{"GraphQL", conf.GraphQL.String()})
+	}
 	generalTable.Render()
 
+	//* Create upstreams table
+	if len(conf.Upstreams) > 0 {
+		output.WriteString("\nUpstreams\n")
+		upstreamRows := [][]string{}
+		for _, name := range conf.UpstreamNames() {
+			upstream := conf.Upstreams[name]
+			upstreamRows = append(upstreamRows, []string{name, strings.Join(upstream.URLs(), "\n"), upstream.PoolString(), strings.Join(upstream.Routes, "\n")})
+		}
+		defaultUpstream := conf.Upstream("")
+		upstreamRows = append(upstreamRows, []string{"(default)", strings.Join(defaultUpstream.URLs(), "\n"), defaultUpstream.PoolString(), "all other routes"})
+		upstreamTable := tablewriter.NewWriter(output)
+		upstreamTable.SetHeader([]string{"Name", "URLs", "Balancing", "Routes"})
+		upstreamTable.SetRowLine(true)
+		upstreamTable.AppendBulk(upstreamRows)
+		upstreamTable.Render()
+	}
+
 	//* Create cache config table
 	output.WriteString("\nCached Endpoints\n")
 	cacheRows := [][]string{}
@@ -279,11 +866,33 @@ func (conf Config) String() string {
 	cacheTable.AppendBulk(cacheRows)
 	cacheTable.Render()
 
+	//* Create key policy table
+	if len(conf.KeyPolicies) > 0 {
+		output.WriteString("\nCache Key Policies\n")
+		policyRows := [][]string{}
+		for _, route := range conf.KeyPolicyRoutes() {
+			policyRows = append(policyRows, []string{route, conf.KeyPolicies[route].String()})
+		}
+		policyTable := tablewriter.NewWriter(output)
+		policyTable.SetHeader([]string{"Route", "Policy"})
+		policyTable.SetRowLine(true)
+		policyTable.AppendBulk(policyRows)
+		policyTable.Render()
+	}
+
 	//* Create bust config table
 	bustRows := [][]string{}
 
 	for _, method := range AllHTTPMethods {
-		for endpoint, endpointMap := range conf.Bust[method] {
+		// Sort the endpoints, so the table is the same every time the same configuration is printed
+		endpoints := make([]string, 0, len(conf.Bust[method]))
+		for endpoint := range conf.Bust[method] {
+			endpoints = append(endpoints, endpoint)
+		}
+		sort.Strings(endpoints)
+
+		for _, endpoint := range endpoints {
+			endpointMap := conf.Bust[method][endpoint]
 			if len(endpointMap) == 0 {
 				bustRows = append(bustRows, []string{method, endpoint, "ALL"}) // empty bust pattern slice means to bust everything
 				continue
@@ -1,7 +1,12 @@
 package config
 
 import (
+	"crypto/tls"
+	"encoding/json"
+	"os"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -83,3 +88,617 @@ func TestTrimTrailingSlash(t *testing.T) {
 	assert.Equal(t, "https://jsonplaceholder.typicode.com", conf2.ApiUrl, "Expected config.LoadJSON to remove trailing slashes from the api url prop when initialized, got: %s", conf2.ApiUrl)
 
 }
+
+func TestLoadJSONC(t *testing.T) {
+	assert := assert.New(t)
+
+	configPath := "testdata/jsonc.config.json"
+
+	assert.FileExists(configPath, "Expected test configuration file to exist for test to work")
+
+	conf, err := LoadJSON(configPath)
+
+	if assert.NoError(err, "Expected config.LoadJSON to accept comments and trailing commas") {
+		assert.Equal(uint64(5), conf.Capacity)
+		assert.Equal("https://jsonplaceholder.typicode.com", conf.ApiUrl)
+		assert.Equal([]string{"/posts", "/posts/:id"}, conf.Cache["GET"], "Expected trailing commas in arrays to be ignored")
+		assert.Equal([]string{"/posts//comments"}, conf.Cache["HEAD"], "Expected // in strings to be kept")
+		assert.Equal([]string{"/posts", "/* not a comment */"}, conf.Bust["POST"]["/posts"], "Expected /* */ in strings to be kept")
+	}
+}
+
+func TestLoadJSONParseError(t *testing.T) {
+	tests := []struct {
+		configPath string
+		position   string
+	}{
+		{"testdata/invalid.json", "line 4, column 3"},
+		{"testdata/wrong-type.json", `property 'port' at line 4, column 11 must be a positive whole number, it is a string`},
+	}
+
+	for _, tt := range tests {
+		assert.FileExists(t, tt.configPath, "Expected test configuration file to exist for test to work")
+
+		conf, err := LoadJSON(tt.configPath)
+
+		assert.Nil(t, conf, "Expected config.LoadJSON to return a nil Config pointer when the config file cannot be parsed")
+		if assert.Error(t, err, "Expected config.LoadJSON to return an error when the config file cannot be parsed") {
+			assert.Contains(t, err.Error(), tt.position, "Expected the parse error of %q to point to the problem", tt.configPath)
+		}
+	}
+}
+
+func TestUnknownProps(t *testing.T) {
+	assert := assert.New(t)
+
+	configPath := "testdata/unknown-props.json"
+
+	assert.FileExists(configPath, "Expected test configuration file to exist for test to work")
+
+	conf, err := LoadJSON(configPath)
+
+	assert.Nil(conf, "Expected config.LoadJSON to return a nil Config pointer when the config file has unknown properties")
+	if assert.Error(err, "Expected config.LoadJSON to return an error when the config file has unknown properties") {
+		assert.Contains(err.Error(), `unknown property 'capcity' at line 2, column 3 (did you mean "capacity"?)`)
+		assert.Contains(err.Error(), `unknown property 'futureFeature' at line 7, column 3`)
+	}
+
+	conf, err = Load(configPath, LoadOptions{AllowUnknownProps: true})
+
+	if assert.NoError(err, "Expected config.Load to ignore unknown properties when they are allowed") {
+		assert.Equal([]string{"/posts"}, conf.Cache["GET"])
+	}
+}
+
+func TestTypeErrorPath(t *testing.T) {
+	configPath := "testdata/wrong-nested-type.json"
+
+	assert.FileExists(t, configPath, "Expected test configuration file to exist for test to work")
+
+	_, err := Load(configPath, LoadOptions{AllowUnknownProps: true})
+
+	if assert.Error(t, err, "Expected config.Load to return an error when a value has the wrong type, even when unknown properties are allowed") {
+		assert.Contains(t, err.Error(), `property 'cache.GET[1]' at line 4, column 24 must be a string, it is a number`)
+	}
+}
+
+func TestInvalidValuePath(t *testing.T) {
+	configPath := "testdata/invalid-duration.json"
+
+	assert.FileExists(t, configPath, "Expected test configuration file to exist for test to work")
+
+	_, err := Load(configPath, LoadOptions{})
+
+	if assert.Error(t, err, "Expected config.Load to return an error when a duration cannot be parsed") {
+		assert.Contains(t, err.Error(), `property 'logMaxAge' at line 6, column 16 is invalid: invalid duration "abc"`)
+	}
+}
+
+func TestLoadFormats(t *testing.T) {
+	jsonConf, err := LoadJSON("testdata/test.config.json")
+	if !assert.NoError(t, err, "Expected the JSON test configuration file to be loaded without errors") {
+		return
+	}
+
+	tests := []struct {
+		configPath string
+		opts       LoadOptions
+	}{
+		{"testdata/test.config.yaml", LoadOptions{}},
+		{"testdata/test.config.toml", LoadOptions{}},
+		{"testdata/test.config.json", LoadOptions{Format: "json"}},
+	}
+
+	for _, tt := range tests {
+		assert.FileExists(t, tt.configPath, "Expected test configuration file to exist for test to work")
+
+		conf, err := Load(tt.configPath, tt.opts)
+
+		if assert.NoError(t, err, "Expected %q to be loaded without errors", tt.configPath) {
+			conf.sources = jsonConf.sources // the sources are the only thing that should differ
+			assert.Equal(t, jsonConf, conf, "Expected %q to be loaded into the same Config as the JSON configuration file", tt.configPath)
+		}
+	}
+
+	_, err = Load("testdata/test.config.json", LoadOptions{Format: "xml"})
+	assert.Error(t, err, "Expected config.Load to return an error for an unknown config file format")
+
+	_, err = Load("testdata/test.config.json", LoadOptions{Format: "yaml"})
+	assert.NoError(t, err, "Expected a JSON file to be loaded when it is read as YAML, since JSON is valid YAML")
+}
+
+func TestYAMLErrors(t *testing.T) {
+	_, err := Load("testdata/invalid.config.yaml", LoadOptions{})
+	if assert.Error(t, err, "Expected config.Load to return an error when a value in a YAML file has the wrong type") {
+		assert.Contains(t, err.Error(), "property 'port' at line 2, column 7 must be a positive whole number, it is a string")
+	}
+
+	_, err = Load("testdata/unknown-props.config.yaml", LoadOptions{})
+	if assert.Error(t, err, "Expected config.Load to return an error when a YAML file has unknown properties") {
+		assert.Contains(t, err.Error(), `unknown property 'capcity' at line 5, column 1 (did you mean "capacity"?)`)
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	assert := assert.New(t)
+
+	t.Setenv("CACHE_TEST_HOSTNAME", "cache.internal")
+	t.Setenv("CACHE_TEST_PORT", "9090")
+	t.Setenv("CACHE_TEST_RESOURCE", "posts")
+
+	conf, err := LoadJSON("testdata/interpolate.config.json")
+
+	if assert.NoError(err, "Expected environment variables and secret files to be resolved without errors") {
+		assert.Equal("https://secret.example.com", conf.ApiUrl, "Expected the api url to be read from the secret file")
+		assert.Equal("cache.internal", conf.Hostname, "Expected ${CACHE_TEST_HOSTNAME} to be replaced with the environment variable")
+		assert.EqualValues(9090, conf.Port, "Expected ${CACHE_TEST_PORT} to be converted to a number for the port")
+		assert.EqualValues(42, conf.Capacity, "Expected the default value to be used when the environment variable is not set")
+		assert.Equal([]string{"/posts/:id", "/${literal}"}, conf.Cache["GET"], "Expected variables in routes to be replaced and $${ to be kept as ${")
+		assert.Equal([]string{"^GET:/posts$"}, conf.Bust["POST"]["/${CACHE_TEST_RESOURCE}"], "Expected variables in patterns to be replaced, but not in routes used as keys")
+	}
+
+	_, err = LoadJSON("testdata/interpolate-unset.config.json")
+
+	if assert.Error(err, "Expected an unset environment variable without a default value to return an error") {
+		assert.Contains(err.Error(), `property 'hostname' at line 6, column 15: environment variable "CACHE_TEST_UNSET_HOSTNAME" is not set`)
+	}
+}
+
+func TestExtendsAndInclude(t *testing.T) {
+	assert := assert.New(t)
+
+	configPath := "testdata/layers/production.json"
+
+	assert.FileExists(configPath, "Expected test configuration file to exist for test to work")
+
+	conf, err := LoadJSON(configPath)
+
+	if assert.NoError(err, "Expected a config file that extends other config files to be loaded without errors") {
+		assert.EqualValues(5000, conf.Capacity, "Expected the extending file to override the capacity of the base file")
+		assert.Equal("https://api.example.com", conf.ApiUrl, "Expected the extending file to override the api url of the base file")
+		assert.Empty(conf.LogFilePath, "Expected null to remove the log file path of the base file")
+		assert.Equal([]string{"/posts", "/posts/:id"}, conf.Cache["GET"], "Expected the GET routes to be included from the shared route set")
+		assert.Equal([]string{"/posts"}, conf.Cache["HEAD"], "Expected the HEAD routes of the extending file to replace the included routes")
+		assert.Equal([]string{"/posts/:id"}, conf.Bust["PUT"]["/posts/:id"], "Expected the bust routes to be included from the shared route set")
+		assert.Equal([]string{"/posts"}, conf.Bust["POST"]["/posts"], "Expected the bust routes of the extending file to be merged with the included bust routes")
+		assert.Equal([]string{"testdata/layers/shared/routes.yaml", "testdata/layers/base.json", configPath}, conf.Sources(), "Expected the sources to list all merged files in order")
+	}
+
+	_, err = LoadJSON("testdata/layers/cycle-a.json")
+	if assert.Error(err, "Expected config files that extend each other to return an error") {
+		assert.Contains(err.Error(), "extends or includes itself")
+	}
+
+	_, err = LoadJSON("testdata/layers/wrong-type.json")
+	if assert.Error(err, "Expected a type error in a config file that extends another file to be reported") {
+		assert.Contains(err.Error(), "property 'port' at line 3, column 11 must be a positive whole number")
+	}
+}
+
+func TestSchema(t *testing.T) {
+	assert := assert.New(t)
+
+	schema, err := Schema()
+	if !assert.NoError(err, "Expected the JSON Schema to be generated without errors") {
+		return
+	}
+
+	committedSchema, err := os.ReadFile("../../config.schema.json")
+	if assert.NoError(err, "Expected the JSON Schema to be committed at the root of the repository") {
+		assert.Equal(string(committedSchema), string(schema), "Expected config.schema.json to be up to date, regenerate it with 'go run . schema > config.schema.json'")
+	}
+
+	var parsed map[string]interface{}
+	if assert.NoError(json.Unmarshal(schema, &parsed), "Expected the JSON Schema to be valid JSON") {
+		properties := parsed["properties"].(map[string]interface{})
+		assert.Contains(properties, "capacityUnit")
+		assert.Contains(properties, schemaProp, "Expected the schema to allow the $schema property")
+		assert.Contains(properties, extendsProp, "Expected the schema to allow the extends property")
+		assert.Equal(false, parsed["additionalProperties"], "Expected the schema to reject unknown properties like loading does")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	configPath := "testdata/check.config.json"
+
+	assert.FileExists(configPath, "Expected test configuration file to exist for test to work")
+
+	conf, err := LoadJSON(configPath)
+	if !assert.NoError(err, "Expected a config with semantic issues to pass the format validation") {
+		return
+	}
+
+	issues := conf.Check()
+
+	expectedIssues := []Issue{
+		{SeverityWarning, "cache.GET[2]", "is shadowed by the earlier route '/posts/:id' (cache.GET[1]), which handles all of its requests"},
+		{SeverityWarning, "cache.GET[3]", "is the same route as cache.GET[0]"},
+		{SeverityWarning, `bust.GET["/posts"]`, "busts the entries of the cached route '/posts' (cache.GET[0]) on requests to it, so they are never read from the cache"},
+		{SeverityError, `bust.POST["/posts"][0]`, "is not a valid regular expression: error parsing regexp: missing closing ): `^GET:/posts(`"},
+		{SeverityWarning, `bust.POST["/posts"][1]`, "does not match any cached route, so it never busts any entries"},
+		{SeverityError, `bust.PUT["/posts/:id"][1]`, "uses the route param :postId, which is never replaced, since the route '/posts/:id' has no such param"},
+	}
+	assert.Equal(expectedIssues, issues, "Expected all semantic issues to be found in the order of the config")
+
+	validConf, _ := LoadJSON("testdata/test.config.json")
+	assert.Empty(validConf.Check(), "Expected no issues in a config without semantic issues")
+}
+
+func TestCheckKeySegments(t *testing.T) {
+	conf := New()
+	conf.ApiUrl = "https://a.example.com"
+	conf.Cache["GET"] = []string{"/posts"}
+	conf.KeyPolicies = map[string]KeyPolicy{"/posts": {Template: "{method}:{path}|{header:X-Tenant}|{cookie:lang}"}}
+	conf.Bust["POST"]["/posts"] = []string{`^GET:/posts\|{header:X-Tenant}\|{cookie:lang}$`, `^GET:/posts/:postId\|{header:X-Tenant}`}
+
+	var issues []string
+	for _, issue := range conf.Check() {
+		issues = append(issues, issue.String())
+	}
+
+	// The first pattern matches the templated keys of /posts, so it is not reported as busting nothing either
+	assert.Equal(t, []string{
+		`error: 'bust.POST["/posts"][1]' uses the route param :postId, which is never replaced, since the route '/posts' has no such param`,
+	}, issues, "Expected key segments not to be read as route params")
+
+	conf.KeyPolicies["/users/:id"] = KeyPolicy{LowercasePath: true}
+	conf.Cache["GET"] = append(conf.Cache["GET"], "/Users/:id")
+
+	segments := KeyRequest{Header: func(string) string { return "a" }, Cookie: func(string) string { return "" }}
+	routes, err := conf.CachedRoutesMatching("", `^GET:/posts\|a\|$`, nil, segments)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"GET /posts"}, routes, "Expected the example keys to be made with the key template and the segments of the request")
+	}
+
+	routes, _ = conf.CachedRoutesMatching("", `^GET:/users/`, nil, segments)
+	assert.Equal(t, []string{"GET /Users/:id"}, routes, "Expected the example keys to be normalized by the key policy of the route")
+}
+
+func TestCachedRoutesMatching(t *testing.T) {
+	assert := assert.New(t)
+
+	conf, _ := LoadJSON("testdata/test.config.json")
+
+	routes, err := conf.CachedRoutesMatching("", "^GET:/posts/12$", map[string]string{"id": "12"}, KeyRequest{})
+	if assert.NoError(err) {
+		assert.Equal([]string{"GET /posts/:id"}, routes, "Expected the params of the request to be used in the example keys of the cached routes")
+	}
+
+	routes, err = conf.CachedRoutesMatching("", "/posts", nil, KeyRequest{})
+	if assert.NoError(err) {
+		assert.Len(routes, len(conf.Cache["GET"])+len(conf.Cache["HEAD"]), "Expected a pattern without a method to match the routes of all methods")
+	}
+
+	_, err = conf.CachedRoutesMatching("", "/posts(", nil, KeyRequest{})
+	assert.Error(err, "Expected an invalid pattern to return an error")
+}
+
+func TestUpstreams(t *testing.T) {
+	assert := assert.New(t)
+
+	configPath := "testdata/upstreams.config.json"
+
+	assert.FileExists(configPath, "Expected test configuration file to exist for test to work")
+
+	conf, err := LoadJSON(configPath)
+	if !assert.NoError(err, "Expected a config with upstreams to be loaded without errors") {
+		return
+	}
+
+	assert.NoError(conf.Validate(), "Expected a config with valid upstreams to be valid")
+	assert.Equal("https://users.example.com", conf.UpstreamURL("users"), "Expected trailing slashes to be trimmed from upstream urls")
+	assert.Equal(conf.ApiUrl, conf.UpstreamURL(""), "Expected the default upstream to be the ApiUrl")
+	assert.Equal([]string{"users"}, conf.UpstreamNames())
+
+	routes, _ := conf.CachedRoutesMatching("users", "^GET:/users/1$", nil, KeyRequest{})
+	assert.Equal([]string{"GET /users/:id"}, routes, "Expected bust patterns to match the cached routes of their own upstream")
+	routes, _ = conf.CachedRoutesMatching("", "^GET:/users/1$", nil, KeyRequest{})
+	assert.Empty(routes, "Expected bust patterns not to match the cached routes of other upstreams")
+
+	expectedIssues := []Issue{
+		{SeverityWarning, `bust.PUT["/posts/:id"][0]`, "does not match any cached route, so it never busts any entries"},
+	}
+	assert.Equal(expectedIssues, conf.Check(), "Expected bust patterns to only be checked against the cached routes of their own upstream")
+
+	invalidConf, err := LoadJSON("testdata/invalid-upstreams.config.json")
+	if assert.NoError(err) {
+		err = invalidConf.Validate()
+		if assert.Error(err, "Expected invalid upstreams to fail validation") {
+			assert.Contains(err.Error(), `the key of 'Upstreams[user|s]' must be a name with only letters, digits, '-', and '_', it is "user|s"`)
+			assert.Contains(err.Error(), `'Upstreams[comments].Url' value is not a valid URL, it is "not a url"`)
+			assert.Contains(err.Error(), `'Upstreams[comments].Routes' must be set`)
+		}
+	}
+}
+
+func TestUpstreamPools(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := New()
+	conf.ApiUrl = "https://a.example.com/"
+	conf.ApiReplicas = []string{"https://b.example.com/"}
+	conf.Balance = "least-connections"
+	conf.HealthCheck = HealthCheck{Path: "/health", Interval: Duration(time.Minute)}
+	conf.Cache["GET"] = []string{"/posts"}
+	conf.TrimTrailingSlash()
+
+	assert.NoError(conf.Validate(), "Expected a config with replicas, a balancing strategy, and health checks to be valid")
+	assert.Equal([]string{"https://a.example.com", "https://b.example.com"}, conf.Upstream("").URLs(), "Expected the default upstream to have the ApiUrl and the trimmed ApiReplicas")
+
+	expectedCheck := HealthCheck{"/health", Duration(time.Minute), DefaultHealthCheckTimeout, DefaultHealthyThreshold, DefaultUnhealthyThreshold}
+	assert.Equal(expectedCheck, conf.HealthCheck.WithDefaults(), "Expected only the zero values of the health check to be replaced by defaults")
+
+	proxy := ProxySettings{Retries: 3}.WithDefaults()
+	assert.Equal(DefaultDialTimeout, proxy.DialTimeout)
+	assert.Equal([]time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond}, []time.Duration{proxy.Backoff(1), proxy.Backoff(2), proxy.Backoff(3)}, "Expected the backoff to double for every retry")
+
+	conf.Balance = "random"
+	conf.HealthCheck.Path = "health"
+	conf.CircuitBreaker.WhileOpen = "never"
+	conf.Upstreams = UpstreamMap{"users": {
+		Url:      "https://users.example.com",
+		Replicas: []string{"not a url"},
+		Proxy:    ProxySettings{ReadTimeout: Duration(-time.Second)},
+		Routes:   []string{"/users"},
+	}}
+
+	err := conf.Validate()
+	if assert.Error(err, "Expected invalid pool props to fail validation") {
+		assert.Contains(err.Error(), `'Balance' must be omitted or set to either "round-robin", "least-connections", or "consistent-hash", it is "random"`)
+		assert.Contains(err.Error(), `'CircuitBreaker.WhileOpen' must be omitted or set to either "fail" or "stale", it is "never"`)
+		assert.Contains(err.Error(), `'HealthCheck.Path' must be omitted or set to a path that starts with '/', it is "health"`)
+		assert.Contains(err.Error(), `'Upstreams[users].Replicas[0]' value is not a valid URL, it is "not a url"`)
+		assert.Contains(err.Error(), `'Upstreams[users].Proxy.ReadTimeout' must be omitted or set to a positive duration, it is "-1s"`)
+	}
+}
+
+func TestServerTLS(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := New()
+	conf.ApiUrl = "https://a.example.com"
+	conf.Cache["GET"] = []string{"/posts"}
+	conf.TLS = ServerTLS{
+		KeyFile:      "testdata/test.config.json", // any existing file will do
+		MinVersion:   "1.4",
+		CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_RC4_128_SHA"},
+		ClientCAFile: "testdata/does.not.exist.pem",
+	}
+
+	err := conf.Validate()
+	if assert.Error(err, "Expected invalid TLS props to fail validation") {
+		assert.Contains(err.Error(), `'TLS.CertFile' must be set when KeyFile or ClientCAFile is set`)
+		assert.Contains(err.Error(), `'TLS.MinVersion' must be omitted or set to either "1.0", "1.1", "1.2", or "1.3", it is "1.4"`)
+		assert.Contains(err.Error(), `'TLS.CipherSuites[1]' must be the name of a secure cipher suite`)
+		assert.Contains(err.Error(), `'TLS.ClientCAFile' must be omitted or set to the path of an existing file, it is "testdata/does.not.exist.pem"`)
+		assert.NotContains(err.Error(), "CipherSuites[0]", "Expected secure cipher suites to be valid")
+	}
+
+	assert.False(conf.TLS.Enabled(), "Expected TLS to be disabled without a CertFile")
+	assert.Equal(uint16(tls.VersionTLS12), ServerTLS{}.TLSMinVersion(), "Expected TLS 1.2 to be the default minimum version")
+	assert.Equal(tls.RequireAndVerifyClientCert, conf.TLS.TLSClientAuth(), "Expected client certificates to be required when a ClientCAFile is set")
+}
+
+func TestUpstreamTLS(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := New()
+	conf.ApiUrl = "https://a.example.com"
+	conf.Cache["GET"] = []string{"/posts"}
+	conf.ApiTLS = UpstreamTLS{ServerName: "not a hostname"}
+	conf.Upstreams = UpstreamMap{"users": {
+		Url:    "https://users.example.com",
+		TLS:    UpstreamTLS{KeyFile: "testdata/test.config.json", CAFile: "testdata/does.not.exist.pem"},
+		Routes: []string{"/users"},
+	}}
+
+	err := conf.Validate()
+	if assert.Error(err, "Expected invalid TLS props of upstreams to fail validation") {
+		assert.Contains(err.Error(), `'ApiTLS.ServerName' must be omitted or set to a valid rfc1123 hostname, it is "not a hostname"`)
+		assert.Contains(err.Error(), `'Upstreams[users].TLS.CertFile' must be set when KeyFile is set`)
+		assert.Contains(err.Error(), `'Upstreams[users].TLS.CAFile' must be omitted or set to the path of an existing file, it is "testdata/does.not.exist.pem"`)
+	}
+
+	assert.Equal(conf.ApiTLS, conf.Upstream("").TLS, "Expected the default upstream to use the ApiTLS")
+}
+
+func TestGraphQL(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := New()
+	conf.ApiUrl = "https://a.example.com"
+	conf.Cache["GET"] = []string{"/posts"}
+	conf.GraphQL = GraphQL{
+		Endpoints: []string{"graphql"},
+		Types:     map[string][]string{"GetPost": {"Post"}},
+		Bust:      map[string][]string{"add-comment": {"Comment", "2Posts"}},
+	}
+
+	err := conf.Validate()
+	if assert.Error(err, "Expected invalid GraphQL props to fail validation") {
+		assert.Contains(err.Error(), `'GraphQL.Endpoints[0]' must be a valid route identifier, it is "graphql"`)
+		assert.Contains(err.Error(), `'GraphQL.Bust[add-comment]' must be a GraphQL name`)
+		assert.Contains(err.Error(), `'GraphQL.Bust[add-comment][1]' must be a GraphQL name`)
+	}
+
+	conf.GraphQL.Endpoints = []string{"/graphql"}
+	conf.GraphQL.Bust = map[string][]string{"AddComment": {"Comment", "GetPost"}}
+	assert.NoError(conf.Validate())
+}
+
+func TestCachePOST(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := New()
+	conf.ApiUrl = "https://a.example.com"
+	conf.Cache["POST"] = []string{"/search"}
+
+	assert.NoError(conf.Validate(), "Expected POST routes to be cacheable")
+	assert.Equal(64*1024, conf.CacheBodyLimitBytes(), "Expected the default body limit to be 64KB")
+
+	conf.CacheBodyLimit = 0
+	assert.Equal(64*1024, conf.CacheBodyLimitBytes(), "Expected a body limit of 0 to use the default")
+
+	conf.CacheBodyLimit = 2
+	assert.Equal(2*1024, conf.CacheBodyLimitBytes())
+
+	conf.Cache["PUT"] = []string{"/items"}
+	err := conf.Validate()
+	if assert.Error(err, "Expected PUT routes not to be cacheable") {
+		assert.Contains(err.Error(), "GET, HEAD, POST")
+	}
+}
+
+func TestIdempotency(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := New()
+	conf.ApiUrl = "https://a.example.com"
+	conf.Cache["GET"] = []string{"/posts"}
+	conf.Idempotency.Retention = Duration(-time.Second)
+
+	err := conf.Validate()
+	if assert.Error(err, "Expected a negative retention to fail validation") {
+		assert.Contains(err.Error(), `'Idempotency.Retention' must be omitted or set to a positive duration`)
+	}
+
+	assert.False(Idempotency{}.Enabled(), "Expected idempotency keys to be disabled without a retention")
+	assert.Equal(DefaultIdempotencyMaxKeys, Idempotency{Retention: Duration(time.Hour)}.WithDefaults().MaxKeys)
+	assert.Equal(DefaultIdempotencyWait, Idempotency{Retention: Duration(time.Hour)}.WithDefaults().Wait)
+	assert.Equal(DefaultIdempotencyClientHeader, Idempotency{Retention: Duration(time.Hour)}.WithDefaults().ClientHeader)
+}
+
+func TestKeyPolicies(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := New()
+	conf.ApiUrl = "https://a.example.com"
+	conf.Cache["GET"] = []string{"/posts"}
+	conf.KeyPolicies = map[string]KeyPolicy{
+		"posts":  {SortQuery: true},
+		"/posts": {IgnoreParams: []string{"utm_["}},
+	}
+
+	err := conf.Validate()
+	if assert.Error(err, "Expected invalid key policies to fail validation") {
+		assert.Contains(err.Error(), `the key of 'KeyPolicies[posts]' must be a valid route identifier, it is "posts"`)
+		assert.Contains(err.Error(), `'KeyPolicies[/posts].IgnoreParams[0]' must be a query param name where '*' matches any characters, it is "utm_["`)
+	}
+
+	conf.KeyPolicies = map[string]KeyPolicy{"/posts/*": {SortQuery: true}, "/posts": {}}
+	assert.NoError(conf.Validate())
+	assert.Equal([]string{"/posts", "/posts/*"}, conf.KeyPolicyRoutes(), "Expected key policy routes in alphabetical order")
+}
+
+func TestKeyPolicyNormalize(t *testing.T) {
+	tests := []struct {
+		policy KeyPolicy
+		url    string
+		want   string
+	}{
+		{KeyPolicy{}, "/Posts?b=2&a=1", "/Posts?b=2&a=1"},
+		{KeyPolicy{SortQuery: true}, "/posts?b=2&a=1&b=1", "/posts?a=1&b=2&b=1"},
+		{KeyPolicy{SortQuery: true}, "/posts?b=2&%61=1", "/posts?%61=1&b=2"},
+		{KeyPolicy{IgnoreParams: []string{"utm_*", "_"}}, "/posts?utm_source=x&page=2&_=123", "/posts?page=2"},
+		{KeyPolicy{IgnoreParams: []string{"utm_*"}}, "/posts?utm_source=x", "/posts"},
+		{KeyPolicy{AllowParams: []string{"page", "sort"}}, "/posts?sort=new&session=abc&page=2", "/posts?sort=new&page=2"},
+		{KeyPolicy{AllowParams: []string{"page*"}, IgnoreParams: []string{"page_token"}}, "/posts?page=2&page_token=x&pageSize=10", "/posts?page=2&pageSize=10"},
+		{KeyPolicy{LowercasePath: true}, "/Posts/ABC?Q=Mixed", "/posts/abc?Q=Mixed"},
+		{KeyPolicy{SortQuery: true}, "/posts?&b=2&&a=1&", "/posts?a=1&b=2"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.policy.Normalize(tt.url), "Expected %s normalized by %s", tt.url, tt.policy)
+	}
+}
+
+func TestKeyTemplate(t *testing.T) {
+	assert := assert.New(t)
+
+	request := KeyRequest{
+		Method: "GET",
+		Host:   "api.example.com",
+		URL:    "/posts?b=2&a=1",
+		Header: func(name string) string { return map[string]string{"X-Tenant": "acme"}[name] },
+		Cookie: func(name string) string { return map[string]string{"lang": "da"}[name] },
+	}
+
+	assert.Equal("GET:/posts?b=2&a=1", KeyPolicy{}.Key(request), "Expected keys without a template to be the method and URL")
+
+	policy := KeyPolicy{SortQuery: true, Template: "{method}:{host}{path}?{query}|{header:X-Tenant}|{cookie:lang}"}
+	assert.Equal("GET:api.example.com/posts?a=1&b=2|acme|da", policy.Key(request))
+
+	request.URL = "/posts"
+	assert.Equal("GET:api.example.com/posts|acme|da", policy.Key(request), "Expected the ? before an empty query to be left out")
+
+	assert.Equal([]string{`^GET:api\.example\.com/posts\?page=\d{2}\|acme\|`, `\|da$`},
+		HydrateKeySegments(request, []string{`^GET:{host}/posts\?page=\d{2}\|{header:X-Tenant}\|`, `\|{cookie:lang}$`}))
+
+	// Values with the separators of the template must not make the keys of different tenants collide
+	tenantA := KeyRequest{
+		Method: "GET",
+		URL:    "/posts",
+		Header: func(name string) string { return map[string]string{"X-Tenant": "a|x"}[name] },
+		Cookie: func(name string) string { return map[string]string{"lang": "en"}[name] },
+	}
+	tenantB := tenantA
+	tenantB.Header = func(name string) string { return map[string]string{"X-Tenant": "a"}[name] }
+	tenantB.Cookie = func(name string) string { return map[string]string{"lang": "x|en"}[name] }
+
+	collisionPolicy := KeyPolicy{Template: "{method}:{path}|{header:X-Tenant}|{cookie:lang}"}
+	assert.Equal("GET:/posts|a%7Cx|en", collisionPolicy.Key(tenantA))
+	assert.Equal("GET:/posts|a|x%7Cen", collisionPolicy.Key(tenantB))
+	assert.NotEqual(collisionPolicy.Key(tenantA), collisionPolicy.Key(tenantB), "Expected values with separators not to make keys collide")
+
+	bustTenant := regexp.MustCompile(HydrateKeySegments(tenantA, []string{`^GET:/posts\|{header:X-Tenant}\|`})[0])
+	assert.True(bustTenant.MatchString(collisionPolicy.Key(tenantA)), "Expected a hydrated pattern to match the encoded values in keys")
+	assert.False(bustTenant.MatchString(collisionPolicy.Key(tenantB)))
+
+	conf := New()
+	conf.ApiUrl = "https://a.example.com"
+	conf.Cache["GET"] = []string{"/posts"}
+	conf.KeyPolicies = map[string]KeyPolicy{
+		"/a": {Template: "{path}|{header:X-Tenant}"},
+		"/b": {Template: "{method}:{path}|{header}"},
+		"/c": {Template: "{method}:{path}|{tenant}"},
+	}
+
+	err := conf.Validate()
+	if assert.Error(err, "Expected invalid key templates to fail validation") {
+		assert.Contains(err.Error(), `'KeyPolicies[/a].Template' must start with {method}:, it is "{path}|{header:X-Tenant}"`)
+		assert.Contains(err.Error(), `'KeyPolicies[/b].Template' has an invalid variable {header}`)
+		assert.Contains(err.Error(), `'KeyPolicies[/c].Template' may only contain the variables`)
+	}
+
+	conf.KeyPolicies = map[string]KeyPolicy{"/posts": policy}
+	assert.NoError(conf.Validate())
+}
+
+func TestCacheTTL(t *testing.T) {
+	conf := New()
+	conf.ApiUrl = "https://a.example.com"
+	conf.Cache["GET"] = []string{"/posts"}
+	conf.CacheTTL = Duration(-time.Minute)
+
+	err := conf.Validate()
+	if assert.Error(t, err, "Expected a negative cache TTL to fail validation") {
+		assert.Contains(t, err.Error(), `'CacheTTL' must be omitted or set to a positive duration, it is "-1m0s"`)
+	}
+
+	conf.CacheTTL = Duration(5 * time.Minute)
+	assert.NoError(t, conf.Validate())
+}
+
+func TestRestartRequiredChanges(t *testing.T) {
+	conf := New()
+	newConf := New()
+	assert.Empty(t, conf.RestartRequiredChanges(newConf))
+
+	newConf.ShutdownTimeout = Duration(time.Minute)
+	newConf.TLS.CertFile = "cert.pem"
+	newConf.Cache["GET"] = []string{"/posts"} // routes are reloaded
+
+	assert.Equal(t, []string{"tls", "shutdown timeout"}, conf.RestartRequiredChanges(newConf))
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Duration is a time.Duration that is written as a human-readable string in configuration files, e.g. "30s" or "24h".
+// It is used for all configuration props that represent an amount of time.
+type Duration time.Duration
+
+// Std returns the Duration as a time.Duration.
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+// String returns the Duration formatted like a time.Duration, e.g. "1h30m0s".
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalText formats the Duration as a string, so it is written the same way it is read.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText parses a duration string such as "300ms", "1.5h" or "2h45m".
+// Valid time units are "ns", "us" (or "µs"), "ms", "s", "m", "h".
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, use a number with a unit such as \"30s\" or \"24h\"", text)
+	}
+
+	*d = Duration(parsed)
+
+	return nil
+}
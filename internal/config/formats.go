@@ -0,0 +1,282 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats that config files can be written in.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// ConfigFormats is a slice of all formats that config files can be written in.
+var ConfigFormats = []string{FormatJSON, FormatYAML, FormatTOML}
+
+// formatExtensions maps config file extensions to the format the file is written in.
+var formatExtensions = map[string]string{
+	".json":  FormatJSON,
+	".jsonc": FormatJSON,
+	".yaml":  FormatYAML,
+	".yml":   FormatYAML,
+	".toml":  FormatTOML,
+}
+
+// LoadOptions changes how a config file is decoded by Load.
+type LoadOptions struct {
+	// AllowUnknownProps ignores properties that are not part of the Config instead of returning an error.
+	// This can be used to share a config file with newer versions of cache-me-ousside that have more properties.
+	AllowUnknownProps bool
+
+	// Format is the format the config file is written in, which is one of ConfigFormats.
+	// If it is empty, the format is chosen by the file extension, and files with other extensions are read as JSON.
+	Format string
+}
+
+// format returns the format of the config file at configPath.
+func (opts LoadOptions) format(configPath string) (string, error) {
+	if opts.Format == "" {
+		if format, found := formatExtensions[strings.ToLower(filepath.Ext(configPath))]; found {
+			return format, nil
+		}
+
+		return FormatJSON, nil
+	}
+
+	format := strings.ToLower(opts.Format)
+	if format == "yml" {
+		format = FormatYAML
+	}
+
+	if !contains(ConfigFormats, format) {
+		return "", fmt.Errorf("unknown config file format %q, it must be either %s", opts.Format, strings.Join(ConfigFormats, ", "))
+	}
+
+	return format, nil
+}
+
+// configDocument is a config file converted to JSON, so files in all formats are decoded into the Config the same way.
+type configDocument struct {
+	json []byte
+
+	// original is the original JSON(C) file, where offsets point to the same place as offsets in json.
+	// It is nil for other formats.
+	original []byte
+
+	// positions are where properties and values are in the original file, for formats other than JSON.
+	positions filePositions
+}
+
+// decodeDocument parses data from the config file at configPath in the given format and converts it to JSON.
+func decodeDocument(configPath, format string, data []byte) (*configDocument, error) {
+	switch format {
+	case FormatYAML:
+		return decodeYAMLDocument(configPath, data)
+	case FormatTOML:
+		return decodeTOMLDocument(configPath, data)
+	default:
+		return decodeJSONDocument(configPath, data)
+	}
+}
+
+// decodeJSONDocument standardizes JSON with comments and trailing commas and makes sure it can be parsed.
+func decodeJSONDocument(configPath string, data []byte) (*configDocument, error) {
+	standardJSON := standardizeJSON(data)
+
+	// Unmarshaling into a json.RawMessage only checks the syntax
+	var raw json.RawMessage
+	if err := json.Unmarshal(standardJSON, &raw); err != nil {
+		return nil, newParseError(configPath, data, err)
+	}
+
+	return &configDocument{json: standardJSON, original: data}, nil
+}
+
+// decodeYAMLDocument converts a YAML config file to JSON and records where every property and value is in the YAML.
+// Anchors, aliases, and merge keys (<<) are resolved.
+func decodeYAMLDocument(configPath string, data []byte) (*configDocument, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("could not parse config file %q: %w", configPath, err)
+	}
+
+	positions := filePositions{
+		keys:   make(map[string]filePosition),
+		values: make(map[string]filePosition),
+	}
+
+	tree, err := yamlValue(&root, "", positions)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse config file %q: %w", configPath, err)
+	}
+	if tree == nil {
+		tree = map[string]interface{}{} // an empty file
+	}
+
+	jsonData, err := json.Marshal(tree)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse config file %q: %w", configPath, err)
+	}
+
+	return &configDocument{json: jsonData, positions: positions}, nil
+}
+
+// yamlValue converts the YAML node at path to the value it would be as JSON and records its position.
+func yamlValue(node *yaml.Node, path string, positions filePositions) (interface{}, error) {
+	if node.Kind != yaml.DocumentNode {
+		positions.values[path] = filePosition{line: node.Line, column: node.Column}
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return yamlValue(node.Content[0], path, positions)
+
+	case yaml.AliasNode:
+		return yamlValue(node.Alias, path, positions)
+
+	case yaml.SequenceNode:
+		list := make([]interface{}, len(node.Content))
+		for i, elem := range node.Content {
+			value, err := yamlValue(elem, fmt.Sprintf("%s[%d]", path, i), positions)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = value
+		}
+		return list, nil
+
+	case yaml.MappingNode:
+		object := make(map[string]interface{}, len(node.Content)/2)
+		merged := make(map[string]interface{})
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyNode, valueNode := node.Content[i], node.Content[i+1]
+			if keyNode.Kind == yaml.AliasNode {
+				keyNode = keyNode.Alias
+			}
+
+			// Merge keys copy the properties of other mappings, unless the properties are set explicitly
+			if keyNode.Tag == "!!merge" {
+				if err := mergeYAMLMappings(valueNode, path, positions, merged); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			propPath := joinPropPath(path, keyNode.Value)
+			positions.keys[propPath] = filePosition{line: keyNode.Line, column: keyNode.Column}
+
+			value, err := yamlValue(valueNode, propPath, positions)
+			if err != nil {
+				return nil, err
+			}
+			object[keyNode.Value] = value
+		}
+
+		for key, value := range merged {
+			if _, found := object[key]; !found {
+				object[key] = value
+			}
+		}
+
+		return object, nil
+
+	default: // scalar
+		var value interface{}
+		if err := node.Decode(&value); err != nil {
+			return nil, fmt.Errorf("line %d: %w", node.Line, err)
+		}
+
+		// Timestamps are not part of JSON, so keep them as they were written
+		if _, isTime := value.(time.Time); isTime {
+			return node.Value, nil
+		}
+
+		return value, nil
+	}
+}
+
+// mergeYAMLMappings adds the properties of the mapping (or sequence of mappings) in node to merged,
+// unless merged already has them, since earlier mappings take precedence.
+func mergeYAMLMappings(node *yaml.Node, path string, positions filePositions, merged map[string]interface{}) error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	mappings := []*yaml.Node{node}
+	if node.Kind == yaml.SequenceNode {
+		mappings = node.Content
+	}
+
+	for _, mapping := range mappings {
+		value, err := yamlValue(mapping, path, positions)
+		if err != nil {
+			return err
+		}
+
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			return fmt.Errorf("line %d: only mappings can be merged with <<", mapping.Line)
+		}
+
+		for key, value := range object {
+			if _, found := merged[key]; !found {
+				merged[key] = value
+			}
+		}
+	}
+
+	return nil
+}
+
+// decodeTOMLDocument converts a TOML config file to JSON.
+// The TOML decoder does not report where keys are, so errors from TOML files only include the property path.
+func decodeTOMLDocument(configPath string, data []byte) (*configDocument, error) {
+	var tree map[string]interface{}
+	if _, err := toml.Decode(string(data), &tree); err != nil {
+		return nil, fmt.Errorf("could not parse config file %q: %w", configPath, err)
+	}
+
+	jsonData, err := json.Marshal(tomlValue(tree))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse config file %q: %w", configPath, err)
+	}
+
+	return &configDocument{json: jsonData}, nil
+}
+
+// tomlValue returns value with all TOML datetimes formatted as strings, since they are not part of JSON.
+func tomlValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+
+	case map[string]interface{}:
+		for key, child := range value {
+			value[key] = tomlValue(child)
+		}
+
+	case []map[string]interface{}: // array of tables
+		for _, child := range value {
+			tomlValue(child)
+		}
+
+	case []interface{}:
+		for i, child := range value {
+			value[i] = tomlValue(child)
+		}
+	}
+
+	return value
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+GraphQL describes the GraphQL endpoints of the APIs, where queries are cached by their body instead of by their route,
+since every request to a GraphQL endpoint is a POST request to the same URL. E.g.:
+	{
+		"endpoints": [ "/graphql" ],
+		"types": {
+			"GetPost": [ "Post", "Comment" ]
+		},
+		"bust": {
+			"AddComment": [ "Comment" ],
+			"DeletePost": [ "Post", "ListPosts" ]
+		}
+	}
+*/
+type GraphQL struct {
+	// Endpoints are the routes of the GraphQL endpoints. Queries sent to them are cached by their normalized query text, operation name, and variables.
+	Endpoints []string `json:"endpoints" validate:"omitempty,dive,route"`
+
+	// Types maps the operation names of queries to the names of the types they return, so mutations can bust their entries by type.
+	Types map[string][]string `json:"types" validate:"omitempty,dive,keys,graphqlname,endkeys,dive,graphqlname"`
+
+	// Bust maps the operation names of mutations to the operation names of queries and the type names whose entries they bust.
+	// Mutations that are not listed do not bust any entries.
+	Bust map[string][]string `json:"bust" validate:"omitempty,dive,keys,graphqlname,endkeys,dive,graphqlname"`
+}
+
+// Enabled returns true if there are any GraphQL endpoints.
+func (settings GraphQL) Enabled() bool {
+	return len(settings.Endpoints) > 0
+}
+
+// String describes the GraphQL settings, e.g. "/graphql, 3 typed queries, 2 busting mutations".
+func (settings GraphQL) String() string {
+	return fmt.Sprintf("%s, %d typed queries, %d busting mutations", strings.Join(settings.Endpoints, " "), len(settings.Types), len(settings.Bust))
+}
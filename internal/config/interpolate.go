@@ -0,0 +1,239 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// secretFilePrefix marks a string value in a config file that should be replaced with the contents of a file,
+// e.g. "file:/run/secrets/api-url".
+const secretFilePrefix = "file:"
+
+// interpolationError is an error from resolving a value in a config file, along with the property path of the value.
+type interpolationError struct {
+	path string
+	err  error
+}
+
+func (err *interpolationError) Error() string {
+	return err.err.Error()
+}
+
+func (err *interpolationError) Unwrap() error {
+	return err.err
+}
+
+// interpolator copies a JSON document token by token while resolving environment variables and secret files in its string values.
+// The document is copied in order, so errors are reported in the same order as they appear in the config file.
+type interpolator struct {
+	decoder   *json.Decoder
+	out       bytes.Buffer
+	configDir string // relative secret file paths are resolved from here
+}
+
+// interpolateJSON resolves environment variables and secret files in all string values of the JSON document data
+// and returns the resolved document. Relative secret file paths are resolved from configDir.
+func interpolateJSON(data []byte, configDir string) ([]byte, error) {
+	interpolator := &interpolator{
+		decoder:   json.NewDecoder(bytes.NewReader(data)),
+		configDir: configDir,
+	}
+	interpolator.decoder.UseNumber() // keep numbers exactly as they were written
+
+	if err := interpolator.copyValue("", reflect.TypeOf(Config{})); err != nil {
+		return nil, err
+	}
+
+	return interpolator.out.Bytes(), nil
+}
+
+// copyValue copies the next value from the decoder to the output, where t is the type the value is decoded into.
+// Strings that are decoded into booleans or numbers are converted, so e.g. "${PORT}" can be used as the port.
+func (interpolator *interpolator) copyValue(path string, t reflect.Type) error {
+	token, err := interpolator.decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	t = decodedType(t)
+
+	switch token := token.(type) {
+	case json.Delim:
+		if token == '{' {
+			interpolator.out.WriteByte('{')
+
+			for i := 0; interpolator.decoder.More(); i++ {
+				keyToken, err := interpolator.decoder.Token()
+				if err != nil {
+					return err
+				}
+				key := keyToken.(string)
+
+				if i > 0 {
+					interpolator.out.WriteByte(',')
+				}
+				interpolator.write(key)
+				interpolator.out.WriteByte(':')
+
+				propType, _ := propertyType(t, key)
+				if err := interpolator.copyValue(joinPropPath(path, key), propType); err != nil {
+					return err
+				}
+			}
+		} else {
+			interpolator.out.WriteByte('[')
+
+			var elemType reflect.Type
+			if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+				elemType = t.Elem()
+			}
+
+			for i := 0; interpolator.decoder.More(); i++ {
+				if i > 0 {
+					interpolator.out.WriteByte(',')
+				}
+				if err := interpolator.copyValue(path+"["+strconv.Itoa(i)+"]", elemType); err != nil {
+					return err
+				}
+			}
+		}
+
+		closing, err := interpolator.decoder.Token()
+		if err != nil {
+			return err
+		}
+		interpolator.out.WriteString(closing.(json.Delim).String())
+
+	case string:
+		resolved, err := interpolateString(token, interpolator.configDir)
+		if err != nil {
+			return &interpolationError{path, err}
+		}
+
+		if resolved != token && t != nil && isScalarKind(t.Kind()) {
+			interpolator.write(scalarFromString(resolved))
+		} else {
+			interpolator.write(resolved)
+		}
+
+	default: // numbers, booleans, and null
+		interpolator.write(token)
+	}
+
+	return nil
+}
+
+// write writes value to the output as JSON.
+func (interpolator *interpolator) write(value interface{}) {
+	encoded, _ := json.Marshal(value) // cannot fail, since values are strings, json.Numbers, booleans, or nil
+	interpolator.out.Write(encoded)
+}
+
+// interpolateString replaces ${VAR} and ${VAR:-default} with the value of the environment variable VAR
+// (or default, if VAR is unset or empty) and then reads the value from a file, if it starts with "file:".
+// $${ is written as a literal ${.
+func interpolateString(value, configDir string) (string, error) {
+	resolved, err := expandEnv(value)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(resolved, secretFilePrefix) {
+		return resolved, nil
+	}
+
+	secretPath := strings.TrimPrefix(resolved, secretFilePrefix)
+	if !filepath.IsAbs(secretPath) {
+		secretPath = filepath.Join(configDir, secretPath)
+	}
+
+	secret, err := os.ReadFile(secretPath)
+	if err != nil {
+		return "", fmt.Errorf("could not read secret file: %w", err)
+	}
+
+	// Files usually end with a newline, which is not part of the secret
+	return strings.TrimRight(string(secret), "\r\n"), nil
+}
+
+// expandEnv replaces ${VAR} and ${VAR:-default} in value with environment variables.
+// Unlike os.ExpandEnv, an unset variable without a default is an error instead of an empty string,
+// and $ is only special when it is followed by {, so regex patterns like ^/posts$ are kept as they are.
+func expandEnv(value string) (string, error) {
+	var expanded strings.Builder
+
+	for {
+		start := strings.Index(value, "${")
+		if start == -1 {
+			expanded.WriteString(value)
+			return expanded.String(), nil
+		}
+
+		// $${ is an escaped ${
+		if start > 0 && value[start-1] == '$' {
+			expanded.WriteString(value[:start])
+			expanded.WriteString("{")
+			value = value[start+len("${"):]
+			continue
+		}
+
+		end := strings.Index(value[start:], "}")
+		if end == -1 {
+			return "", fmt.Errorf("%q has a ${ without a closing }", value)
+		}
+		end += start
+
+		expanded.WriteString(value[:start])
+
+		name, fallback, hasFallback := strings.Cut(value[start+len("${"):end], ":-")
+		if name == "" {
+			return "", fmt.Errorf("%q has a ${} without a variable name", value)
+		}
+
+		envValue, isSet := os.LookupEnv(name)
+		switch {
+		case hasFallback && envValue == "":
+			expanded.WriteString(fallback)
+		case isSet:
+			expanded.WriteString(envValue)
+		default:
+			return "", fmt.Errorf("environment variable %q is not set, use ${%s:-default} to set a default value", name, name)
+		}
+
+		value = value[end+1:]
+	}
+}
+
+// isScalarKind returns true for the kinds that are written as booleans or numbers in a config file.
+func isScalarKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+
+	return false
+}
+
+// scalarFromString returns the boolean or number written in value, or value itself if it is neither,
+// in which case decoding it will report a type error.
+func scalarFromString(value string) interface{} {
+	if value == "true" || value == "false" {
+		return value == "true"
+	}
+
+	var number json.Number
+	if err := json.Unmarshal([]byte(strings.TrimSpace(value)), &number); err == nil {
+		return number
+	}
+
+	return value
+}
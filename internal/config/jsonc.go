@@ -0,0 +1,147 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// standardizeJSON turns JSON with comments (JSONC) into standard JSON by replacing
+// line comments (//), block comments (/* */), and trailing commas with whitespace.
+// Since nothing is removed, offsets in the standardized JSON point to the same line and column in the original.
+func standardizeJSON(data []byte) []byte {
+	standard := stripComments(data)
+	stripTrailingCommas(standard)
+
+	return standard
+}
+
+// stripComments returns a copy of data where all comments outside of strings are replaced with spaces.
+// Newlines in comments are kept, so line numbers stay the same.
+func stripComments(data []byte) []byte {
+	stripped := make([]byte, len(data))
+	copy(stripped, data)
+
+	inString := false
+
+	for i := 0; i < len(stripped); i++ {
+		char := stripped[i]
+
+		if inString {
+			switch char {
+			case '\\':
+				i++ // skip the escaped character, which could be a quote
+			case '"':
+				inString = false
+			}
+			continue
+		}
+
+		if char == '"' {
+			inString = true
+			continue
+		}
+
+		if char != '/' || i+1 >= len(stripped) {
+			continue
+		}
+
+		switch stripped[i+1] {
+		case '/': // line comment, ends at the next newline
+			for ; i < len(stripped) && stripped[i] != '\n'; i++ {
+				stripped[i] = ' '
+			}
+
+		case '*': // block comment, ends at the next */
+			end := bytes.Index(stripped[i+2:], []byte("*/"))
+			if end == -1 {
+				end = len(stripped) // unterminated comments run to the end of the file
+			} else {
+				end += i + 2 + len("*/")
+			}
+
+			for ; i < end; i++ {
+				if stripped[i] != '\n' {
+					stripped[i] = ' '
+				}
+			}
+			i-- // the loop will step past the last character of the comment
+		}
+	}
+
+	return stripped
+}
+
+// stripTrailingCommas replaces commas that are directly followed by (whitespace and) a closing bracket or brace with a space.
+// It expects comments to have been stripped already.
+func stripTrailingCommas(data []byte) {
+	inString := false
+	lastComma := -1 // index of a comma that has only been followed by whitespace so far
+
+	for i := 0; i < len(data); i++ {
+		char := data[i]
+
+		if inString {
+			switch char {
+			case '\\':
+				i++
+			case '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch char {
+		case ' ', '\t', '\n', '\r':
+			continue
+		case '}', ']':
+			if lastComma != -1 {
+				data[lastComma] = ' '
+			}
+		case '"':
+			inString = true
+		}
+
+		if char == ',' {
+			lastComma = i
+		} else {
+			lastComma = -1
+		}
+	}
+}
+
+// newParseError returns an error that explains why the config file at path could not be parsed,
+// and points to the line and column of the problem if err is a json.SyntaxError.
+func newParseError(path string, data []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		return fmt.Errorf("could not parse config file %q: %w", path, err)
+	}
+
+	line, column := position(data, syntaxErr.Offset)
+
+	return fmt.Errorf("could not parse config file %q at line %d, column %d: %w", path, line, column, err)
+}
+
+// position returns the (1-based) line and column of the last byte before offset in data.
+// This is where encoding/json reports errors, since its offsets point just past the offending character.
+func position(data []byte, offset int64) (line, column int) {
+	return lineAndColumn(data, offset-1)
+}
+
+// lineAndColumn returns the (1-based) line and column of the byte at index in data.
+func lineAndColumn(data []byte, index int64) (line, column int) {
+	if index > int64(len(data)) {
+		index = int64(len(data))
+	}
+	if index < 0 {
+		index = 0
+	}
+
+	before := data[:index]
+	line = bytes.Count(before, []byte("\n")) + 1
+	column = int(index) - bytes.LastIndexByte(before, '\n')
+
+	return line, column
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// KeyPolicy describes how the URL of a request is normalized before it is used in its cache key,
+// so requests for the same resource share an entry, even if their query strings are written differently.
+type KeyPolicy struct {
+	// SortQuery sorts the query params by name, so e.g. "?b=2&a=1" and "?a=1&b=2" share an entry.
+	// Params with the same name keep their order, since it can matter to the API.
+	SortQuery bool `json:"sortQuery"`
+
+	// IgnoreParams are the names of query params that are left out of the key, e.g. "utm_*" or "_". A "*" matches any characters.
+	IgnoreParams []string `json:"ignoreParams" validate:"omitempty,dive,paramglob"`
+
+	// AllowParams are the names of the only query params that are kept in the key, e.g. "page". A "*" matches any characters.
+	// Omit them to keep all params that are not ignored.
+	AllowParams []string `json:"allowParams" validate:"omitempty,dive,paramglob"`
+
+	// LowercasePath lowercases the path, so e.g. "/Posts/ABC" and "/posts/abc" share an entry. The query string is not lowercased.
+	LowercasePath bool `json:"lowercasePath"`
+
+	// Template is the format of the keys, e.g. "{method}:{path}?{query}|{header:X-Tenant}|{cookie:lang}", for responses that differ by more than their URL.
+	// It must start with "{method}:", and it can contain {host}, {path}, {query}, {header:<name>}, and {cookie:<name>}, where {path} and {query} are normalized.
+	// A "?" before {query} is left out when the query string is empty. Omit it to use "{method}:{path}?{query}".
+	Template string `json:"template" validate:"omitempty,keytemplate"`
+}
+
+// Normalize returns the URL (a path with an optional query string, like fiber.Ctx.OriginalURL) normalized by the policy.
+func (policy KeyPolicy) Normalize(requestURL string) string {
+	requestPath, query, hasQuery := strings.Cut(requestURL, "?")
+
+	if policy.LowercasePath {
+		requestPath = strings.ToLower(requestPath)
+	}
+
+	if !hasQuery {
+		return requestPath
+	}
+
+	// The params are kept as they were written, so only their order and presence change
+	var params []string
+	for _, param := range strings.Split(query, "&") {
+		if param == "" {
+			continue
+		}
+		if policy.keepsParam(paramName(param)) {
+			params = append(params, param)
+		}
+	}
+
+	if policy.SortQuery {
+		sort.SliceStable(params, func(i, j int) bool {
+			return paramName(params[i]) < paramName(params[j])
+		})
+	}
+
+	if len(params) == 0 {
+		return requestPath
+	}
+
+	return requestPath + "?" + strings.Join(params, "&")
+}
+
+// keepsParam returns true if the query param with the name is kept in the key.
+func (policy KeyPolicy) keepsParam(name string) bool {
+	if matchesAnyParam(policy.IgnoreParams, name) {
+		return false
+	}
+
+	return len(policy.AllowParams) == 0 || matchesAnyParam(policy.AllowParams, name)
+}
+
+// paramName returns the decoded name of a query param like "name=value".
+func paramName(param string) string {
+	name, _, _ := strings.Cut(param, "=")
+	if decoded, err := url.QueryUnescape(name); err == nil {
+		return decoded
+	}
+
+	return name
+}
+
+// matchesAnyParam returns true if the param name matches one of the name patterns, where "*" matches any characters.
+func matchesAnyParam(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String describes the policy, e.g. "sorted query, ignores utm_*, lowercase path, keys like {method}:{path}|{header:X-Tenant}".
+func (policy KeyPolicy) String() string {
+	var parts []string
+	if policy.SortQuery {
+		parts = append(parts, "sorted query")
+	}
+	if len(policy.IgnoreParams) > 0 {
+		parts = append(parts, "ignores "+strings.Join(policy.IgnoreParams, " "))
+	}
+	if len(policy.AllowParams) > 0 {
+		parts = append(parts, "only "+strings.Join(policy.AllowParams, " "))
+	}
+	if policy.LowercasePath {
+		parts = append(parts, "lowercase path")
+	}
+	if policy.Template != "" {
+		parts = append(parts, "keys like "+policy.Template)
+	}
+
+	if len(parts) == 0 {
+		return "unchanged"
+	}
+
+	return strings.Join(parts, ", ")
+}
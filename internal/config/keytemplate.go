@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// keyTemplateVarRegex matches a variable in a key template, e.g. "{path}" or "{header:X-Tenant}", where the name of a header or cookie is the second group.
+var keyTemplateVarRegex = regexp.MustCompile(`\{(method|host|path|query|header|cookie)(?::([^{}]+))?\}`)
+
+// bustSegmentRegex matches the variables that are replaced with the values of a busting request in bust patterns.
+var bustSegmentRegex = regexp.MustCompile(`\{(host|header:[^{}]+|cookie:[^{}]+)\}`)
+
+// KeyRequest is the part of a request that cache keys are made from.
+type KeyRequest struct {
+	Method string
+	Host   string
+	URL    string // the path with an optional query string, like fiber.Ctx.OriginalURL
+
+	// Header returns the value of the request header with the name, or an empty string if the request has no such header.
+	Header func(name string) string
+	// Cookie returns the value of the cookie with the name, or an empty string if the request has no such cookie.
+	Cookie func(name string) string
+}
+
+// Key returns the cache entry key of the request, without the namespace of its upstream.
+// Without a Template, it is in the format [http method]:[url], where the url is normalized by the policy.
+func (policy KeyPolicy) Key(request KeyRequest) string {
+	normalized := policy.Normalize(request.URL)
+	if policy.Template == "" {
+		return request.Method + ":" + normalized
+	}
+
+	requestPath, query, _ := strings.Cut(normalized, "?")
+
+	template := policy.Template
+	if query == "" {
+		template = strings.ReplaceAll(template, "?{query}", "") // so keys of requests without a query string do not end with "?"
+	}
+
+	return keyTemplateVarRegex.ReplaceAllStringFunc(template, func(variable string) string {
+		match := keyTemplateVarRegex.FindStringSubmatch(variable)
+
+		switch match[1] {
+		case "method":
+			return request.Method
+		case "host":
+			return escapeKeySegment(request.Host)
+		case "path":
+			return requestPath
+		case "query":
+			return query
+		case "header":
+			return escapeKeySegment(request.Header(match[2]))
+		default: // cookie
+			return escapeKeySegment(request.Cookie(match[2]))
+		}
+	})
+}
+
+// escapeKeySegment returns the value of a host, header, or cookie percent-encoded like a query param,
+// so values that contain the separators of a template, like "|" or "=", cannot make the keys of different requests the same.
+func escapeKeySegment(value string) string {
+	return url.QueryEscape(value)
+}
+
+// checkKeyTemplate returns an error describing what is wrong with the key template, or nil if it is valid.
+// Templates must start with "{method}:", since that is how keys are told apart from the namespace of their upstream.
+func checkKeyTemplate(template string) error {
+	if !strings.HasPrefix(template, "{method}:") {
+		return fmt.Errorf("must start with {method}:")
+	}
+
+	for _, match := range keyTemplateVarRegex.FindAllStringSubmatch(template, -1) {
+		named := match[1] == "header" || match[1] == "cookie"
+		if named != (match[2] != "") {
+			return fmt.Errorf("has an invalid variable %s", match[0])
+		}
+	}
+
+	if rest := keyTemplateVarRegex.ReplaceAllString(template, ""); strings.ContainsAny(rest, "{}") {
+		return fmt.Errorf("may only contain the variables {method}, {host}, {path}, {query}, {header:<name>}, and {cookie:<name>}")
+	}
+
+	return nil
+}
+
+/*
+HydrateKeySegments returns the bust patterns with the variables {host}, {header:<name>}, and {cookie:<name>}
+replaced with the values of the busting request, encoded like in keys and escaped so they are matched literally.
+That lets a busting request only bust the entries of e.g. its own tenant, with a pattern like ^GET:/posts\|{header:X-Tenant}$.
+Other braces are left as they are, so quantifiers like {2} still work.
+*/
+func HydrateKeySegments(request KeyRequest, patterns []string) []string {
+	hydrated := make([]string, len(patterns))
+
+	for i, pattern := range patterns {
+		hydrated[i] = bustSegmentRegex.ReplaceAllStringFunc(pattern, func(variable string) string {
+			kind, name, _ := strings.Cut(strings.Trim(variable, "{}"), ":")
+
+			switch kind {
+			case "host":
+				return regexp.QuoteMeta(escapeKeySegment(request.Host))
+			case "header":
+				return regexp.QuoteMeta(escapeKeySegment(request.Header(name)))
+			default: // cookie
+				return regexp.QuoteMeta(escapeKeySegment(request.Cookie(name)))
+			}
+		})
+	}
+
+	return hydrated
+}
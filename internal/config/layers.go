@@ -0,0 +1,240 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Properties that make a config file build on top of other config files.
+// They are only allowed at the top level of a config file and are not part of the Config.
+const (
+	extendsProp = "extends" // the base config file(s) that this file overrides
+	includeProp = "include" // config file(s) with shared settings, e.g. a route set, that this file overrides
+)
+
+// configLayer is a config file converted to JSON with environment variables and secret files resolved
+// and with all the config files it extends and includes merged in.
+type configLayer struct {
+	json      []byte
+	positions filePositions
+	sources   []string // paths of all the config files the layer was built from, in the order they were merged
+}
+
+// loadLayer reads the config file at configPath in the given format (chosen by the file extension if empty) and merges it on top of
+// the files it extends and includes. visiting holds the absolute paths of the files currently being loaded, to detect cycles.
+func loadLayer(configPath, format string, visiting []string) (*configLayer, error) {
+	absPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, visitingPath := range visiting {
+		if visitingPath == absPath {
+			cycle := append(append([]string{}, visiting[i:]...), absPath)
+			return nil, fmt.Errorf("config file %q extends or includes itself: %s", configPath, strings.Join(cycle, " -> "))
+		}
+	}
+	visiting = append(visiting, absPath)
+
+	format, err = LoadOptions{Format: format}.format(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the configuration file
+	configFile, err := os.Open(configPath)
+	if err != nil {
+		return nil, err
+	}
+	defer configFile.Close()
+
+	configByteValue, err := ioutil.ReadAll(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert the file to JSON, so all formats are decoded the same way
+	doc, err := decodeDocument(configPath, format, configByteValue)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find out where every value is in the original file to explain any errors
+	positions := doc.positions
+	if doc.original != nil {
+		originalScanner, err := scanConfig(doc.json)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse config file %q: %w", configPath, err)
+		}
+		positions = originalScanner.positions(doc.original)
+	}
+
+	// Resolve environment variables and secret files relative to this file, before the files are merged
+	resolvedJSON, err := interpolateJSON(doc.json, filepath.Dir(configPath))
+	if err != nil {
+		var interpolationErr *interpolationError
+		if errors.As(err, &interpolationErr) {
+			return nil, fmt.Errorf("could not load config file %q: property '%s'%s: %w", configPath, interpolationErr.path, positions.describe(interpolationErr.path, false), interpolationErr.err)
+		}
+		return nil, fmt.Errorf("could not load config file %q: %w", configPath, err)
+	}
+
+	layer := &configLayer{
+		json:      resolvedJSON,
+		positions: positions,
+		sources:   []string{configPath},
+	}
+
+	tree, isObject := decodeTree(resolvedJSON).(map[string]interface{})
+	if !isObject {
+		return layer, nil // let decoding the Config report that the file is not an object
+	}
+
+	var refs []string
+	for _, prop := range []string{extendsProp, includeProp} {
+		propRefs, err := layerRefs(tree, prop)
+		if err != nil {
+			return nil, fmt.Errorf("could not load config file %q: property '%s'%s %w", configPath, prop, positions.describe(prop, false), err)
+		}
+		refs = append(refs, propRefs...)
+	}
+
+	_, hasExtends := tree[extendsProp]
+	_, hasInclude := tree[includeProp]
+	if !hasExtends && !hasInclude {
+		return layer, nil // keep the document as it was written, so errors are reported in the same order as in the file
+	}
+
+	// Merge the files in order, then let this file override them
+	merged := make(map[string]interface{})
+	mergedPositions := filePositions{
+		keys:   make(map[string]filePosition),
+		values: make(map[string]filePosition),
+	}
+	var sources []string
+
+	for _, ref := range refs {
+		refPath := ref
+		if !filepath.IsAbs(refPath) {
+			refPath = filepath.Join(filepath.Dir(configPath), refPath)
+		}
+
+		refLayer, err := loadLayer(refPath, "", visiting)
+		if err != nil {
+			return nil, err
+		}
+
+		merged = mergeTrees(merged, decodeTree(refLayer.json))
+		mergedPositions.merge(refLayer.positions, refPath)
+		sources = appendUnique(sources, refLayer.sources...)
+	}
+
+	delete(tree, extendsProp)
+	delete(tree, includeProp)
+
+	merged = mergeTrees(merged, tree)
+	mergedPositions.merge(positions, "")
+
+	mergedJSON, err := json.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("could not load config file %q: %w", configPath, err)
+	}
+
+	return &configLayer{
+		json:      mergedJSON,
+		positions: mergedPositions,
+		sources:   appendUnique(sources, configPath),
+	}, nil
+}
+
+// layerRefs returns the paths in the extends or include property prop of the config file tree,
+// which can be a single path or an array of paths.
+func layerRefs(tree map[string]interface{}, prop string) ([]string, error) {
+	switch value := tree[prop].(type) {
+	case nil:
+		return nil, nil
+
+	case string:
+		return []string{value}, nil
+
+	case []interface{}:
+		refs := make([]string, len(value))
+		for i, elem := range value {
+			ref, isString := elem.(string)
+			if !isString {
+				return nil, fmt.Errorf("must be a path or an array of paths, but element %d is not a string", i)
+			}
+			refs[i] = ref
+		}
+		return refs, nil
+	}
+
+	return nil, fmt.Errorf("must be a path or an array of paths")
+}
+
+// mergeTrees merges overlay into base and returns the result. The rules are the same as JSON Merge Patch (RFC 7396):
+// objects (like cache and bust) are merged property by property, all other values (including arrays) in overlay replace the
+// values in base, and null in overlay removes the property from base. E.g. merging
+//
+//	{ "cache": { "GET": [ "/users" ] } }
+//
+// into
+//
+//	{ "capacity": 5, "cache": { "GET": [ "/posts" ], "HEAD": [ "/posts" ] } }
+//
+// results in
+//
+//	{ "capacity": 5, "cache": { "GET": [ "/users" ], "HEAD": [ "/posts" ] } }
+func mergeTrees(base, overlay interface{}) map[string]interface{} {
+	baseObject, _ := base.(map[string]interface{})
+	if baseObject == nil {
+		baseObject = make(map[string]interface{})
+	}
+
+	overlayObject, _ := overlay.(map[string]interface{})
+
+	for key, value := range overlayObject {
+		if value == nil {
+			delete(baseObject, key)
+			continue
+		}
+
+		valueObject, isObject := value.(map[string]interface{})
+		if !isObject {
+			baseObject[key] = value
+			continue
+		}
+
+		baseObject[key] = mergeTrees(baseObject[key], valueObject)
+	}
+
+	return baseObject
+}
+
+// decodeTree decodes the valid JSON document data into maps, slices, and values, keeping numbers as they were written.
+func decodeTree(data []byte) interface{} {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var tree interface{}
+	decoder.Decode(&tree) // cannot fail, since data has already been parsed
+
+	return tree
+}
+
+// appendUnique appends the values that are not already in slice.
+func appendUnique(slice []string, values ...string) []string {
+	for _, value := range values {
+		if !contains(slice, value) {
+			slice = append(slice, value)
+		}
+	}
+
+	return slice
+}
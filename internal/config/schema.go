@@ -0,0 +1,405 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/magnus-bb/cache-me-ousside/internal/graphql"
+)
+
+// schemaProp is where editors look for the JSON Schema of a file. It is allowed at the top level of all config files,
+// even though it is not part of the Config.
+const schemaProp = "$schema"
+
+const (
+	// durationPattern matches the duration strings that Duration can be unmarshaled from, e.g. "1h30m" or "0".
+	durationPattern = `^([0-9]*(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+$|^0$`
+
+	// interpolationPattern matches strings that are resolved when the config file is loaded,
+	// which can become any value, e.g. "${PORT}" for a number.
+	interpolationPattern = `\$\{[^}]+\}|^file:`
+)
+
+// schemaDescriptions describe the Config props in the JSON Schema, so editors can show them.
+var schemaDescriptions = map[string]string{
+	"Capacity":        "The limit to how much data can be stored in the cache, either in entries or in capacityUnit.",
+	"CapacityUnit":    "The memory unit of the capacity. Omit or use \"\" to measure the capacity in entries.",
+	"Hostname":        "The hostname where the cache server can be accessed.",
+	"Port":            "The port where the cache server can be accessed.",
+	"TLS":             "Serve HTTPS with a certificate that is reloaded when its files change.",
+	"ApiUrl":          "The URL of the API to which all requests are proxied and cached from. Required, but can also be set in an extended file, with --api-url, or with API_URL.",
+	"LogFilePath":     "The path to a log file to use instead of the terminal.",
+	"LogMaxSize":      "The size in megabytes the log file can reach before it is rotated. Omit or use 0 to disable size-based rotation.",
+	"LogMaxAge":       "How long the log file is written to before it is rotated, e.g. \"24h\". Omit to disable age-based rotation.",
+	"LogMaxBackups":   "How many rotated log files to keep. Omit or use 0 to keep all rotated log files.",
+	"LogCompress":     "Gzip rotated log files.",
+	"AccessLogPath":   "The path to an access log file with a line for every request, or \"stdout\" or \"stderr\".",
+	"AccessLogFormat": "The format of the access log.",
+	"ShutdownTimeout": "How long in-flight requests are given to finish when the server is shut down, e.g. \"30s\". Use \"0s\" to wait for as long as it takes.",
+	"WatchConfig":     "Reload the configuration when the configuration file changes.",
+	"Cache":           "HTTP methods with the routes where responses are cached. POST requests are cached by a hash of their body as well as their route. Required, but can also be set in an extended or included file or with flags.",
+	"CacheBodyLimit":  "The size in kilobytes that the body of a POST request to a cached route can have. Larger requests are proxied without being cached.",
+	"CacheTTL":        "How long entries are fresh after they are saved, e.g. \"5m\". Expired entries with an ETag or Last-Modified header are revalidated with the API. Use \"0s\" for entries that never expire.",
+	"Bust":            "HTTP methods with routes that bust cache entries matching a list of regex patterns. Route params like :id are inserted into the patterns.",
+	"Upstreams":       "Named APIs that requests are proxied to instead of apiUrl when they match one of the routes of the API. Cache keys of an upstream start with its name, e.g. \"users|GET:/users/1\".",
+	"Upstream.Url":    "The URL of the API.",
+	"Upstream.Routes": "The routes of the requests that are proxied to the API, e.g. \"/users/*\".",
+	"ApiReplicas":     "The URLs of more servers with the same API as apiUrl, which requests are balanced across along with apiUrl.",
+	"Balance":         "How requests are balanced across apiUrl and apiReplicas. Default is \"round-robin\".",
+	"HealthCheck":     "Checks apiUrl and apiReplicas, so servers that fail are taken out of rotation until they recover.",
+	"StatsPath":       "A path where the cache server responds with the state of the upstreams as JSON instead of proxying the request, e.g. \"/_stats\".",
+
+	"Upstream.Replicas":    "The URLs of more servers with the same API as url, which requests are balanced across along with url.",
+	"Upstream.Balance":     "How requests are balanced across url and replicas. Default is \"round-robin\".",
+	"Upstream.HealthCheck": "Checks url and replicas, so servers that fail are taken out of rotation until they recover.",
+
+	"CircuitBreaker":          "Makes requests to apiUrl and apiReplicas fail fast while they keep failing.",
+	"Upstream.CircuitBreaker": "Makes requests to url and replicas fail fast while they keep failing.",
+
+	"Proxy":          "Timeouts, connection limits, and retries of the requests that are proxied to apiUrl and apiReplicas.",
+	"Upstream.Proxy": "Timeouts, connection limits, and retries of the requests that are proxied to url and replicas.",
+
+	"ProxySettings.DialTimeout":     "How long connecting to a server can take. Default is \"3s\".",
+	"ProxySettings.ReadTimeout":     "How long a server can take to respond, including reading the full response. Omit it to wait for as long as it takes.",
+	"ProxySettings.WriteTimeout":    "How long sending a request to a server can take. Omit it to wait for as long as it takes.",
+	"ProxySettings.MaxConnsPerHost": "How many connections can be open to each server at the same time. Default is 512.",
+	"ProxySettings.IdleConnTimeout": "How long a connection can be idle before it is closed. Default is \"10s\". The number of idle connections cannot be limited, besides by maxConnsPerHost, so a shorter timeout closes them sooner instead.",
+	"ProxySettings.Retries":         "How many times a GET or HEAD request is retried when it fails with an error or a 502, 503, or 504 status.",
+	"ProxySettings.RetryBackoff":    "How long to wait before the first retry, which is doubled for every retry after it. Default is \"100ms\".",
+
+	"ApiTLS":       "The CAs, client certificate, and server name that are used to connect to apiUrl and apiReplicas over HTTPS.",
+	"Upstream.TLS": "The CAs, client certificate, and server name that are used to connect to url and replicas over HTTPS.",
+
+	"UpstreamTLS.CAFile":             "The path to PEM encoded certificates of the CAs that the certificates of the servers must be signed by, instead of the CAs of the system.",
+	"UpstreamTLS.CertFile":           "The path to a PEM encoded client certificate that is presented to the servers (mutual TLS).",
+	"UpstreamTLS.KeyFile":            "The path to the PEM encoded private key of certFile.",
+	"UpstreamTLS.ServerName":         "The name that is sent to the servers with SNI and must be in their certificates, instead of the hostname of their URL.",
+	"UpstreamTLS.InsecureSkipVerify": "Accept any certificate from the servers. Only use this for development.",
+
+	"ServerTLS.CertFile":     "The path to a PEM encoded certificate (chain) for the cache server. TLS is disabled unless both certFile and keyFile are set.",
+	"ServerTLS.KeyFile":      "The path to the PEM encoded private key of certFile.",
+	"ServerTLS.MinVersion":   "The lowest TLS version that clients can use.",
+	"ServerTLS.CipherSuites": "The cipher suites that clients can use with TLS 1.2 and below. Omit to use the secure defaults of Go.",
+	"ServerTLS.ClientCAFile": "The path to PEM encoded certificates of the CAs that client certificates must be signed by. Omit to not ask clients for certificates.",
+	"ServerTLS.ClientAuth":   "Whether every client must present a certificate (\"require\"), or only certificates that are presented are verified (\"verify-if-given\"). Default is \"require\".",
+
+	"CircuitBreaker.FailureThreshold": "How many requests in a row must fail (with an error or a 5xx status) to open the breaker. Omit it or use 0 to disable the breaker.",
+	"CircuitBreaker.OpenTimeout":      "How long the breaker stays open before requests can try the API again. Default is \"30s\".",
+	"CircuitBreaker.HalfOpenRequests": "How many requests can try the API when the breaker is half-open, and how many must succeed to close it. Default is 1.",
+	"CircuitBreaker.WhileOpen":        "How requests are handled while the breaker is open. \"fail\" (default) answers requests that need the API with 503, and \"stale\" does the same, but does not bust entries, so cached responses are still served.",
+
+	"KeyPolicies":             "Routes with how the URLs of their requests are normalized in cache keys, so requests for the same resource share an entry. Bust patterns are hydrated with the normalized params.",
+	"KeyPolicy.SortQuery":     "Sort the query params by name. Params with the same name keep their order.",
+	"KeyPolicy.IgnoreParams":  "Names of query params that are left out of the key, e.g. \"utm_*\". A \"*\" matches any characters.",
+	"KeyPolicy.AllowParams":   "Names of the only query params that are kept in the key. A \"*\" matches any characters. Omit to keep all params that are not ignored.",
+	"KeyPolicy.LowercasePath": "Lowercase the path, but not the query string.",
+	"KeyPolicy.Template":      "Format of the keys, e.g. \"{method}:{path}?{query}|{header:X-Tenant}|{cookie:lang}\". Must start with \"{method}:\".",
+
+	"Idempotency":              "Replay the responses of POST, PUT, and PATCH requests to retries with the same Idempotency-Key header.",
+	"Idempotency.Retention":    "How long a response is replayed to retries with the same key, e.g. \"24h\". Omit it to disable idempotency keys.",
+	"Idempotency.MaxKeys":      "How many keys are kept at the same time. Requests with new keys are proxied without being stored when there are too many. Default is 10000.",
+	"Idempotency.Wait":         "How long a retry waits for the first request with the same key to finish before it is rejected with 409 Conflict, e.g. \"10s\". Default is \"30s\".",
+	"Idempotency.ClientHeader": "The request header that identifies a client, so clients cannot get the responses to each other's keys. Default is \"Authorization\".",
+
+	"GraphQL":           "GraphQL endpoints where queries are cached by their normalized query text, operation name, and variables, and mutations bust them by operation or type name.",
+	"GraphQL.Endpoints": "The routes of the GraphQL endpoints, e.g. \"/graphql\".",
+	"GraphQL.Types":     "The operation names of queries with the names of the types they return, e.g. {\"GetPost\": [\"Post\", \"Comment\"]}, so mutations can bust them by type.",
+	"GraphQL.Bust":      "The operation names of mutations with the operation names of queries and type names whose entries they bust, e.g. {\"AddComment\": [\"Comment\"]}.",
+
+	"HealthCheck.Path":               "The path that is requested on every server, e.g. \"/health\". Omit it to disable health checks.",
+	"HealthCheck.Interval":           "How long to wait between checks of a server. Default is \"10s\".",
+	"HealthCheck.Timeout":            "How long a server has to respond before the check fails. Default is \"2s\".",
+	"HealthCheck.HealthyThreshold":   "How many checks in a row must succeed before a failing server is put back into rotation. Default is 2.",
+	"HealthCheck.UnhealthyThreshold": "How many checks in a row must fail before a server is taken out of rotation. Default is 3.",
+}
+
+// Schema returns a JSON Schema for config files, generated from the Config and its validate tags.
+// It can be used by editors and CI to validate config files before they are loaded.
+// Props are not required by the schema, since they can have defaults or be set in other files, with flags, or with environment variables.
+func Schema() ([]byte, error) {
+	schema := structSchema(reflect.TypeOf(Config{}), New())
+
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "cache-me-ousside configuration"
+
+	properties := schema["properties"].(map[string]interface{})
+	properties[schemaProp] = map[string]interface{}{
+		"type":        "string",
+		"description": "The JSON Schema of this file.",
+	}
+
+	layerRefsSchema := map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		},
+	}
+	properties[extendsProp] = withDescription(layerRefsSchema, "The path(s) of config files that this file overrides.")
+	properties[includeProp] = withDescription(layerRefsSchema, "The path(s) of config files with shared settings that this file overrides.")
+
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false) // keep route patterns readable
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(schema); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// structSchema returns the schema of an object that is decoded into the struct t.
+// defaults is a pointer to a struct of type t with the default values, which are added to the schema when they are not zero.
+// Descriptions are looked up by field name for the Config and by type and field name (e.g. "Upstream.Url") for other structs.
+func structSchema(t reflect.Type, defaults interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []interface{}
+	defaultValues := reflect.Indirect(reflect.ValueOf(defaults))
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name := jsonPropName(field)
+		if name == "" {
+			continue
+		}
+
+		tags := strings.Split(field.Tag.Get("validate"), ",")
+		propSchema := valueSchema(field.Type, tags)
+
+		descriptionKey := field.Name
+		if t != reflect.TypeOf(Config{}) {
+			descriptionKey = t.Name() + "." + field.Name
+
+			// Props of the Config can be set in other files, with flags, or with environment variables, but nested props cannot
+			if tags[0] == "required" {
+				required = append(required, name)
+			}
+		}
+		if description, found := schemaDescriptions[descriptionKey]; found {
+			propSchema["description"] = description
+		}
+
+		defaultValue := defaultValues.Field(i)
+		if !defaultValue.IsZero() && (isScalarKind(defaultValue.Kind()) || defaultValue.Kind() == reflect.String) {
+			propSchema["default"] = schemaDefault(defaultValue)
+		}
+
+		properties[name] = propSchema
+	}
+
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+// valueSchema returns the schema of a value that is decoded into t and validated with the validate tags.
+// Scalar values can also be written as a string with environment variables or a secret file, since they are resolved before the value is decoded.
+func valueSchema(t reflect.Type, tags []string) map[string]interface{} {
+	schema := typeSchema(t, tags)
+	if schema["type"] == "object" || schema["type"] == "array" {
+		return schema
+	}
+
+	return withInterpolation(schema)
+}
+
+// typeSchema returns the schema of a value that is decoded into t and validated with the validate tags.
+// Tags after "dive" apply to the elements of slices and maps, and tags between "keys" and "endkeys" apply to the keys of maps.
+func typeSchema(t reflect.Type, tags []string) map[string]interface{} {
+	ownTags, elemTags, keyTags := splitDiveTags(tags)
+
+	schema := make(map[string]interface{})
+
+	switch {
+	case t == reflect.TypeOf(Duration(0)):
+		schema["type"] = "string"
+		schema["pattern"] = durationPattern
+		return schema
+
+	case t.Kind() == reflect.Struct:
+		return structSchema(t, reflect.New(t).Interface())
+
+	case t.Kind() == reflect.Map:
+		schema["type"] = "object"
+		schema["additionalProperties"] = valueSchema(t.Elem(), elemTags)
+		if len(keyTags) > 0 {
+			schema["propertyNames"] = typeSchema(t.Key(), keyTags) // keys are not interpolated
+		}
+
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		schema["type"] = "array"
+		schema["items"] = valueSchema(t.Elem(), elemTags)
+
+	case t.Kind() == reflect.Bool:
+		schema["type"] = "boolean"
+
+	case t.Kind() == reflect.String:
+		schema["type"] = "string"
+
+	case isScalarKind(t.Kind()):
+		schema["type"] = "number"
+		if strings.HasPrefix(t.Kind().String(), "int") || strings.HasPrefix(t.Kind().String(), "uint") {
+			schema["type"] = "integer"
+		}
+		if strings.HasPrefix(t.Kind().String(), "uint") {
+			schema["minimum"] = 0
+		}
+	}
+
+	omitEmpty := false
+
+	for _, tag := range ownTags {
+		name, param, _ := strings.Cut(tag, "=")
+
+		switch name {
+		case "omitempty":
+			omitEmpty = true
+
+		case "gt":
+			limit, err := strconv.Atoi(param)
+			if err != nil {
+				continue
+			}
+
+			switch schema["type"] {
+			case "object":
+				schema["minProperties"] = limit + 1
+			case "array":
+				schema["minItems"] = limit + 1
+			}
+
+		case "min", "max":
+			limit, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				continue
+			}
+
+			switch schema["type"] {
+			case "integer", "number":
+				schema[map[string]string{"min": "minimum", "max": "maximum"}[name]] = limit
+			case "string":
+				schema[map[string]string{"min": "minLength", "max": "maxLength"}[name]] = int(limit)
+			case "array":
+				schema[map[string]string{"min": "minItems", "max": "maxItems"}[name]] = int(limit)
+			case "object":
+				schema[map[string]string{"min": "minProperties", "max": "maxProperties"}[name]] = int(limit)
+			}
+
+		case "oneof":
+			var enum []interface{}
+			if omitEmpty {
+				enum = append(enum, "")
+			}
+			for _, value := range strings.Fields(param) {
+				enum = append(enum, value)
+			}
+			schema["enum"] = enum
+
+		case "url":
+			schema["format"] = "uri"
+
+		case "hostname_rfc1123":
+			schema["format"] = "hostname"
+
+		case "route":
+			schema["pattern"] = RouteRegex.String()
+
+		case "upstreamname":
+			schema["pattern"] = UpstreamNameRegex.String()
+
+		case "graphqlname":
+			schema["pattern"] = graphql.NameRegex.String()
+
+		case "startswith":
+			schema["pattern"] = "^" + regexp.QuoteMeta(param)
+
+		case "keytemplate":
+			schema["pattern"] = "^" + regexp.QuoteMeta("{method}:")
+
+		case "ciphersuite":
+			var enum []interface{}
+			for _, suite := range tls.CipherSuites() {
+				enum = append(enum, suite.Name)
+			}
+			schema["enum"] = enum
+		}
+	}
+
+	return schema
+}
+
+// splitDiveTags splits validate tags into the tags that apply to the value itself, the tags that apply to its elements (after "dive"),
+// and the tags that apply to the keys of a map (between "keys" and "endkeys" right after "dive", or all tags after "keys" without "endkeys").
+func splitDiveTags(tags []string) (ownTags, elemTags, keyTags []string) {
+	for i, tag := range tags {
+		if tag != "dive" {
+			continue
+		}
+
+		ownTags, elemTags = tags[:i], tags[i+1:]
+
+		if len(elemTags) > 0 && elemTags[0] == "keys" {
+			keyTags, elemTags = elemTags[1:], nil
+			for j, keyTag := range keyTags {
+				if keyTag == "endkeys" {
+					keyTags, elemTags = keyTags[:j], keyTags[j+1:]
+					break
+				}
+			}
+		}
+
+		// The elements of maps and slices are validated with "required" implied, which the schema does not need
+		return ownTags, elemTags, keyTags
+	}
+
+	return tags, nil, nil
+}
+
+// withInterpolation allows a value to also be written as a string with environment variables or a secret file.
+func withInterpolation(schema map[string]interface{}) map[string]interface{} {
+	if schema["type"] == "string" && len(schema) == 1 {
+		return schema // all strings are allowed anyway
+	}
+
+	return map[string]interface{}{
+		"anyOf": []interface{}{
+			schema,
+			map[string]interface{}{"type": "string", "pattern": interpolationPattern},
+		},
+	}
+}
+
+// withDescription returns a copy of schema with a description.
+func withDescription(schema map[string]interface{}, description string) map[string]interface{} {
+	described := make(map[string]interface{}, len(schema)+1)
+	for key, value := range schema {
+		described[key] = value
+	}
+	described["description"] = description
+
+	return described
+}
+
+// schemaDefault returns the default value as it is written in a config file.
+func schemaDefault(value reflect.Value) interface{} {
+	if marshaler, isMarshaler := value.Interface().(interface{ MarshalText() ([]byte, error) }); isMarshaler {
+		text, _ := marshaler.MarshalText()
+		return string(text)
+	}
+
+	return value.Interface()
+}
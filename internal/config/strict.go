@@ -0,0 +1,473 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+	// identifierRegex matches object keys that can be written with dot notation in a property path.
+	identifierRegex = regexp.MustCompile(`^[A-Za-z_$][\w$]*$`)
+)
+
+// locatedValue is a value (or property name) in a JSON document along with its property path (e.g. cache.GET[0]).
+type locatedValue struct {
+	path  string
+	start int64        // index of the first character
+	end   int64        // offset right after the first token, which is where encoding/json reports errors
+	t     reflect.Type // the type the value is decoded into, or nil if it is unknown
+}
+
+// filePosition is a (1-based) line and column in a config file.
+type filePosition struct {
+	// file is the path of the config file, if it is not the file being loaded but a file it extends or includes.
+	file   string
+	line   int
+	column int
+}
+
+// filePositions maps property paths to where their names and values are in the original config file,
+// so errors can point to the right place no matter which format the file is written in.
+type filePositions struct {
+	keys   map[string]filePosition
+	values map[string]filePosition
+}
+
+// configScanner walks the tokens of a JSON document to find the location of every property and value
+// and every property that is not part of the Config.
+type configScanner struct {
+	data    []byte
+	decoder *json.Decoder
+	keys    []locatedValue
+	values  []locatedValue
+	unknown []string // property paths
+}
+
+// scanConfig walks the tokens of the standardized config file data and returns the location of every value
+// and every property that cannot be decoded into the Config. data must be valid JSON.
+func scanConfig(data []byte) (*configScanner, error) {
+	scanner := &configScanner{
+		data:    data,
+		decoder: json.NewDecoder(bytes.NewReader(data)),
+	}
+	scanner.decoder.UseNumber()
+
+	if err := scanner.scanValue("", reflect.TypeOf(Config{})); err != nil {
+		return nil, err
+	}
+
+	return scanner, nil
+}
+
+// scanValue reads the next value from the decoder, where t is the type it would be decoded into.
+// t is nil if the value is decoded into an interface or by its own unmarshaler, in which case properties are not checked.
+func (scanner *configScanner) scanValue(path string, t reflect.Type) error {
+	start := scanner.nextTokenStart()
+
+	token, err := scanner.decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	scanner.values = append(scanner.values, locatedValue{path, start, scanner.decoder.InputOffset(), t})
+
+	t = decodedType(t)
+
+	switch token {
+	case json.Delim('{'):
+		for scanner.decoder.More() {
+			keyStart := scanner.nextTokenStart()
+
+			keyToken, err := scanner.decoder.Token()
+			if err != nil {
+				return err
+			}
+
+			key := keyToken.(string)
+			propPath := joinPropPath(path, key)
+			scanner.keys = append(scanner.keys, locatedValue{propPath, keyStart, scanner.decoder.InputOffset(), nil})
+
+			propType, known := propertyType(t, key)
+			if !known && !(path == "" && key == schemaProp) {
+				scanner.unknown = append(scanner.unknown, propPath)
+			}
+
+			if err := scanner.scanValue(propPath, propType); err != nil {
+				return err
+			}
+		}
+
+	case json.Delim('['):
+		var elemType reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elemType = t.Elem()
+		}
+
+		for i := 0; scanner.decoder.More(); i++ {
+			if err := scanner.scanValue(path+"["+strconv.Itoa(i)+"]", elemType); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return nil // scalar values have no more tokens
+	}
+
+	_, err = scanner.decoder.Token() // closing delimiter
+
+	return err
+}
+
+// nextTokenStart returns the index of the first character of the next token, skipping the whitespace
+// and separators that the decoder has not consumed yet.
+func (scanner *configScanner) nextTokenStart() int64 {
+	i := scanner.decoder.InputOffset()
+	for i < int64(len(scanner.data)) && strings.IndexByte(" \t\r\n:,", scanner.data[i]) != -1 {
+		i++
+	}
+
+	return i
+}
+
+// locate returns the property path of the value encoding/json was decoding when it reported an error at offset.
+func (scanner *configScanner) locate(offset int64) string {
+	// Values are scanned in order, so the last value whose first token ends before the offset is the one with the error
+	i := sort.Search(len(scanner.values), func(i int) bool {
+		return scanner.values[i].end > offset
+	})
+	if i == 0 {
+		return ""
+	}
+
+	return scanner.values[i-1].path
+}
+
+// locateUnmarshalError returns the property path of the first value that is rejected by its own unmarshaler (e.g. an invalid Duration)
+// along with the error, since encoding/json does not report where those errors happen. The path is empty if no value is rejected.
+func (scanner *configScanner) locateUnmarshalError() (string, error) {
+	for _, value := range scanner.values {
+		if value.t == nil || !hasUnmarshaler(value.t) {
+			continue
+		}
+
+		// The value is decoded on its own, which only reads until the end of the value
+		decoder := json.NewDecoder(bytes.NewReader(scanner.data[value.start:]))
+		if err := decoder.Decode(reflect.New(value.t).Interface()); err != nil {
+			return value.path, err
+		}
+	}
+
+	return "", nil
+}
+
+// positions returns where every scanned property and value is in original,
+// which must have the same offsets as the scanned JSON document (like JSONC before it is standardized).
+func (scanner *configScanner) positions(original []byte) filePositions {
+	positions := filePositions{
+		keys:   make(map[string]filePosition, len(scanner.keys)),
+		values: make(map[string]filePosition, len(scanner.values)),
+	}
+
+	for _, key := range scanner.keys {
+		line, column := lineAndColumn(original, key.start)
+		positions.keys[key.path] = filePosition{line: line, column: column}
+	}
+	for _, value := range scanner.values {
+		line, column := lineAndColumn(original, value.start)
+		positions.values[value.path] = filePosition{line: line, column: column}
+	}
+
+	return positions
+}
+
+// describe returns where the property name (key) or value at path is in the config file, e.g. " at line 2, column 3",
+// or an empty string if the position is unknown.
+func (positions filePositions) describe(path string, key bool) string {
+	lookup := positions.values
+	if key {
+		lookup = positions.keys
+	}
+
+	position, found := lookup[path]
+	if !found {
+		return ""
+	}
+
+	if position.file != "" {
+		return fmt.Sprintf(" at line %d, column %d of %q", position.line, position.column, position.file)
+	}
+
+	return fmt.Sprintf(" at line %d, column %d", position.line, position.column)
+}
+
+// merge copies all positions from other, which are positions in the config file at file, overwriting existing positions.
+// Positions that already belong to another file (one that file extends or includes) keep their file.
+func (positions filePositions) merge(other filePositions, file string) {
+	for path, position := range other.keys {
+		if position.file == "" {
+			position.file = file
+		}
+		positions.keys[path] = position
+	}
+
+	for path, position := range other.values {
+		if position.file == "" {
+			position.file = file
+		}
+		positions.values[path] = position
+	}
+}
+
+// hasUnmarshaler returns true if values of t (or what t points to) are decoded by their own unmarshaler.
+func hasUnmarshaler(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	ptr := reflect.PointerTo(t)
+	return ptr.Implements(jsonUnmarshalerType) || ptr.Implements(textUnmarshalerType)
+}
+
+// decodedType returns the type that properties and elements are looked up on when decoding into t,
+// or nil if the value is decoded by its own unmarshaler or into an interface.
+func decodedType(t reflect.Type) reflect.Type {
+	if t == nil {
+		return nil
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if hasUnmarshaler(t) || t.Kind() == reflect.Interface {
+		return nil
+	}
+
+	return t
+}
+
+// propertyType returns the type the property key of an object decoded into t is decoded into
+// and whether the property is known. All properties are known for maps and for values without a type.
+func propertyType(t reflect.Type, key string) (reflect.Type, bool) {
+	if t == nil {
+		return nil, true
+	}
+
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Elem(), true
+
+	case reflect.Struct:
+		// Like encoding/json, prefer an exact match of the property name, but also accept other casings
+		var caseInsensitiveMatch reflect.Type
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := jsonPropName(field)
+			if name == "" {
+				continue
+			}
+
+			if name == key {
+				return field.Type, true
+			}
+			if caseInsensitiveMatch == nil && strings.EqualFold(name, key) {
+				caseInsensitiveMatch = field.Type
+			}
+		}
+
+		return caseInsensitiveMatch, caseInsensitiveMatch != nil
+	}
+
+	return nil, false // an object where something else was expected, which encoding/json reports as a type error
+}
+
+// jsonPropName returns the name of the json property that is decoded into field, or an empty string if there is none.
+func jsonPropName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+
+	return name
+}
+
+// joinPropPath adds key to the property path, using dot notation for simple keys and bracket notation for other keys, e.g.:
+//
+//	bust.PUT["/posts/:id"]
+func joinPropPath(path, key string) string {
+	if !identifierRegex.MatchString(key) {
+		return path + "[" + strconv.Quote(key) + "]"
+	}
+
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// newUnknownPropsError returns an error listing all unknown properties in the config file at path
+// with their position and a suggestion for what was meant, when a known property name is close.
+func newUnknownPropsError(path string, unknown []string, positions filePositions) error {
+	var messages []string
+
+	for _, propPath := range unknown {
+		message := fmt.Sprintf("unknown property '%s'%s", propPath, positions.describe(propPath, true))
+
+		if suggestion := suggestPropName(propPath); suggestion != "" {
+			message += fmt.Sprintf(" (did you mean %q?)", suggestion)
+		}
+
+		messages = append(messages, message)
+	}
+
+	return fmt.Errorf("could not load config file %q: %w", path, errors.New(strings.Join(messages, "; ")))
+}
+
+// newTypeError returns an error explaining which property in the config file at path has a value of the wrong type.
+func newTypeError(path, propPath string, typeErr *json.UnmarshalTypeError, positions filePositions) error {
+	return fmt.Errorf("could not load config file %q: property '%s'%s must be %s, it is %s",
+		path, propPath, positions.describe(propPath, false), jsonTypeName(typeErr.Type), withArticle(typeErr.Value))
+}
+
+// newValueError returns an error explaining which property in the config file at path has a value that its unmarshaler rejected with err.
+func newValueError(path, propPath string, err error, positions filePositions) error {
+	return fmt.Errorf("could not load config file %q: property '%s'%s is invalid: %w", path, propPath, positions.describe(propPath, false), err)
+}
+
+// suggestPropName returns the known top level property name closest to the last part of propPath,
+// or an empty string if no property is close enough to be a likely typo.
+func suggestPropName(propPath string) string {
+	if strings.ContainsAny(propPath, ".[") {
+		return "" // only top level properties have fixed names
+	}
+
+	const maxDistance = 2
+
+	suggestion := ""
+	bestDistance := maxDistance + 1
+
+	configType := reflect.TypeOf(Config{})
+	for i := 0; i < configType.NumField(); i++ {
+		name := jsonPropName(configType.Field(i))
+		if name == "" {
+			continue
+		}
+
+		distance := editDistance(strings.ToLower(propPath), strings.ToLower(name))
+		if distance < bestDistance {
+			suggestion = name
+			bestDistance = distance
+		}
+	}
+
+	return suggestion
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+// minInt returns the smallest of the given numbers.
+func minInt(first int, rest ...int) int {
+	min := first
+	for _, n := range rest {
+		if n < min {
+			min = n
+		}
+	}
+
+	return min
+}
+
+// jsonTypeName describes the json value that is decoded into t, e.g. "a number" for uint.
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == reflect.TypeOf(Duration(0)) {
+		return `a duration string (e.g. "30s")`
+	}
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return "a string"
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "a whole number"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a positive whole number"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+
+	return t.String()
+}
+
+// withArticle turns the json value description from a json.UnmarshalTypeError into a phrase like "an object".
+func withArticle(value string) string {
+	if strings.HasPrefix(value, "number ") {
+		return strings.TrimPrefix(value, "number ") // the actual value, e.g. -1 for a uint
+	}
+
+	switch value {
+	case "":
+		return "something else"
+	case "bool":
+		return "a boolean"
+	case "array", "object":
+		return "an " + value
+	}
+
+	return "a " + value
+}
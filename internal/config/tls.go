@@ -0,0 +1,174 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// DefaultMinTLSVersion is the lowest TLS version the cache server accepts, if no other version is given.
+const DefaultMinTLSVersion = "1.2"
+
+// tlsVersions maps the valid values of MinVersion to the versions of crypto/tls.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ServerTLS describes how the cache server serves HTTPS. TLS is disabled unless both CertFile and KeyFile are set.
+type ServerTLS struct {
+	// CertFile is the path to a PEM encoded certificate (chain) for the cache server.
+	CertFile string `json:"certFile" validate:"required_with=KeyFile ClientCAFile,omitempty,file"`
+
+	// KeyFile is the path to the PEM encoded private key of the CertFile.
+	KeyFile string `json:"keyFile" validate:"required_with=CertFile,omitempty,file"`
+
+	// Default is "1.2", it is the lowest TLS version that clients can use, which is either "1.0", "1.1", "1.2", or "1.3".
+	MinVersion string `json:"minVersion" validate:"omitempty,oneof=1.0 1.1 1.2 1.3"`
+
+	// CipherSuites are the names of the cipher suites that clients can use with TLS 1.2 and below, e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256".
+	// Omit them to use the secure defaults of Go. The cipher suites of TLS 1.3 cannot be configured.
+	CipherSuites []string `json:"cipherSuites" validate:"omitempty,dive,ciphersuite"`
+
+	// ClientCAFile is the path to PEM encoded certificates of the CAs that client certificates must be signed by.
+	// Omit it to not ask clients for certificates.
+	ClientCAFile string `json:"clientCaFile" validate:"omitempty,file"`
+
+	/*
+		Default is "require", it represents how client certificates are verified when a ClientCAFile is set, which is either:
+			"require": every client must present a certificate signed by one of the CAs
+			"verify-if-given": clients can connect without a certificate, but a certificate they present must be signed by one of the CAs
+	*/
+	ClientAuth string `json:"clientAuth" validate:"omitempty,oneof=require verify-if-given"`
+}
+
+// Enabled returns true if the cache server should serve HTTPS.
+func (settings ServerTLS) Enabled() bool {
+	return settings.CertFile != "" && settings.KeyFile != ""
+}
+
+// Files returns the paths of the files the TLS settings are loaded from, so they can be watched for changes.
+func (settings ServerTLS) Files() []string {
+	files := []string{settings.CertFile, settings.KeyFile}
+	if settings.ClientCAFile != "" {
+		files = append(files, settings.ClientCAFile)
+	}
+
+	return files
+}
+
+// TLSMinVersion returns the crypto/tls version of the MinVersion, or of the DefaultMinTLSVersion if it is omitted.
+func (settings ServerTLS) TLSMinVersion() uint16 {
+	if version, ok := tlsVersions[settings.MinVersion]; ok {
+		return version
+	}
+
+	return tlsVersions[DefaultMinTLSVersion]
+}
+
+// TLSClientAuth returns how client certificates are requested and verified.
+func (settings ServerTLS) TLSClientAuth() tls.ClientAuthType {
+	switch {
+	case settings.ClientCAFile == "":
+		return tls.NoClientCert
+	case settings.ClientAuth == "verify-if-given":
+		return tls.VerifyClientCertIfGiven
+	default:
+		return tls.RequireAndVerifyClientCert
+	}
+}
+
+// TLSCipherSuites returns the IDs of the CipherSuites, or nil to use the defaults of Go if there are none.
+// Names that are not valid are skipped, since they are caught by Validate.
+func (settings ServerTLS) TLSCipherSuites() []uint16 {
+	var ids []uint16
+	for _, name := range settings.CipherSuites {
+		if id, ok := cipherSuiteID(name); ok {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// String describes the TLS settings, e.g. "cert.pem, TLS 1.2+, client certificates required".
+func (settings ServerTLS) String() string {
+	if !settings.Enabled() {
+		return "disabled"
+	}
+
+	minVersion := settings.MinVersion
+	if minVersion == "" {
+		minVersion = DefaultMinTLSVersion
+	}
+
+	parts := []string{settings.CertFile, fmt.Sprintf("TLS %s+", minVersion)}
+	if len(settings.CipherSuites) > 0 {
+		parts = append(parts, fmt.Sprintf("%d cipher suites", len(settings.CipherSuites)))
+	}
+	switch settings.TLSClientAuth() {
+	case tls.RequireAndVerifyClientCert:
+		parts = append(parts, "client certificates required")
+	case tls.VerifyClientCertIfGiven:
+		parts = append(parts, "client certificates verified if given")
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// cipherSuiteID returns the ID of the secure cipher suite with the given name, and false if there is no such cipher suite.
+func cipherSuiteID(name string) (uint16, bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+
+	return 0, false
+}
+
+// UpstreamTLS describes how the cache server connects to the servers of an upstream over HTTPS.
+// The zero value verifies the certificates of the servers with the CAs of the system, like any other HTTPS client.
+type UpstreamTLS struct {
+	// CAFile is the path to PEM encoded certificates of the CAs that the certificates of the servers must be signed by,
+	// instead of the CAs of the system.
+	CAFile string `json:"caFile" validate:"omitempty,file"`
+
+	// CertFile is the path to a PEM encoded client certificate that the cache server presents to the servers (mutual TLS).
+	CertFile string `json:"certFile" validate:"required_with=KeyFile,omitempty,file"`
+
+	// KeyFile is the path to the PEM encoded private key of the CertFile.
+	KeyFile string `json:"keyFile" validate:"required_with=CertFile,omitempty,file"`
+
+	// ServerName is sent to the servers with SNI and must be in their certificates, instead of the hostname of their url.
+	ServerName string `json:"serverName" validate:"omitempty,hostname_rfc1123"`
+
+	// InsecureSkipVerify accepts any certificate from the servers. It should only be used for development.
+	InsecureSkipVerify bool `json:"insecureSkipVerify"`
+}
+
+// IsZero returns true if none of the settings are set, so the servers are connected to like with any other HTTPS client.
+func (settings UpstreamTLS) IsZero() bool {
+	return settings == UpstreamTLS{}
+}
+
+// String describes the settings, e.g. "client certificate, CA ca.pem, server name api.internal".
+func (settings UpstreamTLS) String() string {
+	var parts []string
+	if settings.CertFile != "" {
+		parts = append(parts, "client certificate")
+	}
+	if settings.CAFile != "" {
+		parts = append(parts, "CA "+settings.CAFile)
+	}
+	if settings.ServerName != "" {
+		parts = append(parts, "server name "+settings.ServerName)
+	}
+	if settings.InsecureSkipVerify {
+		parts = append(parts, "certificates not verified")
+	}
+
+	return strings.Join(parts, ", ")
+}
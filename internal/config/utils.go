@@ -1,5 +1,7 @@
 package config
 
+import "strings"
+
 // contains checks whether a slice contains a target value.
 // Is used to remove invalid method keys in Cache and Bust maps on the config.
 func contains[T comparable](slice []T, target T) bool {
@@ -10,3 +12,17 @@ func contains[T comparable](slice []T, target T) bool {
 	}
 	return false
 }
+
+// trimTrailingSlashes returns the urls without trailing slashes, or nil if there are no urls.
+func trimTrailingSlashes(urls []string) []string {
+	if len(urls) == 0 {
+		return nil
+	}
+
+	trimmed := make([]string, len(urls))
+	for i, url := range urls {
+		trimmed[i] = strings.TrimSuffix(url, "/")
+	}
+
+	return trimmed
+}
@@ -4,12 +4,23 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/magnus-bb/cache-me-ousside/cache"
+	"github.com/magnus-bb/cache-me-ousside/internal/graphql"
+)
+
+var (
+	// RouteRegex matches valid route identifiers for caching and busting routes, e.g. /posts/:id or *.
+	RouteRegex = regexp.MustCompile(`^/[\w\-\._~:/?#[\]@!\$&'\(\)\*\+,;=.]+$|^\*$`)
+
+	// UpstreamNameRegex matches valid upstream names, which cannot contain the separator between the name and the rest of a cache key.
+	UpstreamNameRegex = regexp.MustCompile(`^[\w\-]+$`)
 )
 
 // newConfigValidator returns a new instance of config validator
@@ -34,9 +45,38 @@ func newConfigValidator() *validator.Validate {
 	validate.RegisterValidation("route", func(fl validator.FieldLevel) bool {
 		route := fl.Field().String()
 
-		match, err := regexp.MatchString(`^/[\w\-\._~:/?#[\]@!\$&'\(\)\*\+,;=.]+$|^\*$`, route)
+		return RouteRegex.MatchString(route)
+	})
+
+	// Checks if a string is the name of a secure cipher suite
+	validate.RegisterValidation("ciphersuite", func(fl validator.FieldLevel) bool {
+		_, ok := cipherSuiteID(fl.Field().String())
+
+		return ok
+	})
+
+	// Checks if a string is a valid GraphQL operation or type name
+	validate.RegisterValidation("graphqlname", func(fl validator.FieldLevel) bool {
+		return graphql.IsName(fl.Field().String())
+	})
+
+	// Checks if a string is a valid pattern for the names of query params
+	validate.RegisterValidation("paramglob", func(fl validator.FieldLevel) bool {
+		_, err := path.Match(fl.Field().String(), "")
+
+		return err == nil
+	})
 
-		return match && err == nil
+	// Checks if a string is a valid cache key template
+	validate.RegisterValidation("keytemplate", func(fl validator.FieldLevel) bool {
+		return checkKeyTemplate(fl.Field().String()) == nil
+	})
+
+	// Checks if a string is a valid upstream name
+	validate.RegisterValidation("upstreamname", func(fl validator.FieldLevel) bool {
+		name := fl.Field().String()
+
+		return UpstreamNameRegex.MatchString(name)
 	})
 
 	return validate
@@ -48,10 +88,13 @@ func formatValidationError(validationErrors validator.ValidationErrors) error {
 	var errorMessages []string
 
 	for _, err := range validationErrors {
-		// err.Field() usually returns something like "ApiUrl", but with maps it can return "Cache[GET][0]" etc
-		// that means, that if we take only the first part of the string until a (potential) "["
-		// we can always get the prop name on the Config struct
-		propName, _, _ := strings.Cut(err.Field(), "[")
+		// err.StructNamespace() usually returns something like "Config.ApiUrl", but with maps it can return "Config.Cache[GET][0]"
+		// or "Config.Upstreams[users].Url", and with structs "Config.HealthCheck.Path" etc. that means, that if we take only the first part
+		// of the string after "Config." until a (potential) "[" or "." we can always get the prop name on the Config struct
+		propName := strings.TrimPrefix(err.StructNamespace(), "Config.")
+		if end := strings.IndexAny(propName, "[."); end != -1 {
+			propName = propName[:end]
+		}
 
 		errorMessages = append(errorMessages, validationErrorMap[propName](err))
 	}
@@ -66,6 +109,52 @@ func requiredErrorMsg(prop string) string {
 	return fmt.Sprintf("configuration is missing the '%s' property", prop)
 }
 
+// upstreamErrorMsg returns a string formatted to explain an invalid prop of an upstream, e.g. "HealthCheck.Path" or "Upstreams[users].Balance".
+func upstreamErrorMsg(err validator.FieldError) string {
+	field := strings.TrimPrefix(err.Namespace(), "Config.") // err.Field() is only the last part, e.g. Path
+
+	switch err.Tag() {
+	case "url":
+		return fmt.Sprintf("'%s' value is not a valid URL, it is %q", field, err.Value())
+	case "oneof":
+		values := strings.Fields(err.Param())
+		for i, value := range values {
+			values[i] = strconv.Quote(value)
+		}
+
+		alternatives := strings.Join(values[:len(values)-1], ", ") + ", or " + values[len(values)-1]
+		if len(values) == 2 {
+			alternatives = values[0] + " or " + values[1]
+		}
+
+		return fmt.Sprintf("'%s' must be omitted or set to either %s, it is %q", field, alternatives, err.Value())
+	case "startswith":
+		return fmt.Sprintf("'%s' must be omitted or set to a path that starts with '/', it is %q", field, err.Value())
+	case "min":
+		return fmt.Sprintf("'%s' must be omitted or set to a positive duration, it is %q", field, err.Value())
+	}
+
+	return "" // should never happen
+}
+
+// tlsErrorMsg returns a string formatted to explain an invalid prop of TLS settings, e.g. "TLS.CertFile" or "Upstreams[users].TLS.CAFile".
+func tlsErrorMsg(err validator.FieldError) string {
+	field := strings.TrimPrefix(err.Namespace(), "Config.") // err.Field() is only the last part, e.g. CertFile
+
+	switch err.Tag() {
+	case "required_with":
+		return fmt.Sprintf("'%s' must be set when %s is set", field, strings.Join(strings.Fields(err.Param()), " or "))
+	case "file":
+		return fmt.Sprintf("'%s' must be omitted or set to the path of an existing file, it is %q", field, err.Value())
+	case "hostname_rfc1123":
+		return fmt.Sprintf("'%s' must be omitted or set to a valid rfc1123 hostname, it is %q", field, err.Value())
+	case "ciphersuite":
+		return fmt.Sprintf("'%s' must be the name of a secure cipher suite, e.g. \"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256\", it is %q", field, err.Value())
+	}
+
+	return upstreamErrorMsg(err)
+}
+
 // validationErrorMap maps field names to functions that return a validation error message
 // depending on the validation details given with the validator.FieldError err.
 var validationErrorMap = map[string]func(err validator.FieldError) string{
@@ -97,6 +186,8 @@ var validationErrorMap = map[string]func(err validator.FieldError) string{
 		return fmt.Sprintf("'%s' must be omitted or set to a number between 1 and 65535, it is %d", err.Field(), err.Value())
 	},
 
+	"TLS": tlsErrorMsg,
+
 	"ApiUrl": func(err validator.FieldError) string {
 		tag := err.Tag()
 
@@ -110,6 +201,40 @@ var validationErrorMap = map[string]func(err validator.FieldError) string{
 		return "" // should never happen
 	},
 
+	"ApiReplicas": upstreamErrorMsg,
+
+	"Balance": upstreamErrorMsg,
+
+	"HealthCheck": upstreamErrorMsg,
+
+	"CircuitBreaker": upstreamErrorMsg,
+
+	"Proxy": upstreamErrorMsg,
+
+	"ApiTLS": tlsErrorMsg,
+
+	"StatsPath": upstreamErrorMsg,
+
+	"LogMaxAge": func(err validator.FieldError) string {
+		return fmt.Sprintf("'%s' must be omitted or set to a positive duration, it is %q", err.Field(), err.Value())
+	},
+
+	"AccessLogPath": func(err validator.FieldError) string {
+		return fmt.Sprintf("'%s' must be omitted, set to \"stdout\" or \"stderr\", or set to a file path in an existing directory, it is %q", err.Field(), err.Value())
+	},
+
+	"AccessLogFormat": func(err validator.FieldError) string {
+		return fmt.Sprintf("'%s' must be omitted or set to either \"common\", \"combined\", or \"json\", it is %q", err.Field(), err.Value())
+	},
+
+	"ShutdownTimeout": func(err validator.FieldError) string {
+		return fmt.Sprintf("'%s' must be omitted or set to a positive duration, it is %q", err.Field(), err.Value())
+	},
+
+	"CacheTTL": func(err validator.FieldError) string {
+		return fmt.Sprintf("'%s' must be omitted or set to a positive duration, it is %q", err.Field(), err.Value())
+	},
+
 	"Cache": func(err validator.FieldError) string {
 		tag := err.Tag()
 
@@ -141,4 +266,49 @@ var validationErrorMap = map[string]func(err validator.FieldError) string{
 
 		return "" // should never happen
 	},
+
+	"Idempotency": upstreamErrorMsg,
+
+	"KeyPolicies": func(err validator.FieldError) string {
+		field := strings.TrimPrefix(err.Namespace(), "Config.") // e.g. KeyPolicies[/posts].IgnoreParams[0]
+
+		switch err.Tag() {
+		case "route":
+			return fmt.Sprintf("the key of '%s' must be a valid route identifier, it is %q", field, err.Value())
+		case "paramglob":
+			return fmt.Sprintf("'%s' must be a query param name where '*' matches any characters, it is %q", field, err.Value())
+		case "keytemplate":
+			return fmt.Sprintf("'%s' %s, it is %q", field, checkKeyTemplate(fmt.Sprint(err.Value())), err.Value())
+		}
+
+		return "" // should never happen
+	},
+
+	"GraphQL": func(err validator.FieldError) string {
+		field := strings.TrimPrefix(err.Namespace(), "Config.") // e.g. GraphQL.Bust[AddComment][0]
+
+		switch err.Tag() {
+		case "route":
+			return fmt.Sprintf("'%s' must be a valid route identifier, it is %q", field, err.Value())
+		case "graphqlname":
+			return fmt.Sprintf("'%s' must be a GraphQL name with only letters, digits, and '_', which does not start with a digit, it is %q", field, err.Value())
+		}
+
+		return "" // should never happen
+	},
+
+	"Upstreams": func(err validator.FieldError) string {
+		field := strings.TrimPrefix(err.Namespace(), "Config.") // e.g. Upstreams[users].Url, since err.Field() is only Url
+
+		switch err.Tag() {
+		case "upstreamname":
+			return fmt.Sprintf("the key of '%s' must be a name with only letters, digits, '-', and '_', it is %q", field, err.Value())
+		case "required", "gt":
+			return fmt.Sprintf("'%s' must be set", field)
+		case "route":
+			return fmt.Sprintf("'%s' must be a valid route identifier, it is %q", field, err.Value())
+		}
+
+		return tlsErrorMsg(err) // the props that upstreams share with the default upstream, e.g. Upstreams[users].TLS.CAFile
+	},
 }
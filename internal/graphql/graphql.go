@@ -0,0 +1,225 @@
+// Package graphql reads the operations of GraphQL requests, so queries can be cached by what they ask for
+// instead of by their URL, which is the same for every request to a GraphQL endpoint.
+// It only reads as much of the GraphQL language as it needs to tell operations apart and does not validate documents.
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/magnus-bb/cache-me-ousside/internal/canonical"
+)
+
+// The types of GraphQL operations.
+const (
+	Query        = "query"
+	Mutation     = "mutation"
+	Subscription = "subscription"
+)
+
+// Operation is the operation that a GraphQL request runs.
+type Operation struct {
+	// Type is either Query, Mutation, or Subscription.
+	Type string
+
+	// Name is the operation name, which is taken from the query document if the request has none. It is empty for anonymous operations.
+	Name string
+
+	// Document is the normalized query text of the request, see Normalize.
+	Document string
+
+	// Variables are the variables of the request as JSON with sorted keys, or "{}" if there are none.
+	Variables string
+}
+
+// request is the JSON body of a GraphQL request over HTTP.
+type request struct {
+	Query         string          `json:"query"`
+	OperationName string          `json:"operationName"`
+	Variables     json.RawMessage `json:"variables"`
+}
+
+// Parse returns the Operation of the JSON body of a GraphQL POST request.
+// An error is returned if the body is not a single GraphQL request (e.g. a batch),
+// or if it is not clear which operation of the query document is run.
+func Parse(body []byte) (Operation, error) {
+	var req request
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if err := decoder.Decode(&req); err != nil {
+		return Operation{}, fmt.Errorf("the body is not a GraphQL request: %w", err)
+	}
+	if req.Query == "" {
+		return Operation{}, errors.New("the body is not a GraphQL request: it has no query")
+	}
+
+	tokens, err := tokenize(req.Query)
+	if err != nil {
+		return Operation{}, err
+	}
+
+	opType, opName, err := selectOperation(tokens, req.OperationName)
+	if err != nil {
+		return Operation{}, err
+	}
+
+	variables, err := canonicalVariables(req.Variables)
+	if err != nil {
+		return Operation{}, fmt.Errorf("the variables of the GraphQL request are not valid: %w", err)
+	}
+
+	return Operation{
+		Type:      opType,
+		Name:      opName,
+		Document:  strings.Join(tokens, " "),
+		Variables: variables,
+	}, nil
+}
+
+// Normalize returns the query text with comments, commas, and the whitespace between tokens removed,
+// and every token separated by a single space, so formatting does not change the cache key of a query.
+func Normalize(query string) (string, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(tokens, " "), nil
+}
+
+// Hash returns a hash of the normalized query text, the operation name, and the variables.
+func (op Operation) Hash() string {
+	return canonical.Hash([]byte(op.Document + "\x00" + op.Name + "\x00" + op.Variables))
+}
+
+/*
+Key returns the cache key of a query to the GraphQL endpoint at url, e.g.:
+	POST:/graphql#query=GetPost;types=Comment,Post;hash=4f0c...
+The operation name and types are part of the key, so mutations can bust the entries of queries by either with BustPatterns.
+*/
+func (op Operation) Key(url string, types []string) string {
+	sortedTypes := append([]string(nil), types...)
+	sort.Strings(sortedTypes)
+
+	return fmt.Sprintf("POST:%s#query=%s;types=%s;hash=%s", url, op.Name, strings.Join(sortedTypes, ","), op.Hash())
+}
+
+// BustPatterns returns regex patterns that match the keys of the queries to the GraphQL endpoint at url,
+// which have an operation name or a type in names.
+func BustPatterns(url string, names []string) []string {
+	prefix := "^POST:" + regexp.QuoteMeta(url) + "#"
+
+	patterns := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		quoted := regexp.QuoteMeta(name)
+		patterns = append(patterns,
+			prefix+"query="+quoted+";",
+			prefix+"query=[^;]*;types=([^;]*,)?"+quoted+"[,;]",
+		)
+	}
+
+	return patterns
+}
+
+// HasErrors returns true if the JSON body of a GraphQL response has errors, which should not be cached,
+// even though GraphQL servers usually respond to them with a 200 status.
+func HasErrors(body []byte) bool {
+	var response struct {
+		Errors json.RawMessage `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return true // not a GraphQL response
+	}
+
+	errs := strings.TrimSpace(string(response.Errors))
+	return errs != "" && errs != "null" && errs != "[]"
+}
+
+// selectOperation returns the type and name of the operation with the given name in the tokens of a query document,
+// or of the only operation in the document if name is empty.
+func selectOperation(tokens []string, name string) (string, string, error) {
+	type definition struct{ opType, name string }
+	var operations []definition
+
+	depth := 0
+	startOfDefinition := true
+	for i, token := range tokens {
+		switch token {
+		case "{", "(", "[":
+			if depth == 0 && startOfDefinition && token == "{" {
+				operations = append(operations, definition{Query, ""}) // the query shorthand, e.g. "{ posts { id } }"
+			}
+			depth++
+			startOfDefinition = false
+			continue
+		case "}", ")", "]":
+			depth--
+			// A definition ends when its selection set closes, and variable definitions and directive arguments are closed by ")"
+			startOfDefinition = depth == 0 && token == "}"
+			continue
+		}
+
+		if depth != 0 || !startOfDefinition {
+			continue
+		}
+		startOfDefinition = false
+
+		switch token {
+		case Query, Mutation, Subscription:
+			opName := ""
+			if i+1 < len(tokens) && IsName(tokens[i+1]) {
+				opName = tokens[i+1]
+			}
+			operations = append(operations, definition{token, opName})
+		}
+	}
+
+	if depth != 0 {
+		return "", "", errors.New("the GraphQL query has unbalanced brackets")
+	}
+
+	switch {
+	case len(operations) == 0:
+		return "", "", errors.New("the GraphQL query has no operations")
+	case name == "" && len(operations) > 1:
+		return "", "", errors.New("the GraphQL query has several operations, but no operation name")
+	case name == "":
+		return operations[0].opType, operations[0].name, nil
+	}
+
+	for _, operation := range operations {
+		if operation.name == name {
+			return operation.opType, operation.name, nil
+		}
+	}
+
+	return "", "", fmt.Errorf("the GraphQL query has no operation named %q", name)
+}
+
+// canonicalVariables returns the variables as JSON with sorted keys, so variables that are formatted or ordered differently are the same.
+// Missing, null, and empty variables are all "{}".
+func canonicalVariables(raw json.RawMessage) (string, error) {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || string(trimmed) == "null" {
+		return "{}", nil
+	}
+
+	variables, err := canonical.JSON(trimmed)
+	if err != nil {
+		return "", err
+	}
+
+	return string(variables), nil
+}
+
+// NameRegex matches GraphQL names, e.g. operation and type names.
+var NameRegex = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+// IsName returns true if s is a valid GraphQL name, e.g. "GetPost".
+func IsName(s string) bool {
+	return NameRegex.MatchString(s)
+}
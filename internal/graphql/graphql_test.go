@@ -0,0 +1,105 @@
+package graphql
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		body    string
+		opType  string
+		opName  string
+		wantErr bool
+	}{
+		{`{"query": "{ posts { id } }"}`, Query, "", false},
+		{`{"query": "query GetPost($id: ID!) { post(id: $id) { id } }"}`, Query, "GetPost", false},
+		{`{"query": "mutation AddComment { addComment { id } }"}`, Mutation, "AddComment", false},
+		{`{"query": "subscription { comments { id } }"}`, Subscription, "", false},
+		{`{"query": "query A { a } mutation B { b }", "operationName": "B"}`, Mutation, "B", false},
+		{`{"query": "query A @cached(ttl: 10) { a } fragment F on Post { id } mutation B { b }", "operationName": "A"}`, Query, "A", false},
+		{`{"query": "query A { a } mutation B { b }"}`, "", "", true},
+		{`{"query": "query A { a }", "operationName": "B"}`, "", "", true},
+		{`{"query": "query A { a"}`, "", "", true},
+		{`{"query": "query A { a(s: \"unterminated) }"}`, "", "", true},
+		{`[{"query": "{ a }"}]`, "", "", true},
+		{`{"variables": {}}`, "", "", true},
+	}
+
+	for _, tt := range tests {
+		operation, err := Parse([]byte(tt.body))
+		if tt.wantErr {
+			assert.Error(t, err, "Expected an error when parsing %s", tt.body)
+			continue
+		}
+
+		if assert.NoError(t, err, "Expected no error when parsing %s", tt.body) {
+			assert.Equal(t, tt.opType, operation.Type, "Expected the operation type of %s", tt.body)
+			assert.Equal(t, tt.opName, operation.Name, "Expected the operation name of %s", tt.body)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	assert := assert.New(t)
+
+	hash := func(body string) string {
+		operation, err := Parse([]byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return operation.Hash()
+	}
+
+	original := hash(`{"query": "query GetPost($id: ID!) { post(id: $id) { id, title } }", "variables": {"id": 1, "draft": false}}`)
+
+	assert.Equal(original, hash(`{"query": "# a comment\nquery GetPost($id: ID!) {\n  post(id: $id) {\n    id\n    title\n  }\n}", "variables": {"draft": false, "id": 1}}`),
+		"Expected formatting, comments, and the order of variables not to change the hash")
+	assert.NotEqual(original, hash(`{"query": "query GetPost($id: ID!) { post(id: $id) { id, title } }", "variables": {"id": 2, "draft": false}}`),
+		"Expected other variables to change the hash")
+	assert.NotEqual(original, hash(`{"query": "query GetPost($id: ID!) { post(id: $id) { id } }", "variables": {"id": 1, "draft": false}}`),
+		"Expected other fields to change the hash")
+
+	assert.Equal(hash(`{"query": "{ a }"}`), hash(`{"query": "{ a }", "variables": null}`), "Expected missing and null variables to be the same")
+	assert.NotEqual(hash(`{"query": "{ a(s: \"x  y\") }"}`), hash(`{"query": "{ a(s: \"x y\") }"}`), "Expected whitespace in strings to be kept")
+}
+
+func TestBustPatterns(t *testing.T) {
+	operation := Operation{Type: Query, Name: "GetPost"}
+	key := operation.Key("/graphql", []string{"Post", "Comment"})
+
+	tests := []struct {
+		names   []string
+		matches bool
+	}{
+		{[]string{"GetPost"}, true},
+		{[]string{"Post"}, true},
+		{[]string{"Comment"}, true},
+		{[]string{"User", "Comment"}, true},
+		{[]string{"Get"}, false},
+		{[]string{"Pos"}, false},
+		{[]string{"User"}, false},
+	}
+
+	for _, tt := range tests {
+		matches := false
+		for _, pattern := range BustPatterns("/graphql", tt.names) {
+			matches = matches || regexp.MustCompile(pattern).MatchString(key)
+		}
+
+		assert.Equal(t, tt.matches, matches, "Expected the bust patterns for %v to match %s: %t", tt.names, key, tt.matches)
+	}
+
+	for _, pattern := range BustPatterns("/other/graphql", []string{"GetPost"}) {
+		assert.NotRegexp(t, pattern, key, "Expected the bust patterns of another endpoint not to match")
+	}
+}
+
+func TestHasErrors(t *testing.T) {
+	assert.False(t, HasErrors([]byte(`{"data": {"post": null}}`)))
+	assert.False(t, HasErrors([]byte(`{"data": {}, "errors": []}`)))
+	assert.True(t, HasErrors([]byte(`{"data": null, "errors": [{"message": "not found"}]}`)))
+	assert.True(t, HasErrors([]byte(`not json`)))
+}
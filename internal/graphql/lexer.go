@@ -0,0 +1,108 @@
+package graphql
+
+import (
+	"errors"
+	"strings"
+)
+
+// punctuators are the single character tokens of GraphQL. The spread "..." is read separately.
+const punctuators = "!$&()/:=@[]{|}"
+
+// tokenize splits a GraphQL document into its tokens, skipping whitespace, commas, and comments, which carry no meaning.
+// Strings are kept as they were written, including their quotes, so queries that only differ in string values are not the same.
+func tokenize(document string) ([]string, error) {
+	var tokens []string
+
+	for i := 0; i < len(document); {
+		c := document[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+
+		case strings.HasPrefix(document[i:], "\uFEFF"): // unicode BOM
+			i += len("\uFEFF")
+
+		case c == '#':
+			end := strings.IndexAny(document[i:], "\n\r")
+			if end == -1 {
+				return tokens, nil
+			}
+			i += end
+
+		case strings.HasPrefix(document[i:], "..."):
+			tokens = append(tokens, "...")
+			i += 3
+
+		case strings.IndexByte(punctuators, c) != -1:
+			tokens = append(tokens, string(c))
+			i++
+
+		case strings.HasPrefix(document[i:], `"""`):
+			end := blockStringEnd(document, i+3)
+			if end == -1 {
+				return nil, errors.New("the GraphQL query has an unterminated block string")
+			}
+			tokens = append(tokens, document[i:end])
+			i = end
+
+		case c == '"':
+			end := stringEnd(document, i+1)
+			if end == -1 {
+				return nil, errors.New("the GraphQL query has an unterminated string")
+			}
+			tokens = append(tokens, document[i:end])
+			i = end
+
+		default:
+			// Names and numbers run until the next character that cannot be part of them
+			end := i + 1
+			for end < len(document) && isNameOrNumberChar(document[end]) {
+				end++
+			}
+			if !isNameOrNumberChar(c) {
+				return nil, errors.New("the GraphQL query has an unexpected character " + string(c))
+			}
+			tokens = append(tokens, document[i:end])
+			i = end
+		}
+	}
+
+	return tokens, nil
+}
+
+// stringEnd returns the index after the closing quote of the string whose content starts at start, or -1 if it is not closed.
+func stringEnd(document string, start int) int {
+	for i := start; i < len(document); i++ {
+		switch document[i] {
+		case '\\':
+			i++ // skip the escaped character
+		case '"':
+			return i + 1
+		case '\n', '\r':
+			return -1
+		}
+	}
+
+	return -1
+}
+
+// blockStringEnd returns the index after the closing quotes of the block string whose content starts at start, or -1 if it is not closed.
+func blockStringEnd(document string, start int) int {
+	for i := start; i < len(document); i++ {
+		if strings.HasPrefix(document[i:], `\"""`) {
+			i += 3
+			continue
+		}
+		if strings.HasPrefix(document[i:], `"""`) {
+			return i + 3
+		}
+	}
+
+	return -1
+}
+
+// isNameOrNumberChar returns true if c can be part of a name, e.g. "post_2", or a number, e.g. "-1.5e10".
+func isNameOrNumberChar(c byte) bool {
+	return c == '_' || c == '-' || c == '+' || c == '.' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
@@ -0,0 +1,171 @@
+// Package idempotency keeps the responses of unsafe requests under the Idempotency-Key that clients send with them,
+// so a client that retries a request, e.g. after a timeout, gets the response of the first request instead of running it twice.
+package idempotency
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Header is the request header that clients send the key of a request in.
+const Header = "Idempotency-Key"
+
+var (
+	// ErrMismatch is returned by Begin when a key is reused for a request that differs from the one it was first used for.
+	ErrMismatch = errors.New("the Idempotency-Key has already been used for a different request")
+
+	// ErrFull is returned by Begin when the Store already holds the maximum number of keys.
+	ErrFull = errors.New("too many Idempotency-Keys are stored")
+
+	// ErrInFlight is returned by Begin when the earlier request with a key has not finished before the wait has passed.
+	ErrInFlight = errors.New("a request with the Idempotency-Key is still in flight")
+)
+
+// Response is a response that is replayed to retries of a request.
+type Response struct {
+	Status  int
+	Headers map[string]string
+	Body    []byte
+}
+
+// Store holds the responses of requests under their keys until their retention has passed.
+// A Store is safe for concurrent use and should be shared by all routes, so it is kept when the configuration is reloaded.
+type Store struct {
+	mu      sync.Mutex
+	records map[string]*record
+
+	// now returns the current time, which can be replaced in tests.
+	now func() time.Time
+}
+
+// record is the state of a key, which is in flight until its done channel is closed.
+type record struct {
+	fingerprint string
+	done        chan struct{}
+	response    *Response // nil while the first request is in flight
+	expires     time.Time
+}
+
+// Claim is held by the request that first uses a key, and it must be finished with either Finish or Release.
+type Claim struct {
+	store  *Store
+	key    string
+	record *record
+}
+
+// NewStore returns an empty Store.
+func NewStore() *Store {
+	return &Store{
+		records: make(map[string]*record),
+		now:     time.Now,
+	}
+}
+
+/*
+Begin looks up the key for a request with the fingerprint, which identifies what the request does, e.g. its method, URL, and body.
+	- If an earlier request with the key has finished, its Response is returned to be replayed.
+	- If an earlier request with the key is still in flight, Begin waits up to wait for it to finish first.
+	- If the key is unused, or the earlier request was released, a Claim is returned, and the request should be sent.
+ErrMismatch is returned if the key was used with another fingerprint, ErrFull if the key is unused, but the Store already holds maxKeys keys,
+and ErrInFlight if the earlier request is still in flight when the wait has passed.
+*/
+func (store *Store) Begin(key, fingerprint string, maxKeys int, wait time.Duration) (*Response, *Claim, error) {
+	var timeout <-chan time.Time // started the first time Begin waits, so waiting again after a release does not reset it
+
+	for {
+		store.mu.Lock()
+
+		rec, found := store.records[key]
+		if found && rec.response != nil && !store.now().Before(rec.expires) {
+			delete(store.records, key)
+			found = false
+		}
+
+		if !found {
+			if len(store.records) >= maxKeys {
+				store.removeExpired()
+			}
+			if len(store.records) >= maxKeys {
+				store.mu.Unlock()
+				return nil, nil, ErrFull
+			}
+
+			rec = &record{fingerprint: fingerprint, done: make(chan struct{})}
+			store.records[key] = rec
+			store.mu.Unlock()
+
+			return nil, &Claim{store: store, key: key, record: rec}, nil
+		}
+
+		if rec.fingerprint != fingerprint {
+			store.mu.Unlock()
+			return nil, nil, ErrMismatch
+		}
+
+		if rec.response != nil {
+			store.mu.Unlock()
+			return rec.response, nil, nil
+		}
+
+		// Wait for the first request, and then look again, since it may have been released
+		done := rec.done
+		store.mu.Unlock()
+
+		if timeout == nil {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case <-done:
+		case <-timeout:
+			return nil, nil, ErrInFlight
+		}
+	}
+}
+
+// Len returns the number of keys in the Store, including keys whose retention has passed, but which have not been removed yet.
+func (store *Store) Len() int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return len(store.records)
+}
+
+// removeExpired removes the records whose retention has passed. The caller must hold the lock.
+func (store *Store) removeExpired() {
+	now := store.now()
+	for key, rec := range store.records {
+		if rec.response != nil && !now.Before(rec.expires) {
+			delete(store.records, key)
+		}
+	}
+}
+
+// Finish stores the response of the request under its key for the retention, and lets waiting retries replay it.
+func (claim *Claim) Finish(response Response, retention time.Duration) {
+	store := claim.store
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	claim.record.response = &response
+	claim.record.expires = store.now().Add(retention)
+	close(claim.record.done)
+}
+
+// Release frees the key without storing a response, e.g. because the request failed, so the next retry with the key is sent again.
+// Retries that are waiting for the request are woken up, and one of them claims the key.
+func (claim *Claim) Release() {
+	store := claim.store
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.records[claim.key] == claim.record {
+		delete(store.records, claim.key)
+	}
+	close(claim.record.done)
+}
@@ -0,0 +1,138 @@
+package idempotency
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	response, claim, err := store.Begin("key", "POST:/orders", 10, time.Second)
+	if !assert.NoError(err) || !assert.NotNil(claim, "Expected the first request with a key to claim it") {
+		return
+	}
+	assert.Nil(response)
+	claim.Finish(Response{Status: 201, Body: []byte("created")}, time.Minute)
+
+	response, claim, err = store.Begin("key", "POST:/orders", 10, time.Second)
+	if assert.NoError(err) && assert.NotNil(response, "Expected a retry to get the stored response") {
+		assert.Nil(claim)
+		assert.Equal(201, response.Status)
+		assert.Equal("created", string(response.Body))
+	}
+
+	_, _, err = store.Begin("key", "POST:/orders/2", 10, time.Second)
+	assert.ErrorIs(err, ErrMismatch, "Expected a reused key with another request to be rejected")
+
+	now = now.Add(time.Minute)
+	response, claim, err = store.Begin("key", "POST:/orders/2", 10, time.Second)
+	assert.NoError(err, "Expected a key to be free after its retention has passed")
+	assert.Nil(response)
+	assert.NotNil(claim)
+}
+
+func TestConcurrentRetries(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewStore()
+	_, claim, _ := store.Begin("key", "POST:/orders", 10, time.Second)
+
+	var wg sync.WaitGroup
+	responses := make(chan *Response, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, _, _ := store.Begin("key", "POST:/orders", 10, time.Second)
+			responses <- response
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond) // let the retries start waiting
+	assert.Len(responses, 0, "Expected retries to wait while the first request is in flight")
+
+	claim.Finish(Response{Status: 201}, time.Minute)
+	wg.Wait()
+	close(responses)
+
+	for response := range responses {
+		if assert.NotNil(response, "Expected every waiting retry to get the stored response") {
+			assert.Equal(201, response.Status)
+		}
+	}
+}
+
+func TestRelease(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewStore()
+	_, claim, _ := store.Begin("key", "POST:/orders", 10, time.Second)
+
+	retry := make(chan *Claim)
+	go func() {
+		_, retryClaim, _ := store.Begin("key", "POST:/orders", 10, time.Second)
+		retry <- retryClaim
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	claim.Release()
+
+	select {
+	case retryClaim := <-retry:
+		assert.NotNil(retryClaim, "Expected a waiting retry to claim the key when the first request is released")
+	case <-time.After(time.Second):
+		t.Fatal("Expected a waiting retry to be woken up when the first request is released")
+	}
+}
+
+func TestMaxKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	_, first, _ := store.Begin("a", "POST:/orders", 2, time.Second)
+	store.Begin("b", "POST:/orders", 2, time.Second)
+
+	_, _, err := store.Begin("c", "POST:/orders", 2, time.Second)
+	assert.ErrorIs(err, ErrFull, "Expected new keys to be refused when the store is full")
+
+	first.Finish(Response{Status: 201}, time.Second)
+	now = now.Add(time.Second)
+
+	_, claim, err := store.Begin("c", "POST:/orders", 2, time.Second)
+	if assert.NoError(err, "Expected expired keys to be removed to make room for new keys") {
+		assert.NotNil(claim)
+	}
+	assert.Equal(2, store.Len())
+}
+
+func TestWait(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewStore()
+	_, claim, _ := store.Begin("key", "POST:/orders", 10, time.Second)
+
+	start := time.Now()
+	response, retryClaim, err := store.Begin("key", "POST:/orders", 10, 20*time.Millisecond)
+	assert.ErrorIs(err, ErrInFlight, "Expected a retry to give up when the first request is still in flight after the wait")
+	assert.Nil(response)
+	assert.Nil(retryClaim)
+	assert.GreaterOrEqual(time.Since(start), 20*time.Millisecond)
+
+	claim.Finish(Response{Status: 201}, time.Minute)
+
+	response, _, err = store.Begin("key", "POST:/orders", 10, 20*time.Millisecond)
+	if assert.NoError(err) && assert.NotNil(response, "Expected a retry after the first request has finished to get its response") {
+		assert.Equal(201, response.Status)
+	}
+}
@@ -0,0 +1,204 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats that can be used for the access log.
+const (
+	AccessFormatCommon   = "common"   // NCSA common log format followed by latency and cache details
+	AccessFormatCombined = "combined" // NCSA combined log format (common + referer and user agent) followed by latency and cache details
+	AccessFormatJSON     = "json"     // One JSON object per line
+)
+
+// Cache outcomes of a request, used in the access log.
+const (
+	CacheHit         = "HIT"         // served from the cache
+	CacheMiss        = "MISS"        // cacheable, but fetched from the API
+	CacheStale       = "STALE"       // served from an expired cache entry
+	CacheRevalidated = "REVALIDATED" // served from an expired cache entry after the API answered that it is not modified
+	CacheBypass      = "BYPASS"      // not a cached route, proxied directly to the API
+	CacheReplay      = "REPLAY"      // answered with the stored response of an earlier request with the same Idempotency-Key
+)
+
+// clfTimeFormat is the time format used by the common and combined log formats.
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+var (
+	accessMutex   sync.Mutex
+	accessLog     io.Writer // nil when the access log is disabled
+	accessLogFile *LogFile  // only populated when the access log is written to a file
+	accessFormat  string
+)
+
+// AccessEntry describes a single request handled by the cache server.
+type AccessEntry struct {
+	Time            time.Time     `json:"time"`
+	ClientIP        string        `json:"clientIp"`
+	Method          string        `json:"method"`
+	URL             string        `json:"url"`
+	Protocol        string        `json:"protocol"`
+	Status          int           `json:"status"`
+	Bytes           int           `json:"bytes"`
+	Latency         time.Duration `json:"-"`
+	UpstreamLatency time.Duration `json:"-"`
+	CacheOutcome    string        `json:"cache"`
+	BustedKeys      int           `json:"bustedKeys"`
+	Referer         string        `json:"referer,omitempty"`
+	UserAgent       string        `json:"userAgent,omitempty"`
+}
+
+// InitializeAccessLog configures the access log to be written to path in the given format.
+// Path can be "stdout" or "stderr" to write to those streams instead of a file, which is rotated according to rotation.
+// Returns a reference to the open access log file, if the access log is written to a file.
+func InitializeAccessLog(path, format string, rotation RotationConfig) (*LogFile, error) {
+	accessMutex.Lock()
+	defer accessMutex.Unlock()
+
+	accessFormat = format
+	accessLogFile = nil
+
+	switch path {
+	case "":
+		accessLog = nil
+	case "stdout":
+		accessLog = os.Stdout
+	case "stderr":
+		accessLog = os.Stderr
+	default:
+		file, err := OpenLogFile(path, rotation)
+		if err != nil {
+			return nil, fmt.Errorf("could not set access log file %q, got the following error: %v", path, err)
+		}
+
+		accessLog = file
+		accessLogFile = file
+	}
+
+	return accessLogFile, nil
+}
+
+// Access writes entry to the access log in the configured format.
+// It does nothing if the access log has not been initialized.
+func Access(entry AccessEntry) {
+	accessMutex.Lock()
+	defer accessMutex.Unlock()
+
+	if accessLog == nil {
+		return
+	}
+
+	var line string
+
+	switch accessFormat {
+	case AccessFormatJSON:
+		line = formatAccessJSON(entry)
+	case AccessFormatCombined:
+		line = formatAccessCombined(entry)
+	default:
+		line = formatAccessCommon(entry)
+	}
+
+	if _, err := io.WriteString(accessLog, line+"\n"); err != nil {
+		Error(fmt.Errorf("could not write to access log, got the following error: %v", err))
+	}
+}
+
+// reopenAccessLog reopens the access log file, if the access log is written to a file.
+func reopenAccessLog() error {
+	accessMutex.Lock()
+	defer accessMutex.Unlock()
+
+	if accessLogFile == nil {
+		return nil
+	}
+
+	return accessLogFile.Reopen()
+}
+
+// formatAccessCommon formats entry in the NCSA common log format followed by latency and cache details, e.g.:
+//
+//	127.0.0.1 - - [30/May/2022:14:03:59 +0200] "GET /posts HTTP/1.1" 200 1234 latency=1.204ms upstream=1.113ms cache=MISS busted=0
+func formatAccessCommon(entry AccessEntry) string {
+	return fmt.Sprintf("%s - - [%s] %q %d %s %s",
+		dashIfEmpty(entry.ClientIP),
+		entry.Time.Format(clfTimeFormat),
+		entry.Method+" "+entry.URL+" "+entry.Protocol,
+		entry.Status,
+		bytesString(entry.Bytes),
+		accessDetails(entry),
+	)
+}
+
+// formatAccessCombined formats entry in the NCSA combined log format followed by latency and cache details, e.g.:
+//
+//	127.0.0.1 - - [30/May/2022:14:03:59 +0200] "GET /posts HTTP/1.1" 200 1234 "-" "curl/7.79.1" latency=1.204ms upstream=1.113ms cache=MISS busted=0
+func formatAccessCombined(entry AccessEntry) string {
+	return fmt.Sprintf("%s - - [%s] %q %d %s %q %q %s",
+		dashIfEmpty(entry.ClientIP),
+		entry.Time.Format(clfTimeFormat),
+		entry.Method+" "+entry.URL+" "+entry.Protocol,
+		entry.Status,
+		bytesString(entry.Bytes),
+		dashIfEmpty(entry.Referer),
+		dashIfEmpty(entry.UserAgent),
+		accessDetails(entry),
+	)
+}
+
+// formatAccessJSON formats entry as a single line JSON object with latencies in milliseconds.
+func formatAccessJSON(entry AccessEntry) string {
+	jsonEntry := struct {
+		AccessEntry
+		LatencyMs         float64 `json:"latencyMs"`
+		UpstreamLatencyMs float64 `json:"upstreamLatencyMs"`
+	}{
+		AccessEntry:       entry,
+		LatencyMs:         milliseconds(entry.Latency),
+		UpstreamLatencyMs: milliseconds(entry.UpstreamLatency),
+	}
+
+	line, _ := json.Marshal(jsonEntry) // cannot fail, since all fields are plain values
+
+	return string(line)
+}
+
+// accessDetails returns the latency and cache details that are appended to the common and combined log formats.
+func accessDetails(entry AccessEntry) string {
+	return strings.Join([]string{
+		"latency=" + entry.Latency.String(),
+		"upstream=" + entry.UpstreamLatency.String(),
+		"cache=" + entry.CacheOutcome,
+		"busted=" + strconv.Itoa(entry.BustedKeys),
+	}, " ")
+}
+
+// bytesString returns the number of bytes as a string, or "-" if no bytes were sent (like the common log format).
+func bytesString(bytes int) string {
+	if bytes == 0 {
+		return "-"
+	}
+
+	return strconv.Itoa(bytes)
+}
+
+// dashIfEmpty returns "-" for empty values, which is how the common log format marks missing values.
+func dashIfEmpty(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
+
+// milliseconds returns d as fractional milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,46 @@
+package logger
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testEntry = AccessEntry{
+	Time:            time.Date(2022, time.May, 30, 14, 3, 59, 0, time.UTC),
+	ClientIP:        "127.0.0.1",
+	Method:          "GET",
+	URL:             "/posts?page=2",
+	Protocol:        "HTTP/1.1",
+	Status:          200,
+	Bytes:           1234,
+	Latency:         1500 * time.Microsecond,
+	UpstreamLatency: time.Millisecond,
+	CacheOutcome:    CacheMiss,
+	UserAgent:       "curl/7.79.1",
+}
+
+func TestAccessFormats(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(
+		`127.0.0.1 - - [30/May/2022:14:03:59 +0000] "GET /posts?page=2 HTTP/1.1" 200 1234 latency=1.5ms upstream=1ms cache=MISS busted=0`,
+		formatAccessCommon(testEntry),
+		"Expected the common format to follow the NCSA common log format with latency and cache details appended",
+	)
+
+	assert.Equal(
+		`127.0.0.1 - - [30/May/2022:14:03:59 +0000] "GET /posts?page=2 HTTP/1.1" 200 1234 "-" "curl/7.79.1" latency=1.5ms upstream=1ms cache=MISS busted=0`,
+		formatAccessCombined(testEntry),
+		"Expected the combined format to follow the NCSA combined log format with latency and cache details appended",
+	)
+
+	var jsonEntry map[string]interface{}
+	assert.NoError(json.Unmarshal([]byte(formatAccessJSON(testEntry)), &jsonEntry), "Expected the json format to be valid JSON")
+	assert.Equal(1.5, jsonEntry["latencyMs"], "Expected the json format to have the latency in milliseconds")
+	assert.Equal(1.0, jsonEntry["upstreamLatencyMs"], "Expected the json format to have the upstream latency in milliseconds")
+	assert.Equal("MISS", jsonEntry["cache"], "Expected the json format to have the cache outcome")
+	assert.NotContains(jsonEntry, "referer", "Expected the json format to omit empty values")
+}
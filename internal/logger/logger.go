@@ -24,16 +24,20 @@ var (
 	errorLog   = new(log.Logger)
 
 	terminalMode bool
+
+	// logFile is the currently used log file, it is nil in terminal mode.
+	logFile *LogFile
 )
 
 // Initialize configures the logger service to use a log file from logFilepath or run in terminal mode.
+// The log file is rotated according to rotation, which is ignored in terminal mode.
 // Returns a reference to the open log file, if a log file is specified.
-func Initialize(logFilepath string) *os.File {
-	var logFile *os.File // will only be populated if a logfile path is provided
+func Initialize(logFilepath string, rotation RotationConfig) *LogFile {
+	logFile = nil // will only be populated if a logfile path is provided
 
 	// Output to a logfile (will use text instead of emojis and no colors)
 	if logFilepath != "" {
-		logFile = setLogFileMode(logFilepath)
+		logFile = setLogFileMode(logFilepath, rotation)
 
 	} else {
 		// Only show output in terminal (stdout) (will use emojis and colors).
@@ -45,17 +49,35 @@ func Initialize(logFilepath string) *os.File {
 	errorLog.SetFlags(defaultFlags)
 
 	// Use this for CACHE [OPERATION] printing with / without color
+	terminalMode = logFile == nil
+
+	return logFile // Will be nil in terminal mode
+}
+
+// Reopen closes and reopens the log file and the access log file at the same paths.
+// This is used on SIGHUP so logging continues in a new file after an external tool (e.g. logrotate) has moved the old one.
+// It does nothing in terminal mode.
+func Reopen() {
+	if err := reopenAccessLog(); err != nil {
+		Error(fmt.Errorf("could not reopen access log file, got the following error: %v", err))
+	}
+
 	if logFile == nil {
-		terminalMode = true
+		return
 	}
 
-	return logFile // Will be nil in terminal mode
+	if err := logFile.Reopen(); err != nil {
+		fmt.Fprintf(os.Stderr, "could not reopen log file %q, got the following error: %v\n", logFile.Path(), err)
+		return
+	}
+
+	Info("reopened log file " + logFile.Path())
 }
 
-// setLogFileMode configures the logger to use a file at filepath.
+// setLogFileMode configures the logger to use a file at filepath, which is rotated according to rotation.
 // Returns a reference to the open log file.
-func setLogFileMode(filepath string) *os.File {
-	file, err := os.OpenFile(filepath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+func setLogFileMode(filepath string, rotation RotationConfig) *LogFile {
+	file, err := OpenLogFile(filepath, rotation)
 	if err != nil {
 		Fatal(fmt.Errorf("could not set log file %q, got the following error: %v", filepath, err))
 	}
@@ -151,6 +173,32 @@ func CacheSkip(key string) {
 	infoLog.Println(msg)
 }
 
+// CacheRevalidate will log a formatted message for a cache revalidate operation to key
+// with correct colors and cache operation indicator.
+func CacheRevalidate(key string) {
+	msg := "CACHE REVALIDATE" + prefixSeparator + key
+
+	if terminalMode {
+		clr := color.New(color.FgCyan, color.Bold)
+		msg = clr.Sprint(msg)
+	}
+
+	infoLog.Println(msg)
+}
+
+// CacheStaleRead will log a formatted message for a read of an expired entry under key,
+// which is served because the API cannot be reached, with correct colors and cache operation indicator.
+func CacheStaleRead(key string) {
+	msg := "CACHE STALE READ" + prefixSeparator + key
+
+	if terminalMode {
+		clr := color.New(color.FgYellow, color.Bold)
+		msg = clr.Sprint(msg)
+	}
+
+	infoLog.Println(msg)
+}
+
 // Info will log msg with the infoPrefix and correct icon.
 func Info(msg string) {
 	infoLog.Println(msg)
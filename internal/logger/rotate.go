@@ -0,0 +1,386 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is used to timestamp the names of rotated log files, e.g. logfile-2022-05-30T14-03-59.000.log.
+// It sorts lexically in chronological order, which is used to find the oldest backups to remove.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotationConfig describes when a log file should be rotated and what happens to the rotated files.
+// The zero value never rotates the log file.
+type RotationConfig struct {
+	// MaxSize is the size in bytes a log file can reach before it is rotated, 0 disables size-based rotation.
+	MaxSize uint64
+	// MaxAge is how long a log file is written to before it is rotated, 0 disables age-based rotation.
+	MaxAge time.Duration
+	// MaxBackups is how many rotated log files to keep, 0 keeps all of them.
+	MaxBackups uint
+	// Compress will gzip rotated log files.
+	Compress bool
+}
+
+// LogFile is an io.Writer that appends to the file at its path and rotates it according to its RotationConfig.
+// It can be reopened to follow the path after an external tool (e.g. logrotate) has moved the file.
+type LogFile struct {
+	mutex    sync.Mutex
+	path     string
+	rotation RotationConfig
+	file      *os.File
+	info      os.FileInfo // of the current file, to tell if Reopen opens the same file again
+	size      uint64      // bytes written to the current file, including what was there when it was opened
+	startedAt time.Time   // when the current file was started, used for age-based rotation
+
+	// compressing keeps track of rotated files being gzipped in the background, so Close can wait for them.
+	compressing sync.WaitGroup
+	// maintenance makes compressing and removing backups run one at a time,
+	// so backups are not counted (and removed) while another one is half compressed.
+	maintenance sync.Mutex
+}
+
+// OpenLogFile opens (or creates) the log file at path in append mode and returns a LogFile
+// that rotates it according to rotation.
+func OpenLogFile(path string, rotation RotationConfig) (*LogFile, error) {
+	logFile := &LogFile{
+		path:     path,
+		rotation: rotation,
+	}
+
+	if err := logFile.open(); err != nil {
+		return nil, err
+	}
+
+	return logFile, nil
+}
+
+// Write appends p to the log file, rotating the file first if writing p would exceed the size limit
+// or the file has been open for longer than the age limit.
+func (logFile *LogFile) Write(p []byte) (int, error) {
+	logFile.mutex.Lock()
+	defer logFile.mutex.Unlock()
+
+	if logFile.file == nil {
+		return 0, fmt.Errorf("log file %q is closed", logFile.path)
+	}
+
+	if logFile.shouldRotate(uint64(len(p))) {
+		if err := logFile.rotate(); err != nil {
+			// Keep logging to the current file rather than losing the message
+			fmt.Fprintf(os.Stderr, "could not rotate log file %q, got the following error: %v\n", logFile.path, err)
+		}
+	}
+
+	n, err := logFile.file.Write(p)
+	logFile.size += uint64(n)
+
+	return n, err
+}
+
+// Reopen closes the log file and opens the file at the same path again.
+// This is used on SIGHUP, after an external tool has moved the log file, so logging continues in a new file.
+func (logFile *LogFile) Reopen() error {
+	logFile.mutex.Lock()
+	defer logFile.mutex.Unlock()
+
+	if logFile.file != nil {
+		logFile.file.Close()
+	}
+
+	return logFile.open()
+}
+
+// Close closes the log file and waits for any rotated files to finish compressing.
+func (logFile *LogFile) Close() error {
+	logFile.mutex.Lock()
+
+	var err error
+	if logFile.file != nil {
+		err = logFile.file.Close()
+		logFile.file = nil
+	}
+
+	logFile.mutex.Unlock()
+
+	// Wait without the mutex, so writes from other goroutines fail right away instead of waiting for the compression.
+	// No more compression is started once the file is closed, since closed files are not written to or rotated.
+	logFile.compressing.Wait()
+
+	return err
+}
+
+// Path returns the path of the log file.
+func (logFile *LogFile) Path() string {
+	return logFile.path
+}
+
+// open opens the file at the LogFile's path and sets the size and age used for rotation.
+// The age is kept when the same file is opened again, and otherwise it is found with startTime.
+// NOTE: The calling operation must hold the mutex.
+func (logFile *LogFile) open() error {
+	file, err := os.OpenFile(logFile.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		logFile.file = nil
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		logFile.file = nil
+		return err
+	}
+
+	if logFile.info == nil || !os.SameFile(logFile.info, info) {
+		logFile.startedAt = logFile.startTime(info)
+	}
+
+	logFile.file = file
+	logFile.info = info
+	logFile.size = uint64(info.Size())
+
+	return nil
+}
+
+// startTime returns when the log file with the info was started, so restarting the process does not reset its age.
+// An empty file has just been started. A file with content was started when the newest backup was rotated,
+// or if there are no backups, it is at least as old as its last write.
+func (logFile *LogFile) startTime(info os.FileInfo) time.Time {
+	if info.Size() == 0 {
+		return time.Now()
+	}
+
+	if backups, err := logFile.backups(); err == nil && len(backups) > 0 {
+		if rotatedAt, ok := logFile.backupTime(backups[len(backups)-1]); ok {
+			return rotatedAt
+		}
+	}
+
+	return info.ModTime()
+}
+
+// shouldRotate returns true if writing another n bytes to the current file would break the rotation limits.
+// An empty file is never rotated, since that would just produce empty backups.
+func (logFile *LogFile) shouldRotate(n uint64) bool {
+	if logFile.size == 0 {
+		return false
+	}
+
+	maxSize := logFile.rotation.MaxSize
+	if maxSize != 0 && logFile.size+n > maxSize {
+		return true
+	}
+
+	maxAge := logFile.rotation.MaxAge
+	if maxAge != 0 && time.Since(logFile.startedAt) >= maxAge {
+		return true
+	}
+
+	return false
+}
+
+// rotate moves the current log file to a timestamped backup, opens a new file at the original path,
+// and then compresses and removes old backups as configured.
+// NOTE: The calling operation must hold the mutex.
+func (logFile *LogFile) rotate() error {
+	if err := logFile.file.Close(); err != nil {
+		return err
+	}
+
+	backupPath := logFile.backupPath(time.Now())
+
+	if err := os.Rename(logFile.path, backupPath); err != nil {
+		// Try to keep writing to the original file, even though it could not be moved
+		if openErr := logFile.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+
+	if err := logFile.open(); err != nil {
+		return err
+	}
+
+	if logFile.rotation.Compress {
+		logFile.compressing.Add(1)
+		go func() {
+			defer logFile.compressing.Done()
+
+			logFile.maintenance.Lock()
+			defer logFile.maintenance.Unlock()
+
+			if err := compressFile(backupPath); err != nil {
+				fmt.Fprintf(os.Stderr, "could not compress rotated log file %q, got the following error: %v\n", backupPath, err)
+			}
+
+			logFile.removeOldBackups()
+		}()
+
+		return nil
+	}
+
+	logFile.maintenance.Lock()
+	defer logFile.maintenance.Unlock()
+
+	logFile.removeOldBackups()
+
+	return nil
+}
+
+// backupPath returns the path of a rotated log file, which is the original path with a timestamp
+// inserted before the extension, e.g. logfile-2022-05-30T14-03-59.000.log.
+// If a backup already exists with that timestamp, the timestamp is moved forward until the path is unused.
+func (logFile *LogFile) backupPath(rotatedAt time.Time) string {
+	prefix, ext := logFile.backupNameParts()
+
+	for {
+		path := filepath.Join(filepath.Dir(logFile.path), prefix+rotatedAt.Format(backupTimeFormat)+ext)
+
+		if !fileExists(path) && !fileExists(path+".gz") {
+			return path
+		}
+
+		rotatedAt = rotatedAt.Add(time.Millisecond)
+	}
+}
+
+// fileExists returns true if there is a file at path.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// backupNameParts returns the parts of a backup's filename that come before and after the timestamp.
+func (logFile *LogFile) backupNameParts() (prefix, ext string) {
+	name := filepath.Base(logFile.path)
+	ext = filepath.Ext(name)
+
+	return strings.TrimSuffix(name, ext) + "-", ext
+}
+
+// backups returns the paths of all rotated log files (compressed or not), oldest first.
+// A backup that is being compressed exists both with and without .gz, and it is only returned once, with .gz.
+func (logFile *LogFile) backups() ([]string, error) {
+	dir := filepath.Dir(logFile.path)
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byTimestamp := make(map[time.Time]string)
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(dir, file.Name())
+		rotatedAt, ok := logFile.backupTime(path)
+		if !ok {
+			continue // not one of our backups
+		}
+
+		if existing, found := byTimestamp[rotatedAt]; !found || !strings.HasSuffix(existing, ".gz") {
+			byTimestamp[rotatedAt] = path
+		}
+	}
+
+	backups := make([]string, 0, len(byTimestamp))
+	for _, path := range byTimestamp {
+		backups = append(backups, path)
+	}
+
+	sort.Strings(backups)
+
+	return backups, nil
+}
+
+// backupTime returns the time a backup at path was rotated, or false if path is not a backup of the log file.
+func (logFile *LogFile) backupTime(path string) (time.Time, bool) {
+	prefix, ext := logFile.backupNameParts()
+
+	name := filepath.Base(path)
+	if !strings.HasPrefix(name, prefix) {
+		return time.Time{}, false
+	}
+
+	timestamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz"), ext)
+	rotatedAt, err := time.ParseInLocation(backupTimeFormat, timestamp, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return rotatedAt, true
+}
+
+// removeOldBackups removes the oldest rotated log files, so only MaxBackups are kept.
+// NOTE: The calling operation must hold the maintenance mutex.
+func (logFile *LogFile) removeOldBackups() {
+	maxBackups := int(logFile.rotation.MaxBackups)
+	if maxBackups == 0 {
+		return
+	}
+
+	backups, err := logFile.backups()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not find rotated log files to remove, got the following error: %v\n", err)
+		return
+	}
+
+	if len(backups) <= maxBackups {
+		return
+	}
+
+	for _, backup := range backups[:len(backups)-maxBackups] {
+		// Remove both the compressed and uncompressed file, in case the backup was not compressed completely
+		for _, path := range []string{strings.TrimSuffix(backup, ".gz"), strings.TrimSuffix(backup, ".gz") + ".gz"} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "could not remove rotated log file %q, got the following error: %v\n", path, err)
+			}
+		}
+	}
+}
+
+// compressFile gzips the file at path into path.gz and removes the original file.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+
+	if _, err := io.Copy(gz, src); err != nil {
+		gz.Close()
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	src.Close()
+
+	return os.Remove(path)
+}
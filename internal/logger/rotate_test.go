@@ -0,0 +1,126 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotateOnSize(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "test.log")
+
+	logFile, err := OpenLogFile(path, RotationConfig{MaxSize: 10, MaxBackups: 2})
+	assert.NoError(err, "Expected OpenLogFile to create a new log file")
+	defer logFile.Close()
+
+	// Every write fills the file, so every write after the first one should rotate the file
+	for _, line := range []string{"line 1...\n", "line 2...\n", "line 3...\n", "line 4...\n"} {
+		logFile.Write([]byte(line))
+	}
+
+	current, _ := os.ReadFile(path)
+	assert.Equal("line 4...\n", string(current), "Expected the log file to only contain the last write after rotating")
+
+	backups, _ := logFile.backups()
+	assert.Len(backups, 2, "Expected rotated log files to be removed when there are more than MaxBackups, got %v", backups)
+
+	for _, backup := range backups {
+		assert.True(strings.HasPrefix(filepath.Base(backup), "test-"), "Expected rotated log file %q to be named after the log file", backup)
+		assert.Equal(".log", filepath.Ext(backup), "Expected rotated log file %q to keep the extension of the log file", backup)
+	}
+}
+
+func TestRotateCompress(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "test.log")
+
+	logFile, _ := OpenLogFile(path, RotationConfig{MaxSize: 10, Compress: true})
+
+	logFile.Write([]byte("line 1...\n"))
+	logFile.Write([]byte("line 2...\n"))
+
+	logFile.Close() // waits for compression
+
+	backups, _ := logFile.backups()
+	assert.Len(backups, 1, "Expected one rotated log file, got %v", backups)
+	assert.True(strings.HasSuffix(backups[0], ".log.gz"), "Expected rotated log file to be gzipped, got %q", backups[0])
+}
+
+func TestReopen(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.log")
+
+	logFile, _ := OpenLogFile(path, RotationConfig{})
+	defer logFile.Close()
+
+	logFile.Write([]byte("before\n"))
+
+	// This is what an external tool like logrotate does
+	os.Rename(path, filepath.Join(dir, "test.log.1"))
+
+	assert.NoError(logFile.Reopen(), "Expected LogFile.Reopen to create a new file at the original path")
+
+	logFile.Write([]byte("after\n"))
+
+	current, _ := os.ReadFile(path)
+	assert.Equal("after\n", string(current), "Expected writes after LogFile.Reopen to go to the new file")
+
+	moved, _ := os.ReadFile(filepath.Join(dir, "test.log.1"))
+	assert.Equal("before\n", string(moved), "Expected writes before LogFile.Reopen to stay in the moved file")
+}
+
+func TestRotateOnAge(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.log")
+
+	// The log file was started when the newest backup was rotated, before the process (re)started
+	rotatedAt := time.Now().Add(-2 * time.Hour)
+	os.WriteFile(filepath.Join(dir, "test-"+rotatedAt.Format(backupTimeFormat)+".log"), []byte("old\n"), 0666)
+	os.WriteFile(path, []byte("current\n"), 0666)
+
+	logFile, _ := OpenLogFile(path, RotationConfig{MaxAge: time.Hour})
+	defer logFile.Close()
+
+	logFile.Write([]byte("after restart\n"))
+
+	current, _ := os.ReadFile(path)
+	assert.Equal("after restart\n", string(current), "Expected a file older than MaxAge to be rotated, even though it was just opened")
+
+	// Reopening the same file (e.g. on SIGHUP when the configuration is reloaded) must not reset its age
+	logFile.startedAt = rotatedAt
+	assert.NoError(logFile.Reopen())
+	assert.Equal(rotatedAt, logFile.startedAt, "Expected LogFile.Reopen of the same file to keep its age")
+}
+
+func TestBackupsCountedOnce(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	logFile, _ := OpenLogFile(filepath.Join(dir, "test.log"), RotationConfig{MaxBackups: 1})
+	defer logFile.Close()
+
+	// A backup that is being compressed exists with and without .gz
+	older := "test-" + time.Now().Add(-time.Hour).Format(backupTimeFormat) + ".log"
+	newer := "test-" + time.Now().Format(backupTimeFormat) + ".log"
+	for _, name := range []string{older, newer, newer + ".gz"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("backup\n"), 0666)
+	}
+
+	backups, _ := logFile.backups()
+	assert.Equal([]string{filepath.Join(dir, older), filepath.Join(dir, newer+".gz")}, backups, "Expected a half compressed backup to be counted once")
+
+	logFile.removeOldBackups()
+	assert.NoFileExists(filepath.Join(dir, older), "Expected the oldest backup to be removed")
+	assert.FileExists(filepath.Join(dir, newer), "Expected a half compressed backup not to count as two backups")
+}
@@ -1,25 +1,57 @@
 package router
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/proxy"
 	"github.com/magnus-bb/cache-me-ousside/cache"
+	"github.com/magnus-bb/cache-me-ousside/internal/canonical"
+	"github.com/magnus-bb/cache-me-ousside/internal/config"
+	"github.com/magnus-bb/cache-me-ousside/internal/graphql"
+	"github.com/magnus-bb/cache-me-ousside/internal/idempotency"
 	"github.com/magnus-bb/cache-me-ousside/internal/logger"
+	"github.com/magnus-bb/cache-me-ousside/internal/upstream"
 )
 
-// createProxyHandler returns a route handler that will proxy all requests to apiUrl.
+// createProxyHandler returns a route handler that will proxy all requests to a server picked from the Pool of their upstream,
+// unless the circuit breaker of the upstream is open, in which case the request fails right away.
+// Requests that time out get a 504 status, and other requests that cannot be proxied get a 502 or 503 status.
 // It is always used as the last step of a request,
 // and as such it does not call Next() like middlewares.
 // This is used for all routes that are not cached and should just be proxied to the API.
-func createProxyHandler(apiUrl string) func(ctx *fiber.Ctx) error {
+func createProxyHandler(pools upstream.Pools) func(ctx *fiber.Ctx) error {
 	return func(ctx *fiber.Ctx) error {
-		url := apiUrl + ctx.OriginalURL()
+		pool := pools[upstreamName(ctx)]
 
-		if err := proxy.Do(ctx, url); err != nil {
-			logger.Error(fmt.Errorf("could not proxy request to: %v", url))
-			return err
+		// Don't log requests that fail fast, since the breaker logs when it opens
+		if err := pool.Breaker.Allow(); err != nil {
+			return fiber.NewError(fiber.StatusServiceUnavailable, err.Error())
+		}
+
+		// Time the API on its own, so it can be told apart from the time spent in the cache server in the access log
+		start := time.Now()
+		err := pool.Proxy(ctx.Request(), ctx.Response())
+		ctx.Locals("upstreamLatency", time.Since(start))
+
+		// Retries are not counted by the breaker, only whether the request succeeded in the end
+		pool.Breaker.Record(err != nil || ctx.Response().StatusCode() >= fiber.StatusInternalServerError)
+
+		switch {
+		case err == nil:
+		case errors.Is(err, upstream.ErrNoHealthyReplicas):
+			logger.Error(fmt.Errorf("could not proxy request to %s: %w", pool, err))
+			return fiber.NewError(fiber.StatusServiceUnavailable, err.Error())
+		case upstream.IsTLSError(err):
+			logger.Error(fmt.Errorf("could not proxy request to %s for %s, the TLS handshake failed: %w", pool, ctx.OriginalURL(), err))
+			return fiber.NewError(fiber.StatusBadGateway, "could not reach the API")
+		case upstream.IsTimeout(err):
+			logger.Error(fmt.Errorf("request to %s for %s timed out: %w", pool, ctx.OriginalURL(), err))
+			return fiber.NewError(fiber.StatusGatewayTimeout, "the API did not respond in time")
+		default:
+			logger.Error(fmt.Errorf("could not proxy request to %s for %s: %w", pool, ctx.OriginalURL(), err))
+			return fiber.NewError(fiber.StatusBadGateway, "could not reach the API")
 		}
 
 		// Remove Server header from response
@@ -33,96 +65,400 @@ func createProxyHandler(apiUrl string) func(ctx *fiber.Ctx) error {
 // by decorating createProxyHandler to also call Next() after running.
 // This is used for every route that is cached
 // so it is possible to save the proxied response to the cache.
-func createProxyMiddleware(apiUrl string) func(ctx *fiber.Ctx) error {
-	proxyHandler := createProxyHandler(apiUrl)
+func createProxyMiddleware(pools upstream.Pools) func(ctx *fiber.Ctx) error {
+	proxyHandler := createProxyHandler(pools)
 
 	return func(ctx *fiber.Ctx) error {
-		proxyHandler(ctx)
+		// Don't cache anything if the request could not be proxied
+		if err := proxyHandler(ctx); err != nil {
+			return err
+		}
 
-		ctx.Next()
-		return nil
+		return ctx.Next()
 	}
 }
 
-// readCacheMiddleware checks for existing cache entries on the http method and route
+// createReadCacheMiddleware returns a middleware that checks for existing cache entries on the http method and route
 // which it is applied to and sends the cached entry back to the requester if it exists.
 // If the entry does not exist, it calls Next() to proxy the request and get data from the api.
-func readCacheMiddleware(ctx *fiber.Ctx) error {
-	dataCache := ctx.Locals("cache").(*cache.LRUCache) // not called 'cache' to avoid conflict with package name
-	entryKey := entryKey(ctx)                          // which entry to look for in the cache
+// If the entry has expired, the request is proxied to revalidate it, and the entry is kept for another ttl if the API answers that it is not modified.
+// Expired entries are served as they are while the circuit breaker of the upstream is open, or if the API cannot be reached.
+func createReadCacheMiddleware(pools upstream.Pools, ttl time.Duration) func(*fiber.Ctx) error {
+	return func(ctx *fiber.Ctx) error {
+		dataCache := ctx.Locals("cache").(*cache.LRUCache) // not called 'cache' to avoid conflict with package name
+		entryKey := entryKey(ctx)                          // which entry to look for in the cache
+
+		// Responses with a Vary header are only served to requests with the same values of the headers they vary by
+		cachedData := dataCache.GetVariant(entryKey, requestHeader(ctx))
+
+		// If there is no cached data, continue middlewares to proxy the request
+		if cachedData == nil {
+			ctx.Set("X-LRU-Cache", "MISS")
+			ctx.Locals("cacheOutcome", logger.CacheMiss)
+
+			return ctx.Next()
+		}
+
+		if cachedData.Expired(time.Now()) {
+			return revalidate(ctx, pools[upstreamName(ctx)], dataCache, entryKey, cachedData, ttl)
+		}
+
+		// Let people know they've been served
+		ctx.Set("X-LRU-Cache", "HIT")
+		ctx.Locals("cacheOutcome", logger.CacheHit)
+
+		// Let SysAdmin know they served something from cache
+		logger.CacheRead(entryKey)
+
+		sendCached(ctx, cachedData, ctx.Get(fiber.HeaderIfNoneMatch), ctx.Get(fiber.HeaderIfModifiedSince))
+
+		return nil // don't continue middlewares in this case
+	}
+}
+
+// revalidate proxies a request for an expired entry to the API, with the ETag and Last-Modified of the entry as If-None-Match and If-Modified-Since.
+// If the API answers 304 Not Modified, the entry is kept for another ttl without downloading the body again, and it is sent like a fresh entry.
+// Any other response is sent as it is, and it replaces the entry if it is cacheable.
+// Only GET and HEAD requests are revalidated, since conditional headers mean something else for other methods, so other expired entries are fetched again.
+// The expired entry is served as a stale response instead if the circuit breaker of the pool is open, or if the request cannot be proxied.
+func revalidate(ctx *fiber.Ctx, pool *upstream.Pool, dataCache *cache.LRUCache, entryKey string, cachedData *cache.CacheData, ttl time.Duration) error {
+	// The conditional headers of the client are about its own copy, so they are checked against the entry when it is sent instead of being proxied
+	ifNoneMatch, ifModifiedSince := ctx.Get(fiber.HeaderIfNoneMatch), ctx.Get(fiber.HeaderIfModifiedSince)
+
+	// Don't wait for an API that is known to be down, since the expired entry is better than an error
+	if pool.Breaker.State() == upstream.BreakerOpen {
+		sendStale(ctx, entryKey, cachedData, ifNoneMatch, ifModifiedSince)
+		return nil
+	}
+
+	conditional := cachedData.HasValidators() && (ctx.Method() == fiber.MethodGet || ctx.Method() == fiber.MethodHead)
+	if conditional {
+		ctx.Request().Header.Del(fiber.HeaderIfNoneMatch)
+		ctx.Request().Header.Del(fiber.HeaderIfModifiedSince)
+
+		if cachedData.ETag != "" {
+			ctx.Request().Header.Set(fiber.HeaderIfNoneMatch, cachedData.ETag)
+		}
+		if cachedData.LastModified != "" {
+			ctx.Request().Header.Set(fiber.HeaderIfModifiedSince, cachedData.LastModified)
+		}
+	}
 
-	cachedData := dataCache.Get(entryKey)
+	// The proxied response replaces the expired entry in writeCacheMiddleware, unless it is a 304
+	ctx.Locals("expiredData", cachedData)
+	ctx.Set("X-LRU-Cache", "MISS")
+	ctx.Locals("cacheOutcome", logger.CacheMiss)
 
-	// If there is no cached data, continue middlewares to proxy the request
-	if cachedData == nil {
-		ctx.Set("X-LRU-Cache", "MISS")
+	if err := ctx.Next(); err != nil {
+		// The proxy only fails when the API could not be reached (or the breaker opened in the meantime), so nothing has been cached
+		sendStale(ctx, entryKey, cachedData, ifNoneMatch, ifModifiedSince)
+		return nil
+	}
 
-		ctx.Next()
+	if !conditional || ctx.Response().StatusCode() != fiber.StatusNotModified {
 		return nil
 	}
 
+	// The 304 of the API is about the validators of the entry, not the copy of the client, so the refreshed entry is sent instead
+	revalidated := cachedData.Revalidated(ctx.GetRespHeaders(), time.Now().Add(ttl))
+	dataCache.Replace(entryKey, cachedData, revalidated)
+
+	ctx.Status(fiber.StatusOK)
+	ctx.Set("X-LRU-Cache", "REVALIDATED")
+	ctx.Locals("cacheOutcome", logger.CacheRevalidated)
+
+	logger.CacheRevalidate(entryKey)
+
+	sendCached(ctx, revalidated, ifNoneMatch, ifModifiedSince)
+
+	return nil
+}
+
+// sendStale sends the expired cached data, because the API cannot be reached to revalidate it.
+func sendStale(ctx *fiber.Ctx, entryKey string, cachedData *cache.CacheData, ifNoneMatch, ifModifiedSince string) {
+	ctx.Status(fiber.StatusOK)
+	ctx.Set("X-LRU-Cache", "STALE")
+	ctx.Locals("cacheOutcome", logger.CacheStale)
+
+	logger.CacheStaleRead(entryKey)
+
+	sendCached(ctx, cachedData, ifNoneMatch, ifModifiedSince)
+}
+
+// sendCached sets the headers and body of the cached data on the response,
+// or answers 304 Not Modified without a body to GET and HEAD requests whose If-None-Match or If-Modified-Since show that the client already has the data.
+func sendCached(ctx *fiber.Ctx, cachedData *cache.CacheData, ifNoneMatch, ifModifiedSince string) {
 	// Set all of the cached headers on the current response
 	cachedData.SetHeaders(ctx)
 
-	// Let people know they've been served
-	ctx.Set("X-LRU-Cache", "HIT")
-
-	// Let SysAdmin know they served something from cache
-	logger.CacheRead(entryKey)
+	if (ctx.Method() == fiber.MethodGet || ctx.Method() == fiber.MethodHead) && cachedData.NotModified(ifNoneMatch, ifModifiedSince) {
+		ctx.Status(fiber.StatusNotModified)
+		return
+	}
 
 	ctx.Send(cachedData.Body)
+}
+
+// createWriteCacheMiddleware returns a middleware that runs after a cacheable request has been proxied to the API.
+// It saves the API response to the cache so it can be read on the next request, and it expires after ttl, unless ttl is 0.
+func createWriteCacheMiddleware(ttl time.Duration) func(*fiber.Ctx) error {
+	return func(ctx *fiber.Ctx) error {
+		entryKey := entryKey(ctx) // the name to use when saving the entry in cache
+		expiredData, revalidating := ctx.Locals("expiredData").(*cache.CacheData)
+
+		// If the API answered a revalidation with 304, the expired entry is refreshed by the read middleware instead
+		status := ctx.Response().StatusCode()
+		if revalidating && status == fiber.StatusNotModified {
+			return nil
+		}
+
+		// If the response is not a 2xx, or it is a GraphQL response with errors, don't cache it
+		if status < 200 || status >= 300 || ctx.Locals("graphql") != nil && graphql.HasErrors(ctx.Response().Body()) {
+			logger.CacheSkip(entryKey)
+			return nil
+		}
+
+		// Responses that vary by something other than request headers can never be served to another request
+		vary, cacheable := cache.ParseVary(string(ctx.Response().Header.Peek(fiber.HeaderVary)))
+		if !cacheable {
+			logger.CacheSkip(entryKey)
+			return nil
+		}
+
+		dataCache := ctx.Locals("cache").(*cache.LRUCache) // not called 'cache' to avoid conflict with package name
+
+		// Init the current response
+		apiResponse := cache.CacheData{
+			Headers:      ctx.GetRespHeaders(),
+			Body:         append([]byte(nil), ctx.Response().Body()...), // copy, since fasthttp reuses the body buffer for later responses
+			Vary:         vary,
+			ETag:         ctx.GetRespHeader(fiber.HeaderETag),
+			LastModified: ctx.GetRespHeader(fiber.HeaderLastModified),
+		}
+		if ttl > 0 {
+			apiResponse.Expires = time.Now().Add(ttl)
+		}
 
-	return nil // don't continue middlewares in this case
+		// Save the api response in cache, as a variant of the entry if it varies by request headers,
+		// or in place of the expired entry, unless it has been busted in the meantime
+		if !revalidating || !dataCache.Replace(entryKey, expiredData, &apiResponse) {
+			dataCache.SetVariant(entryKey, &apiResponse, requestHeader(ctx))
+		}
+
+		logger.CacheWrite(entryKey)
+
+		return nil // this is always last step, so no Next()
+	}
 }
 
-// writeCacheMiddleware runs after a cacheable request has been proxied to the API.
-// It saves the API response to the cache so it can be read on the next request.
-func writeCacheMiddleware(ctx *fiber.Ctx) error {
-	entryKey := entryKey(ctx) // the name to use when saving the entry in cache
+// createBustMiddleware returns a middleware that will bust the cache
+// for entries that match the patterns when the routes that the middleware is applied to are matched.
+// Nothing is busted while the circuit breaker of the upstream is open and set to serve stale entries.
+func createBustMiddleware(pools upstream.Pools, patterns []string) func(*fiber.Ctx) error {
+	return func(ctx *fiber.Ctx) error {
+		bustEntries(ctx, pools, patterns)
 
-	// If the response is not a 2xx, don't cache it
-	status := ctx.Response().StatusCode()
-	if status < 200 && status >= 300 {
-		logger.CacheSkip(entryKey)
-		return nil
+		return ctx.Next()
+	}
+}
+
+// bustEntries removes the cache entries of the upstream of the request that match the patterns, with the route params of the request inserted.
+// An empty list of patterns busts all entries of the upstream.
+func bustEntries(ctx *fiber.Ctx, pools upstream.Pools, patterns []string) {
+	if pools[upstreamName(ctx)].Breaker.ServesStale() {
+		return
 	}
 
 	dataCache := ctx.Locals("cache").(*cache.LRUCache) // not called 'cache' to avoid conflict with package name
 
-	// Init the current response
-	apiResponse := cache.CacheData{
-		Headers: ctx.GetRespHeaders(),
-		Body:    ctx.Response().Body(),
+	// Segments of key templates in the patterns, like {header:X-Tenant}, are replaced with the values of this request
+	patterns = config.HydrateKeySegments(keyRequest(ctx), patterns)
+
+	// Now find all cache entries from the same upstream that match the regex pattern or specific route with param
+	matchedEntries := dataCache.MatchNamespace(upstreamName(ctx), patterns, bustParams(ctx))
+
+	// Remove the matched entries from the cache
+	dataCache.Bust(matchedEntries...)
+
+	// Several bust routes can match the same request, so keep a running count for the access log
+	bustedKeys, _ := ctx.Locals("bustedKeys").(int)
+	ctx.Locals("bustedKeys", bustedKeys+len(matchedEntries))
+}
+
+// createBodyKeyMiddleware returns a middleware for cached routes of the BodyKeyedHTTPMethods, e.g. POST search endpoints,
+// which gives requests a cache key made from their route and a hash of their canonical body, so requests with the same route and body share an entry.
+// Requests with a body larger than limit bytes are proxied without being cached.
+func createBodyKeyMiddleware(pools upstream.Pools, limit int) func(*fiber.Ctx) error {
+	proxyHandler := createProxyHandler(pools)
+
+	return func(ctx *fiber.Ctx) error {
+		body := ctx.Body()
+		if len(body) > limit {
+			return proxyHandler(ctx)
+		}
+
+		bodyHash := canonical.Hash(canonical.Body(body, ctx.Get(fiber.HeaderContentType)))
+		ctx.Locals("entryKey", cache.NamespacedKey(upstreamName(ctx), requestKey(ctx)+"#body="+bodyHash))
+
+		return ctx.Next()
 	}
+}
 
-	// Save the api response in cache
-	dataCache.Set(entryKey, &apiResponse)
+// createGraphQLMiddleware returns a middleware for GraphQL endpoints, which gives queries a cache key made from their
+// normalized query text, operation name, and variables, so the next middlewares can cache them like any other request.
+// Mutations bust the entries of the queries and types that settings lists for their operation name and are proxied without being cached,
+// like subscriptions and requests that cannot be read as a single GraphQL operation.
+func createGraphQLMiddleware(pools upstream.Pools, settings config.GraphQL) func(*fiber.Ctx) error {
+	proxyHandler := createProxyHandler(pools)
 
-	logger.CacheWrite(entryKey)
+	return func(ctx *fiber.Ctx) error {
+		operation, err := graphql.Parse(ctx.Body())
+		if err != nil {
+			return proxyHandler(ctx) // let the API respond to requests it might understand better
+		}
+
+		switch operation.Type {
+		case graphql.Query:
+			key := operation.Key(keyURL(ctx), settings.Types[operation.Name])
+			ctx.Locals("entryKey", cache.NamespacedKey(upstreamName(ctx), key))
+			ctx.Locals("graphql", true)
 
-	return nil // this is always last step, so no Next()
+			return ctx.Next()
+
+		case graphql.Mutation:
+			if targets := settings.Bust[operation.Name]; len(targets) > 0 { // an empty list of patterns would bust everything
+				bustEntries(ctx, pools, graphql.BustPatterns(keyURL(ctx), targets))
+			}
+		}
+
+		return proxyHandler(ctx)
+	}
 }
 
-// createBustMiddleware returns a middleware that will bust the cache
-// for entries that match the patterns when the routes that the middleware is applied to are matched.
-func createBustMiddleware(patterns []string) func(*fiber.Ctx) error {
+// createIdempotencyMiddleware returns a middleware that stores the response of a request with an Idempotency-Key header
+// under the key and replays it to retries with the same key, so they are not sent to the API again.
+// Keys are scoped by the client header of the settings, so a client only gets the responses to its own keys.
+// Retries that arrive while the first request is in flight wait for its response, and are rejected with 409 if it takes longer than the wait of the settings.
+// A key that is reused for a request with another method, URL, or body is rejected with 422. Responses with a 5xx status are not stored, so they can be retried.
+func createIdempotencyMiddleware(replays *idempotency.Store, settings config.Idempotency) func(*fiber.Ctx) error {
 	return func(ctx *fiber.Ctx) error {
-		dataCache := ctx.Locals("cache").(*cache.LRUCache) // not called 'cache' to avoid conflict with package name
+		key := ctx.Get(idempotency.Header)
+		if key == "" {
+			return ctx.Next()
+		}
+
+		bodyHash := canonical.Hash(canonical.Body(ctx.Body(), ctx.Get(fiber.HeaderContentType)))
+		fingerprint := ctx.Method() + ":" + ctx.OriginalURL() + "#body=" + bodyHash
 
-		// Now find all cache entries that match the regex pattern or specific route with param
-		matchedEntries := dataCache.Match(patterns, ctx.AllParams())
+		// Keys are only unique to each client, so they are stored under a hash of the client header (which may hold credentials),
+		// and the same key could still be used by clients of different upstreams
+		clientHash := canonical.Hash([]byte(ctx.Get(settings.ClientHeader)))
+		scopedKey := cache.NamespacedKey(upstreamName(ctx), key+"#client="+clientHash)
 
-		// Remove the matched entries from the cache
-		dataCache.Bust(matchedEntries...)
+		response, claim, err := replays.Begin(scopedKey, fingerprint, int(settings.MaxKeys), settings.Wait.Std())
+		switch {
+		case errors.Is(err, idempotency.ErrMismatch):
+			return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+		case errors.Is(err, idempotency.ErrInFlight):
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		case errors.Is(err, idempotency.ErrFull):
+			logger.Warn(fmt.Sprintf("the response to %s %s is not stored under its %s: %s", ctx.Method(), ctx.OriginalURL(), idempotency.Header, err))
+			return ctx.Next()
+		case response != nil:
+			ctx.Status(response.Status)
+			for name, value := range response.Headers {
+				ctx.Set(name, value)
+			}
+			ctx.Set("Idempotent-Replayed", "true")
+			ctx.Locals("cacheOutcome", logger.CacheReplay)
+
+			return ctx.Send(response.Body)
+		}
+
+		if err := ctx.Next(); err != nil {
+			claim.Release()
+			return err
+		}
+
+		if ctx.Response().StatusCode() >= fiber.StatusInternalServerError {
+			claim.Release()
+			return nil
+		}
+
+		claim.Finish(idempotency.Response{
+			Status:  ctx.Response().StatusCode(),
+			Headers: ctx.GetRespHeaders(),
+			Body:    append([]byte(nil), ctx.Response().Body()...), // copy, since fasthttp reuses the body buffer for later responses
+		}, settings.Retention.Std())
 
-		ctx.Next()
 		return nil
 	}
 }
 
+// createStatsHandler returns a route handler that responds with the state of the upstream Pools as JSON.
+func createStatsHandler(pools upstream.Pools) func(ctx *fiber.Ctx) error {
+	return func(ctx *fiber.Ctx) error {
+		return ctx.JSON(fiber.Map{
+			"upstreams": pools.Stats(),
+		})
+	}
+}
+
+// accessLogMiddleware wraps all other handlers and writes a line to the access log when the request has been handled.
+// The other handlers report how the request was handled through ctx.Locals:
+// "cacheOutcome" (defaults to BYPASS), "upstreamLatency", and "bustedKeys".
+func accessLogMiddleware(ctx *fiber.Ctx) error {
+	start := time.Now()
+
+	// Let the error handler set the status code before logging it, like the fiber logger middleware does
+	if err := ctx.Next(); err != nil {
+		if err := ctx.App().Config().ErrorHandler(ctx, err); err != nil {
+			ctx.SendStatus(fiber.StatusInternalServerError)
+		}
+	}
+
+	cacheOutcome, ok := ctx.Locals("cacheOutcome").(string)
+	if !ok {
+		cacheOutcome = logger.CacheBypass
+	}
+	upstreamLatency, _ := ctx.Locals("upstreamLatency").(time.Duration)
+	bustedKeys, _ := ctx.Locals("bustedKeys").(int)
+
+	logger.Access(logger.AccessEntry{
+		Time:            start,
+		ClientIP:        ctx.IP(),
+		Method:          ctx.Method(),
+		URL:             ctx.OriginalURL(),
+		Protocol:        string(ctx.Request().Header.Protocol()),
+		Status:          ctx.Response().StatusCode(),
+		Bytes:           len(ctx.Response().Body()),
+		Latency:         time.Since(start),
+		UpstreamLatency: upstreamLatency,
+		CacheOutcome:    cacheOutcome,
+		BustedKeys:      bustedKeys,
+		Referer:         ctx.Get(fiber.HeaderReferer),
+		UserAgent:       ctx.Get(fiber.HeaderUserAgent),
+	})
+
+	return nil
+}
+
+// requestHeader returns a function that returns the value of a header of the request, which is used to find the variant of an entry for the request.
+func requestHeader(ctx *fiber.Ctx) func(name string) string {
+	return func(name string) string {
+		return ctx.Get(name)
+	}
+}
+
 // entryKey returns takes a route handler fiber context and returns a key
 // that can be used to store the entry in the cache.
-// It is in the format [http method]:[route].
+// It is in the format [http method]:[route], prefixed with [upstream]| for requests that are not proxied to the ApiUrl,
+// where the route is normalized by the KeyPolicy of the request, or in the format of the Template of the policy,
+// unless an earlier middleware has set a key made from the body of the request in ctx.Locals("entryKey"), like for POST requests and GraphQL queries.
 func entryKey(ctx *fiber.Ctx) string {
-	return ctx.Method() + ":" + ctx.OriginalURL()
+	if key, ok := ctx.Locals("entryKey").(string); ok {
+		return key
+	}
+
+	return cache.NamespacedKey(upstreamName(ctx), requestKey(ctx))
 }
@@ -0,0 +1,420 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/magnus-bb/cache-me-ousside/cache"
+	"github.com/magnus-bb/cache-me-ousside/internal/config"
+	"github.com/magnus-bb/cache-me-ousside/internal/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.Initialize("", logger.RotationConfig{})
+}
+
+// newTestRouter starts an API with the handler, points the configuration at it, and returns a Router for it with an empty cache.
+func newTestRouter(t *testing.T, conf *config.Config, handler http.HandlerFunc) (*Router, *cache.LRUCache) {
+	api := httptest.NewServer(handler)
+	t.Cleanup(api.Close)
+	conf.ApiUrl = api.URL
+
+	lru, err := cache.New(10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router, err := New(conf, lru)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return router, lru
+}
+
+// doRequest sends the request to the router and returns the response with its body, failing the test if it cannot be sent.
+func doRequest(t *testing.T, router *Router, request *http.Request) (*http.Response, string) {
+	response, err := router.app.Test(request, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return response, string(body)
+}
+
+// newIdempotentRequest returns a POST request to /orders with the Idempotency-Key, Authorization header, and body.
+func newIdempotentRequest(key, authorization, body string) *http.Request {
+	request := httptest.NewRequest("POST", "/orders", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Idempotency-Key", key)
+	request.Header.Set("Authorization", authorization)
+
+	return request
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls int32
+	conf := config.New()
+	conf.Idempotency.Retention = config.Duration(time.Hour)
+	router, _ := newTestRouter(t, conf, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	})
+
+	response, body := doRequest(t, router, newIdempotentRequest("a", "Bearer alice", `{"item":1,"amount":2}`))
+	assert.Equal(http.StatusCreated, response.StatusCode)
+	assert.Empty(response.Header.Get("Idempotent-Replayed"))
+
+	response, body = doRequest(t, router, newIdempotentRequest("a", "Bearer alice", `{"amount":2,"item":1}`))
+	assert.Equal(http.StatusCreated, response.StatusCode)
+	assert.Equal("true", response.Header.Get("Idempotent-Replayed"), "Expected a retry with the same key and body to be replayed")
+	assert.Equal("created", body)
+	assert.EqualValues(1, atomic.LoadInt32(&calls), "Expected a replayed retry not to be sent to the API")
+
+	response, _ = doRequest(t, router, newIdempotentRequest("a", "Bearer bob", `{"item":1,"amount":2}`))
+	assert.Empty(response.Header.Get("Idempotent-Replayed"), "Expected the same key from another client not to be replayed")
+	assert.EqualValues(2, atomic.LoadInt32(&calls))
+}
+
+func TestIdempotencyMismatch(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls int32
+	conf := config.New()
+	conf.Idempotency.Retention = config.Duration(time.Hour)
+	router, _ := newTestRouter(t, conf, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	doRequest(t, router, newIdempotentRequest("a", "Bearer alice", `{"item":1}`))
+
+	response, _ := doRequest(t, router, newIdempotentRequest("a", "Bearer alice", `{"item":2}`))
+	assert.Equal(http.StatusUnprocessableEntity, response.StatusCode, "Expected a reused key with another body to be rejected")
+	assert.EqualValues(1, atomic.LoadInt32(&calls), "Expected a rejected request not to be sent to the API")
+}
+
+func TestIdempotencyWait(t *testing.T) {
+	assert := assert.New(t)
+
+	release := make(chan struct{})
+	conf := config.New()
+	conf.Idempotency.Retention = config.Duration(time.Hour)
+	conf.Idempotency.Wait = config.Duration(20 * time.Millisecond)
+	router, _ := newTestRouter(t, conf, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	first := make(chan int)
+	go func() {
+		response, _ := doRequest(t, router, newIdempotentRequest("a", "Bearer alice", `{"item":1}`))
+		first <- response.StatusCode
+	}()
+
+	time.Sleep(10 * time.Millisecond) // let the first request reach the API
+	response, _ := doRequest(t, router, newIdempotentRequest("a", "Bearer alice", `{"item":1}`))
+	assert.Equal(http.StatusConflict, response.StatusCode, "Expected a retry to be rejected when the first request takes longer than the wait")
+
+	close(release)
+	assert.Equal(http.StatusCreated, <-first)
+}
+
+func TestKeyPolicyRequests(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls int32
+	conf := config.New()
+	conf.Cache["GET"] = []string{"/posts"}
+	conf.KeyPolicies = map[string]config.KeyPolicy{
+		"/posts": {SortQuery: true, IgnoreParams: []string{"utm_*"}},
+	}
+	router, lru := newTestRouter(t, conf, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte(`{"posts":[]}`))
+	})
+
+	doRequest(t, router, httptest.NewRequest("GET", "/posts?a=1&b=2", nil))
+	assert.Equal([]string{"GET:/posts?a=1&b=2"}, lru.CachedKeys())
+
+	for _, url := range []string{"/posts?b=2&a=1", "/posts?a=1&b=2&utm_source=x"} {
+		response, body := doRequest(t, router, httptest.NewRequest("GET", url, nil))
+		assert.Equal("HIT", response.Header.Get("X-LRU-Cache"), "Expected %s to hit the entry of /posts?a=1&b=2", url)
+		assert.Equal(`{"posts":[]}`, body)
+	}
+
+	assert.EqualValues(1, atomic.LoadInt32(&calls), "Expected only the first request to be sent to the API")
+	assert.Len(lru.CachedKeys(), 1)
+}
+
+func TestKeyTemplateRequests(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.New()
+	conf.Cache["GET"] = []string{"/posts"}
+	conf.KeyPolicies = map[string]config.KeyPolicy{"/posts": {Template: "{method}:{path}|{header:X-Tenant}"}}
+	conf.Bust["POST"]["/posts"] = []string{`^GET:/posts\|{header:X-Tenant}$`}
+	router, lru := newTestRouter(t, conf, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("posts of " + r.Header.Get("X-Tenant")))
+	})
+
+	tenantRequest := func(method, tenant string) *http.Request {
+		request := httptest.NewRequest(method, "/posts", nil)
+		request.Header.Set("X-Tenant", tenant)
+		return request
+	}
+
+	doRequest(t, router, tenantRequest("GET", "a"))
+	doRequest(t, router, tenantRequest("GET", "b"))
+	assert.ElementsMatch([]string{"GET:/posts|a", "GET:/posts|b"}, lru.CachedKeys(), "Expected every tenant to get its own entry")
+
+	for _, tenant := range []string{"a", "b"} {
+		response, body := doRequest(t, router, tenantRequest("GET", tenant))
+		assert.Equal("HIT", response.Header.Get("X-LRU-Cache"))
+		assert.Equal("posts of "+tenant, body, "Expected every tenant to be served its own entry")
+	}
+
+	doRequest(t, router, tenantRequest("POST", "a"))
+	assert.Equal([]string{"GET:/posts|b"}, lru.CachedKeys(), "Expected a bust to only remove the entries of the tenant of the busting request")
+}
+
+func TestBodyKeyRequests(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls int32
+	conf := config.New()
+	conf.Cache["POST"] = []string{"/search"}
+	conf.Bust["DELETE"]["/search"] = []string{"^POST:/search"}
+	conf.CacheBodyLimit = 1 // KB
+	router, lru := newTestRouter(t, conf, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte(`{"results":[]}`))
+	})
+
+	search := func(body string) *http.Response {
+		request := httptest.NewRequest("POST", "/search", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		response, _ := doRequest(t, router, request)
+		return response
+	}
+
+	search(`{"query":"cats","page":1}`)
+	keys := lru.CachedKeys()
+	if assert.Len(keys, 1) {
+		assert.True(strings.HasPrefix(keys[0], "POST:/search#body="), "Expected the entry to be keyed by the hash of the body, got %q", keys[0])
+	}
+
+	response := search(`{ "page": 1, "query": "cats" }`)
+	assert.Equal("HIT", response.Header.Get("X-LRU-Cache"), "Expected a body with reordered keys to hit the same entry")
+	assert.EqualValues(1, atomic.LoadInt32(&calls))
+
+	large := `{"query":"` + strings.Repeat("a", 1024) + `"}`
+	search(large)
+	response = search(large)
+	assert.NotEqual("HIT", response.Header.Get("X-LRU-Cache"), "Expected a body over the limit to be proxied without being cached")
+	assert.Len(lru.CachedKeys(), 1)
+	assert.EqualValues(3, atomic.LoadInt32(&calls))
+
+	doRequest(t, router, httptest.NewRequest("DELETE", "/search", nil))
+	assert.Empty(lru.CachedKeys(), "Expected ^POST:/search to bust the entries keyed by body")
+}
+
+func TestVaryRequests(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.New()
+	conf.Cache["GET"] = []string{"/posts"}
+	conf.Bust["DELETE"]["/posts"] = []string{"^GET:/posts"}
+	router, lru := newTestRouter(t, conf, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte("posts in " + r.Header.Get("Accept-Language")))
+	})
+
+	getPosts := func(language string) (*http.Response, string) {
+		request := httptest.NewRequest("GET", "/posts", nil)
+		request.Header.Set("Accept-Language", language)
+		return doRequest(t, router, request)
+	}
+
+	getPosts("en")
+	getPosts("da")
+	assert.Equal([]string{"GET:/posts"}, lru.CachedKeys(), "Expected both variants to be stored in the entry of the URL")
+
+	for _, language := range []string{"en", "da"} {
+		response, body := getPosts(language)
+		assert.Equal("HIT", response.Header.Get("X-LRU-Cache"))
+		assert.Equal("posts in "+language, body, "Expected each variant to be served to requests with the same Accept-Language")
+	}
+
+	doRequest(t, router, httptest.NewRequest("DELETE", "/posts", nil))
+	assert.Empty(lru.CachedKeys(), "Expected one bust pattern to bust all variants of the entry")
+
+	response, _ := getPosts("en")
+	assert.NotEqual("HIT", response.Header.Get("X-LRU-Cache"))
+}
+
+func TestCacheTTLRequests(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls int32
+	conf := config.New()
+	conf.Cache["GET"] = []string{"/posts"}
+	conf.CacheTTL = config.Duration(50 * time.Millisecond)
+	router, lru := newTestRouter(t, conf, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmt.Sprintf("posts %d", atomic.AddInt32(&calls, 1))))
+	})
+
+	doRequest(t, router, httptest.NewRequest("GET", "/posts", nil))
+	response, body := doRequest(t, router, httptest.NewRequest("GET", "/posts", nil))
+	assert.Equal("HIT", response.Header.Get("X-LRU-Cache"), "Expected the entry to be served before it expires")
+	assert.Equal("posts 1", body)
+
+	time.Sleep(60 * time.Millisecond)
+	_, body = doRequest(t, router, httptest.NewRequest("GET", "/posts", nil))
+	assert.Equal("posts 2", body, "Expected an expired entry to be fetched from the API again")
+
+	response, body = doRequest(t, router, httptest.NewRequest("GET", "/posts", nil))
+	assert.Equal("HIT", response.Header.Get("X-LRU-Cache"))
+	assert.Equal("posts 2", body, "Expected the new response to replace the expired entry")
+	assert.Equal([]string{"GET:/posts"}, lru.CachedKeys())
+}
+
+func TestRevalidateRequests(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls, notModified int32
+	conf := config.New()
+	conf.Cache["GET"] = []string{"/posts"}
+	conf.CacheTTL = config.Duration(50 * time.Millisecond)
+	router, _ := newTestRouter(t, conf, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("posts"))
+	})
+
+	doRequest(t, router, httptest.NewRequest("GET", "/posts", nil))
+
+	conditional := httptest.NewRequest("GET", "/posts", nil)
+	conditional.Header.Set("If-None-Match", `"v1"`)
+	response, body := doRequest(t, router, conditional)
+	assert.Equal(http.StatusNotModified, response.StatusCode, "Expected a client that has the entry to be answered with 304 from the cache")
+	assert.Equal("HIT", response.Header.Get("X-LRU-Cache"))
+	assert.Empty(body)
+	assert.EqualValues(1, atomic.LoadInt32(&calls))
+
+	time.Sleep(60 * time.Millisecond)
+	response, body = doRequest(t, router, httptest.NewRequest("GET", "/posts", nil))
+	assert.Equal(http.StatusOK, response.StatusCode)
+	assert.Equal("REVALIDATED", response.Header.Get("X-LRU-Cache"), "Expected an expired entry to be revalidated with its ETag")
+	assert.Equal("posts", body)
+	assert.EqualValues(1, atomic.LoadInt32(&notModified))
+
+	response, _ = doRequest(t, router, httptest.NewRequest("GET", "/posts", nil))
+	assert.Equal("HIT", response.Header.Get("X-LRU-Cache"), "Expected a revalidated entry to be fresh for another TTL")
+	assert.EqualValues(2, atomic.LoadInt32(&calls))
+}
+
+func TestStaleRequests(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls int32
+	var down int32
+	conf := config.New()
+	conf.Cache["GET"] = []string{"/posts"}
+	conf.CacheTTL = config.Duration(50 * time.Millisecond)
+	conf.CircuitBreaker = config.CircuitBreaker{FailureThreshold: 1, OpenTimeout: config.Duration(time.Minute)}
+	router, _ := newTestRouter(t, conf, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&down) == 1 {
+			panic(http.ErrAbortHandler) // close the connection, so the API cannot be reached
+		}
+		w.Write([]byte("posts"))
+	})
+
+	doRequest(t, router, httptest.NewRequest("GET", "/posts", nil))
+	time.Sleep(60 * time.Millisecond)
+	atomic.StoreInt32(&down, 1)
+
+	response, body := doRequest(t, router, httptest.NewRequest("GET", "/posts", nil))
+	assert.Equal(http.StatusOK, response.StatusCode)
+	assert.Equal("STALE", response.Header.Get("X-LRU-Cache"), "Expected an expired entry to be served when the API cannot be reached")
+	assert.Equal("posts", body)
+	assert.EqualValues(2, atomic.LoadInt32(&calls))
+
+	// The failed revalidation has opened the breaker, so the API is not tried again
+	response, body = doRequest(t, router, httptest.NewRequest("GET", "/posts", nil))
+	assert.Equal("STALE", response.Header.Get("X-LRU-Cache"), "Expected an expired entry to be served while the breaker is open")
+	assert.Equal("posts", body)
+	assert.EqualValues(2, atomic.LoadInt32(&calls), "Expected an expired entry not to be revalidated while the breaker is open")
+}
+
+// newGraphQLRequest returns a POST request to /graphql with the query as its JSON body.
+func newGraphQLRequest(query string) *http.Request {
+	body, _ := json.Marshal(map[string]string{"query": query})
+	request := httptest.NewRequest("POST", "/graphql", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+
+	return request
+}
+
+func TestGraphQLRequests(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls int32
+	conf := config.New()
+	conf.GraphQL = config.GraphQL{
+		Endpoints: []string{"/graphql"},
+		Types:     map[string][]string{"Posts": {"Post"}},
+		Bust:      map[string][]string{"AddPost": {"Post"}},
+	}
+	router, lru := newTestRouter(t, conf, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		body, _ := io.ReadAll(r.Body)
+		if bytes.Contains(body, []byte("Broken")) {
+			w.Write([]byte(`{"errors":[{"message":"broken"}]}`))
+			return
+		}
+		w.Write([]byte(`{"data":{}}`))
+	})
+
+	doRequest(t, router, newGraphQLRequest("query Posts { posts { id } }"))
+	doRequest(t, router, newGraphQLRequest("query Users { users { id } }"))
+	assert.Len(lru.CachedKeys(), 2)
+
+	response, _ := doRequest(t, router, newGraphQLRequest("# all posts\nquery Posts {\n  posts {\n    id\n  }\n}"))
+	assert.Equal("HIT", response.Header.Get("X-LRU-Cache"), "Expected a reformatted query to hit the entry of the same query")
+	assert.EqualValues(2, atomic.LoadInt32(&calls))
+
+	doRequest(t, router, newGraphQLRequest("query Broken { broken }"))
+	response, _ = doRequest(t, router, newGraphQLRequest("query Broken { broken }"))
+	assert.NotEqual("HIT", response.Header.Get("X-LRU-Cache"), "Expected a response with errors not to be cached")
+	assert.Len(lru.CachedKeys(), 2)
+
+	doRequest(t, router, newGraphQLRequest("mutation AddPost { addPost { id } }"))
+	if keys := lru.CachedKeys(); assert.Len(keys, 1, "Expected the mutation to bust the queries of the type it changes") {
+		assert.Contains(keys[0], "#query=Users;", "Expected queries of other types to be kept")
+	}
+}
@@ -0,0 +1,231 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/magnus-bb/cache-me-ousside/cache"
+	"github.com/magnus-bb/cache-me-ousside/internal/config"
+	"github.com/olekukonko/tablewriter"
+)
+
+// Explanation describes how the cache server would handle a request with the routes from a Config.
+type Explanation struct {
+	Method string
+	URL    string
+	// Upstream is the name of the upstream the request is proxied to, or an empty string for the ApiUrl.
+	Upstream string
+	// KeyPolicy describes the KeyPolicy that normalizes the URL of the request in its key, or is an empty string if no policy matches the request.
+	KeyPolicy string
+	// Key is the cache entry key of the request.
+	Key string
+	// CachedRoute is the cached route that handles the request, or an empty string if the request is not cached.
+	CachedRoute string
+	// GraphQL is true if CachedRoute is a GraphQL endpoint, where only queries are cached, under a key made from the body of the request.
+	GraphQL bool
+	// Busts are the busting routes that match the request, in the order they run.
+	Busts []BustExplanation
+}
+
+// BustExplanation describes a busting route that matches a request.
+type BustExplanation struct {
+	Route string
+	// Params are the route params of the request, which are inserted into the patterns.
+	Params   map[string]string
+	Patterns []PatternExplanation
+}
+
+// PatternExplanation describes a bust pattern after the route params of a request have been inserted.
+type PatternExplanation struct {
+	Pattern  string // as it is written in the Config
+	Hydrated string // with the route params and key segments inserted, which is the regular expression that cache entry keys are matched with
+	// CachedRoutes are the cached routes whose entries the hydrated pattern could match, e.g. "GET /posts/:id".
+	CachedRoutes []string
+	// Err is set if the hydrated pattern is not a valid regular expression.
+	Err error
+}
+
+// Explain shows how the cache server would handle a request with method to requestURL (e.g. "/posts/12?page=2") and the header,
+// which can be nil, with the routes built from conf. Headers and cookies are used in the keys and bust patterns of routes with a key template.
+// The request is routed by fiber exactly like on the cache server, but it is not proxied to the API and no LRUCache is used.
+func Explain(conf *config.Config, method, requestURL string, header http.Header) (*Explanation, error) {
+	explanation := &Explanation{
+		Method: method,
+		URL:    requestURL,
+	}
+
+	app := newFiberRoutesApp()
+
+	// Use the same functions as newRoutesApp to add the routes, so they match in the same order
+	setUpstreamRoutes(app, conf)
+	setKeyPolicies(app, conf)
+
+	// Record the upstream and the key, which are the same for all routes
+	app.Use(func(ctx *fiber.Ctx) error {
+		explanation.Upstream = upstreamName(ctx)
+		if policy, found := ctx.Locals("keyPolicy").(config.KeyPolicy); found {
+			explanation.KeyPolicy = policy.String()
+		}
+		explanation.Key = entryKey(ctx)
+		return ctx.Next()
+	})
+
+	setBustingEndpoints(app, conf, func(patterns []string) func(*fiber.Ctx) error {
+		return func(ctx *fiber.Ctx) error {
+			explanation.Busts = append(explanation.Busts, explainBust(conf, upstreamName(ctx), ctx.Route().Path, bustParams(ctx), keyRequest(ctx), patterns))
+			return ctx.Next()
+		}
+	})
+
+	setBodyKeyedEndpoints(app, conf, func(ctx *fiber.Ctx) error {
+		explanation.Key = cache.NamespacedKey(upstreamName(ctx), requestKey(ctx)+"#body=<hash of the body>")
+		return ctx.Next()
+	})
+
+	setCachingEndpoints(app, conf, func(ctx *fiber.Ctx) error {
+		explanation.CachedRoute = ctx.Route().Path
+		return nil // like a cached response, this ends the request
+	})
+
+	setGraphQLEndpoints(app, conf, func(ctx *fiber.Ctx) error {
+		explanation.CachedRoute = ctx.Route().Path
+		explanation.GraphQL = true
+		explanation.Key = cache.NamespacedKey(upstreamName(ctx), "POST:"+keyURL(ctx)+"#query=<operation name>;types=<types of the operation>;hash=<hash of the query and variables>")
+		return nil
+	})
+
+	// Like the proxy handler, this ends all requests that are not cached
+	app.Use("*", func(ctx *fiber.Ctx) error {
+		return nil
+	})
+
+	request, err := newExplainRequest(method, requestURL)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		for _, value := range values {
+			request.Header.Add(name, value)
+		}
+	}
+
+	if _, err := app.Test(request, -1); err != nil {
+		return nil, fmt.Errorf("could not route the request: %w", err)
+	}
+
+	return explanation, nil
+}
+
+// newExplainRequest returns a request with method to requestURL, which is a path with an optional query string, or a full URL.
+func newExplainRequest(method, requestURL string) (*http.Request, error) {
+	validMethod := false
+	for _, httpMethod := range config.AllHTTPMethods {
+		validMethod = validMethod || method == httpMethod
+	}
+	if !validMethod {
+		return nil, fmt.Errorf("%q is not a valid HTTP method, use one of: %s", method, strings.Join(config.AllHTTPMethods, ", "))
+	}
+
+	parsedURL, err := url.Parse(requestURL)
+	if err != nil || !strings.HasPrefix(parsedURL.Path, "/") {
+		return nil, fmt.Errorf("%q is not a valid URL, use a path like /posts/12, optionally with a query string", requestURL)
+	}
+
+	// Only the path and the query string are used by the routes, like when the cache server receives a request
+	return httptest.NewRequest(method, parsedURL.RequestURI(), nil), nil
+}
+
+// explainBust explains what the busting route with the patterns does with the params and key segments of a request to the upstream.
+func explainBust(conf *config.Config, upstream, route string, params map[string]string, request config.KeyRequest, patterns []string) BustExplanation {
+	bust := BustExplanation{
+		Route:  route,
+		Params: params,
+	}
+
+	for i, hydrated := range cache.HydrateParams(params, config.HydrateKeySegments(request, patterns)) {
+		cachedRoutes, err := conf.CachedRoutesMatching(upstream, hydrated, params, request)
+
+		bust.Patterns = append(bust.Patterns, PatternExplanation{
+			Pattern:      patterns[i],
+			Hydrated:     hydrated,
+			CachedRoutes: cachedRoutes,
+			Err:          err,
+		})
+	}
+
+	return bust
+}
+
+// Cached returns true if the request is cached.
+func (explanation Explanation) Cached() bool {
+	return explanation.CachedRoute != ""
+}
+
+// String returns a human-readable explanation with a table of the bust patterns that run on the request.
+func (explanation Explanation) String() string {
+	output := new(strings.Builder)
+
+	fmt.Fprintf(output, "\nRequest:   %s %s\n", explanation.Method, explanation.URL)
+	if explanation.Upstream != "" {
+		fmt.Fprintf(output, "Upstream:  %s\n", explanation.Upstream)
+	} else {
+		fmt.Fprintf(output, "Upstream:  (default)\n")
+	}
+	if explanation.KeyPolicy != "" {
+		fmt.Fprintf(output, "Policy:    %s\n", explanation.KeyPolicy)
+	}
+	fmt.Fprintf(output, "Cache key: %s\n", explanation.Key)
+
+	if explanation.GraphQL {
+		fmt.Fprintf(output, "Cached:    queries only, by the GraphQL endpoint %s\n", explanation.CachedRoute)
+	} else if explanation.Cached() {
+		fmt.Fprintf(output, "Cached:    yes, by the cached route %s\n", explanation.CachedRoute)
+	} else {
+		fmt.Fprintf(output, "Cached:    no, the request is proxied to the API without being cached\n")
+	}
+
+	if len(explanation.Busts) == 0 {
+		output.WriteString("Busts:     no busting routes match the request\n")
+		return output.String()
+	}
+
+	output.WriteString("\nBusting Routes\n")
+	bustRows := [][]string{}
+	for _, bust := range explanation.Busts {
+		params := make([]string, 0, len(bust.Params))
+		for name, value := range bust.Params {
+			params = append(params, name+"="+value)
+		}
+		sort.Strings(params)
+		paramsString := strings.Join(params, "\n")
+
+		if len(bust.Patterns) == 0 {
+			bustRows = append(bustRows, []string{bust.Route, paramsString, "(none)", "(none)", "all cached routes"})
+		}
+
+		for _, pattern := range bust.Patterns {
+			matches := strings.Join(pattern.CachedRoutes, "\n")
+			if pattern.Err != nil {
+				matches = "invalid regular expression: " + pattern.Err.Error()
+			} else if matches == "" {
+				matches = "(none)"
+			}
+
+			bustRows = append(bustRows, []string{bust.Route, paramsString, pattern.Pattern, pattern.Hydrated, matches})
+		}
+	}
+	bustTable := tablewriter.NewWriter(output)
+	bustTable.SetHeader([]string{"Route", "Params", "Pattern", "Hydrated pattern", "Matching cached routes"})
+	bustTable.SetAutoMergeCellsByColumnIndex([]int{0, 1})
+	bustTable.SetAutoWrapText(false)
+	bustTable.SetRowLine(true)
+	bustTable.AppendBulk(bustRows)
+	bustTable.Render()
+
+	return output.String()
+}
@@ -0,0 +1,31 @@
+package router
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/magnus-bb/cache-me-ousside/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplainKeyTemplate(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.New()
+	conf.ApiUrl = "https://a.example.com"
+	conf.Cache["GET"] = []string{"/posts"}
+	conf.KeyPolicies = map[string]config.KeyPolicy{"/posts": {Template: "{method}:{path}|{header:X-Tenant}|{cookie:lang}"}}
+	conf.Bust["POST"]["/posts"] = []string{`^GET:/posts\|{header:X-Tenant}\|`}
+
+	header := http.Header{"X-Tenant": {"a"}, "Cookie": {"lang=da"}}
+	explanation, err := Explain(conf, "POST", "/posts", header)
+	if !assert.NoError(err) || !assert.Len(explanation.Busts, 1) || !assert.Len(explanation.Busts[0].Patterns, 1) {
+		return
+	}
+
+	assert.Equal("POST:/posts|a|da", explanation.Key, "Expected the headers and cookies of the request to be used in its key")
+
+	pattern := explanation.Busts[0].Patterns[0]
+	assert.Equal(`^GET:/posts\|a\|`, pattern.Hydrated)
+	assert.Equal([]string{"GET /posts"}, pattern.CachedRoutes, "Expected the example keys of templated routes to be made with the template")
+}
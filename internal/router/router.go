@@ -1,57 +1,315 @@
 package router
 
 import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/magnus-bb/cache-me-ousside/cache"
 	"github.com/magnus-bb/cache-me-ousside/internal/config"
+	"github.com/magnus-bb/cache-me-ousside/internal/idempotency"
+	"github.com/magnus-bb/cache-me-ousside/internal/upstream"
+	"github.com/valyala/fasthttp"
 )
 
-// New creates a fiber.App and injects the LRUCache into the application's context.
-// The router is set up to proxy all requests to the ApiUrl from the Config.
-// Routes are created for all caching and busting endpoints from Config.
-func New(conf *config.Config, cache *cache.LRUCache) *fiber.App {
-	app := fiber.New(fiber.Config{
-		DisableStartupMessage: true, // has own HiMom message
-		Immutable:             true, // muy importante - makes sure that OriginalUrl() cannot mutate cached endpoints somehow
+// idempotentHTTPMethods are the methods whose requests are replayed to retries with the same Idempotency-Key.
+var idempotentHTTPMethods = []string{fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch}
+
+// Router is the cache server. It hands every request to the routes built from the current Config,
+// which can be swapped with Reload without restarting the server or clearing the LRUCache.
+type Router struct {
+	// app is the fiber.App that listens for requests and passes them on to the current routes.
+	app *fiber.App
+	// routes holds the *routes built from the current Config.
+	routes atomic.Value
+	// cache is shared by all routes, so it stays the same when the routes are reloaded.
+	cache *cache.LRUCache
+	// replays holds the responses of requests with an Idempotency-Key, and it is kept when the routes are reloaded like the cache.
+	replays *idempotency.Store
+}
+
+// routes is a fiber.App with all of the caching, busting, and proxy routes from a Config,
+// along with its request handler, so the handler does not have to be prepared for every request,
+// and the upstream Pools that its requests are proxied to.
+type routes struct {
+	app     *fiber.App
+	handler fasthttp.RequestHandler
+	pools   upstream.Pools
+}
+
+// New creates a Router that proxies all requests to the ApiUrl from the Config
+// and caches and busts entries in the LRUCache on the routes from the Config.
+// An error is returned if the upstream Pools cannot be built, e.g. because a client certificate cannot be loaded.
+func New(conf *config.Config, cache *cache.LRUCache) (*Router, error) {
+	router := &Router{
+		app: fiber.New(fiber.Config{
+			DisableStartupMessage: true, // has own HiMom message
+		}),
+		cache:   cache,
+		replays: idempotency.NewStore(),
+	}
+
+	// Write a line to the access log for every request, if an access log is configured
+	if conf.AccessLogPath != "" {
+		router.app.Use(accessLogMiddleware)
+	}
+
+	// Every request is handled by the routes that are current when it arrives
+	router.app.Use(func(ctx *fiber.Ctx) error {
+		router.routes.Load().(*routes).handler(ctx.Context())
+		return nil
 	})
 
+	if err := router.Reload(conf); err != nil {
+		return nil, err
+	}
+
+	return router, nil
+}
+
+// Reload builds new routes from conf and swaps them with the current routes.
+// Requests that are already being handled will finish with the old routes, and the LRUCache is kept as is.
+// The upstream Pools are built again as well, and the health checks of the old Pools are stopped.
+// If the new Pools cannot be built, an error is returned and the current routes are kept.
+func (router *Router) Reload(conf *config.Config) error {
+	pools, err := upstream.NewPools(conf)
+	if err != nil {
+		return err
+	}
+	app := newRoutesApp(conf, router.cache, router.replays, pools)
+
+	pools.Start()
+
+	oldRoutes, _ := router.routes.Load().(*routes)
+
+	router.routes.Store(&routes{
+		app:     app,
+		handler: app.Handler(),
+		pools:   pools,
+	})
+
+	if oldRoutes != nil {
+		oldRoutes.pools.Stop()
+	}
+
+	return nil
+}
+
+// Listen serves requests on addr until the server is shut down.
+func (router *Router) Listen(addr string) error {
+	return router.app.Listen(addr)
+}
+
+// ListenTLS serves requests over HTTPS on addr with tlsConfig until the server is shut down.
+func (router *Router) ListenTLS(addr string, tlsConfig *tls.Config) error {
+	ln, err := net.Listen(router.app.Config().Network, addr)
+	if err != nil {
+		return err
+	}
+
+	return router.app.Listener(tls.NewListener(ln, tlsConfig))
+}
+
+// Shutdown stops accepting new connections and waits for in-flight requests to finish for up to timeout.
+// A timeout of 0 waits for as long as it takes. An error is returned if requests were still in flight when the timeout was reached.
+func (router *Router) Shutdown(timeout time.Duration) error {
+	router.routes.Load().(*routes).pools.Stop()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- router.app.Shutdown()
+	}()
+
+	if timeout <= 0 {
+		return <-done
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("requests were still in flight when the shutdown timeout of %s was reached", timeout)
+	}
+}
+
+// newRoutesApp creates a fiber.App and injects the LRUCache into the application's context.
+// The app is set up to proxy all requests to the servers of their upstream in pools.
+// Routes are created for all caching and busting endpoints from Config, and responses to requests with an Idempotency-Key
+// are stored in replays if idempotency keys are enabled.
+func newRoutesApp(conf *config.Config, cache *cache.LRUCache, replays *idempotency.Store, pools upstream.Pools) *fiber.App {
+	app := newFiberRoutesApp()
+
+	// Answer requests to the stats path before they can be cached or proxied
+	if conf.StatsPath != "" {
+		app.Get(conf.StatsPath, createStatsHandler(pools))
+	}
+
 	// Make cache available in all handlers with ctx.Locals("cache").(*cache.LRUCache)
 	app.Use(injectCtxCache(cache))
 
+	// Choose the upstream of every request before it is busted, cached, or proxied, so they all use the same upstream
+	setUpstreamRoutes(app, conf)
+
+	// Normalize the URLs of requests to routes with a key policy, before they are used in cache keys and bust patterns
+	setKeyPolicies(app, conf)
+
+	// Replay stored responses before anything is busted, since a replayed request must not change anything again
+	if conf.Idempotency.Enabled() {
+		for _, method := range idempotentHTTPMethods {
+			app.Add(method, "*", createIdempotencyMiddleware(replays, conf.Idempotency.WithDefaults()))
+		}
+	}
+
 	// Will loop through methods, endpoints, and patterns and set a middleware for each that removes cache entries when patterns are matched
-	setBustingEndpoints(app, conf)
+	setBustingEndpoints(app, conf, func(patterns []string) func(*fiber.Ctx) error {
+		return createBustMiddleware(pools, patterns)
+	})
+
+	// Cached POST requests are keyed by their body as well as their route, so they need a key before the cache is read
+	setBodyKeyedEndpoints(app, conf, createBodyKeyMiddleware(pools, conf.CacheBodyLimitBytes()))
 
 	// Will loop through cachable endpoints in config and set route handlers + middleware to handle caching on those routes
-	setCachingEndpoints(app, conf)
+	setCachingEndpoints(app, conf,
+		createReadCacheMiddleware(pools, time.Duration(conf.CacheTTL)),
+		createProxyMiddleware(pools),
+		createWriteCacheMiddleware(time.Duration(conf.CacheTTL)),
+	)
+
+	// Queries to GraphQL endpoints are cached by their body, since they are all POST requests to the same URL
+	setGraphQLEndpoints(app, conf,
+		createGraphQLMiddleware(pools, conf.GraphQL),
+		createReadCacheMiddleware(pools, time.Duration(conf.CacheTTL)),
+		createProxyMiddleware(pools),
+		createWriteCacheMiddleware(time.Duration(conf.CacheTTL)),
+	)
 
 	// Any non-cache / non-cache-busting requests should just proxy directly to the original API
-	app.Use("*", createProxyHandler(conf.ApiUrl)) // default behavior
+	app.Use("*", createProxyHandler(pools)) // default behavior
 
 	return app
 }
 
-// setBustingEndpoints loops through methods, endpoints, and patterns and sets a middleware that removes cache entries when patterns are matched.
+// newFiberRoutesApp returns an empty fiber.App with the settings that all routes built from a Config are served with.
+func newFiberRoutesApp() *fiber.App {
+	return fiber.New(fiber.Config{
+		DisableStartupMessage: true, // has own HiMom message
+		Immutable:             true, // muy importante - makes sure that OriginalUrl() cannot mutate cached endpoints somehow
+	})
+}
+
+// setUpstreamRoutes sets a middleware on the routes of every upstream, that makes the upstream the one requests to the route are proxied to.
+// If a request matches the routes of several upstreams, the first upstream in alphabetical order is used,
+// and requests that do not match the routes of any upstream are proxied to the ApiUrl.
+// The upstream of a request can be read with upstreamName.
+func setUpstreamRoutes(app *fiber.App, conf *config.Config) {
+	for _, name := range conf.UpstreamNames() {
+		name := name // used in the closure
+
+		for _, route := range conf.Upstreams[name].Routes {
+			app.All(route, func(ctx *fiber.Ctx) error {
+				if upstreamName(ctx) == "" {
+					ctx.Locals("upstream", name)
+				}
+
+				return ctx.Next()
+			})
+		}
+	}
+}
+
+// setKeyPolicies sets a middleware on the route of every KeyPolicy, for all methods, that makes the policy the one requests to the route are keyed with.
+// If a request matches the routes of several policies, the first route in alphabetical order is used.
+// The policy of a request can be read with keyPolicy, the normalized URL with keyURL, and the key made with its template with requestKey.
+func setKeyPolicies(app *fiber.App, conf *config.Config) {
+	for _, route := range conf.KeyPolicyRoutes() {
+		policy := conf.KeyPolicies[route] // used in the closure
+
+		app.All(route, func(ctx *fiber.Ctx) error {
+			if _, found := ctx.Locals("keyPolicy").(config.KeyPolicy); !found {
+				ctx.Locals("keyPolicy", policy)
+			}
+
+			return ctx.Next()
+		})
+	}
+}
+
+// keyPolicy returns the KeyPolicy of the request, which is the zero KeyPolicy that changes nothing if no policy matches the request.
+func keyPolicy(ctx *fiber.Ctx) config.KeyPolicy {
+	policy, _ := ctx.Locals("keyPolicy").(config.KeyPolicy)
+	return policy
+}
+
+// keyURL returns the URL of the request as it is used in cache keys, which is normalized by the KeyPolicy of the request.
+func keyURL(ctx *fiber.Ctx) string {
+	return keyPolicy(ctx).Normalize(ctx.OriginalURL())
+}
+
+// keyRequest returns the parts of the request that cache keys are made from.
+func keyRequest(ctx *fiber.Ctx) config.KeyRequest {
+	return config.KeyRequest{
+		Method: ctx.Method(),
+		Host:   ctx.Hostname(),
+		URL:    ctx.OriginalURL(),
+		Header: requestHeader(ctx),
+		Cookie: func(name string) string { return ctx.Cookies(name) },
+	}
+}
+
+// requestKey returns the cache key of the request without the namespace of its upstream, made with the KeyPolicy of the request.
+func requestKey(ctx *fiber.Ctx) string {
+	return keyPolicy(ctx).Key(keyRequest(ctx))
+}
+
+// bustParams returns the route params of a busting request, which are inserted into its bust patterns.
+// They are lowercased like the paths of the keys they should match, if the KeyPolicy of the request lowercases paths.
+func bustParams(ctx *fiber.Ctx) map[string]string {
+	params := ctx.AllParams()
+	if keyPolicy(ctx).LowercasePath {
+		for name, value := range params {
+			params[name] = strings.ToLower(value)
+		}
+	}
+
+	return params
+}
+
+// upstreamName returns the name of the upstream that the request is proxied to, or an empty string for the ApiUrl.
+func upstreamName(ctx *fiber.Ctx) string {
+	name, _ := ctx.Locals("upstream").(string)
+	return name
+}
+
+// setBustingEndpoints loops through methods, endpoints, and patterns and sets a middleware created by createMiddleware
+// that removes cache entries when patterns are matched.
 // E.g. POST to /users could remove all cache entries that match the pattern /users or /users/:id.
-func setBustingEndpoints(app *fiber.App, conf *config.Config) {
-	for method, endpointMap := range conf.Bust {
-		for endpoint, patterns := range endpointMap {
-			app.Add(method, endpoint, createBustMiddleware(patterns))
+// Endpoints are added in a fixed order, so requests are always busted the same way.
+func setBustingEndpoints(app *fiber.App, conf *config.Config, createMiddleware func(patterns []string) func(*fiber.Ctx) error) {
+	for _, method := range config.AllHTTPMethods {
+		endpointMap := conf.Bust[method]
+
+		endpoints := make([]string, 0, len(endpointMap))
+		for endpoint := range endpointMap {
+			endpoints = append(endpoints, endpoint)
+		}
+		sort.Strings(endpoints)
+
+		for _, endpoint := range endpoints {
+			app.Add(method, endpoint, createMiddleware(endpointMap[endpoint]))
 		}
 	}
 }
 
-// setCachingEndpoints sets route handlers and middleware that:
+// setCachingEndpoints sets the middlewares on all cached endpoints. On the cache server, they:
 // 1) reads data from cache if anything is cached, if not, then
 // 2) proxies the incoming request to Conf.ApiUrl and gets a response, then
 // 3) saves the response in the cache to be read the next time.
-func setCachingEndpoints(app *fiber.App, conf *config.Config) {
-	// These are the middlewares needed for caching
-	middlewares := []func(*fiber.Ctx) error{
-		readCacheMiddleware,
-		createProxyMiddleware(conf.ApiUrl),
-		writeCacheMiddleware,
-	}
-
+func setCachingEndpoints(app *fiber.App, conf *config.Config, middlewares ...func(*fiber.Ctx) error) {
 	// For all cacheable methods, set middlewares on each defined endpoint to cache
 	for _, method := range config.CacheableHTTPMethods {
 		for _, endpoint := range conf.Cache[method] {
@@ -60,11 +318,29 @@ func setCachingEndpoints(app *fiber.App, conf *config.Config) {
 	}
 }
 
+// setBodyKeyedEndpoints sets the middleware on the cached endpoints of the BodyKeyedHTTPMethods, before the caching middlewares are set on them.
+// On the cache server, it gives requests a cache key with a hash of their body.
+func setBodyKeyedEndpoints(app *fiber.App, conf *config.Config, middleware func(*fiber.Ctx) error) {
+	for _, method := range config.BodyKeyedHTTPMethods {
+		for _, endpoint := range conf.Cache[method] {
+			app.Add(method, endpoint, middleware)
+		}
+	}
+}
+
+// setGraphQLEndpoints sets the middlewares on the POST routes of all GraphQL endpoints.
+// On the cache server, the first middleware gives queries a cache key made from their body,
+// and the rest read, proxy, and write the entry like on the cached endpoints.
+func setGraphQLEndpoints(app *fiber.App, conf *config.Config, middlewares ...func(*fiber.Ctx) error) {
+	for _, endpoint := range conf.GraphQL.Endpoints {
+		app.Post(endpoint, middlewares...)
+	}
+}
+
 // injectCtxCache injects the LRUCache into the fiber.Ctx so the cache is available in every route handler.
 func injectCtxCache(cache *cache.LRUCache) func(ctx *fiber.Ctx) error {
 	return func(ctx *fiber.Ctx) error {
 		ctx.Locals("cache", cache)
-		ctx.Next()
-		return nil
+		return ctx.Next()
 	}
 }
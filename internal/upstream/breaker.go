@@ -0,0 +1,147 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/magnus-bb/cache-me-ousside/internal/config"
+	"github.com/magnus-bb/cache-me-ousside/internal/logger"
+)
+
+// States of a Breaker.
+const (
+	BreakerClosed   = "closed"    // requests are proxied to the API
+	BreakerOpen     = "open"      // requests fail fast without being proxied
+	BreakerHalfOpen = "half-open" // a limited number of requests can try the API
+)
+
+// ErrCircuitOpen is returned by Allow when the Breaker does not let a request through to the API.
+var ErrCircuitOpen = errors.New("the circuit breaker is open")
+
+// Breaker is the circuit breaker of a Pool. It counts the requests that fail in a row and opens when there are too many,
+// so requests fail fast instead of waiting for an API that is down.
+type Breaker struct {
+	pool     *Pool // only used to describe the upstream in log messages
+	settings config.CircuitBreaker
+
+	mu       sync.Mutex
+	state    string
+	failures uint      // the requests in a row that have failed while the breaker is closed
+	openedAt time.Time // when the breaker was last opened
+	trials   uint      // the requests let through while the breaker is half-open
+	passed   uint      // the requests that succeeded while the breaker is half-open
+
+	// now returns the current time, which can be replaced in tests.
+	now func() time.Time
+}
+
+// newBreaker returns a closed Breaker with the settings (and their defaults) for the Pool.
+func newBreaker(pool *Pool, settings config.CircuitBreaker) *Breaker {
+	return &Breaker{
+		pool:     pool,
+		settings: settings.WithDefaults(),
+		state:    BreakerClosed,
+		now:      time.Now,
+	}
+}
+
+// Allow returns ErrCircuitOpen if a request should fail fast instead of being proxied to the API.
+// Every request that is allowed must be recorded with Record when the API has responded.
+func (breaker *Breaker) Allow() error {
+	if !breaker.settings.Enabled() {
+		return nil
+	}
+
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	if breaker.state == BreakerOpen && breaker.now().Sub(breaker.openedAt) >= breaker.settings.OpenTimeout.Std() {
+		breaker.setState(BreakerHalfOpen)
+	}
+
+	switch breaker.state {
+	case BreakerOpen:
+		return ErrCircuitOpen
+
+	case BreakerHalfOpen:
+		if breaker.trials >= breaker.settings.HalfOpenRequests {
+			return ErrCircuitOpen
+		}
+		breaker.trials++
+	}
+
+	return nil
+}
+
+// Record counts the outcome of a request that was allowed. failed is true if the request could not be proxied or the API responded with a 5xx status.
+func (breaker *Breaker) Record(failed bool) {
+	if !breaker.settings.Enabled() {
+		return
+	}
+
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	switch breaker.state {
+	case BreakerClosed:
+		if !failed {
+			breaker.failures = 0
+			return
+		}
+
+		breaker.failures++
+		if breaker.failures >= breaker.settings.FailureThreshold {
+			breaker.setState(BreakerOpen)
+		}
+
+	case BreakerHalfOpen:
+		if failed {
+			breaker.setState(BreakerOpen)
+			return
+		}
+
+		breaker.passed++
+		if breaker.passed >= breaker.settings.HalfOpenRequests {
+			breaker.setState(BreakerClosed)
+		}
+	}
+
+	// Requests that finish while the breaker is open were allowed before it opened, so they are not counted
+}
+
+// State returns the current state of the Breaker, which is always closed if the breaker is disabled.
+// An open breaker is reported as half-open when its OpenTimeout has passed, even if no request has tried the API yet.
+func (breaker *Breaker) State() string {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	if breaker.state == BreakerOpen && breaker.now().Sub(breaker.openedAt) >= breaker.settings.OpenTimeout.Std() {
+		return BreakerHalfOpen
+	}
+
+	return breaker.state
+}
+
+// ServesStale returns true if busting routes should leave the entries of the upstream in the cache, because the breaker is open
+// and configured to serve stale responses.
+func (breaker *Breaker) ServesStale() bool {
+	return breaker.settings.Enabled() && breaker.settings.WhileOpen == "stale" && breaker.State() == BreakerOpen
+}
+
+// setState changes the state of the Breaker, resets its counters, and logs the change. breaker.mu must be held.
+func (breaker *Breaker) setState(state string) {
+	breaker.state = state
+	breaker.failures, breaker.trials, breaker.passed = 0, 0, 0
+
+	switch state {
+	case BreakerOpen:
+		breaker.openedAt = breaker.now()
+		logger.Warn(fmt.Sprintf("the circuit breaker of %s is open, so its requests fail fast for %s", breaker.pool, breaker.settings.OpenTimeout))
+	case BreakerHalfOpen:
+		logger.Info(fmt.Sprintf("the circuit breaker of %s is half-open, so %d request(s) can try the API again", breaker.pool, breaker.settings.HalfOpenRequests))
+	case BreakerClosed:
+		logger.Info(fmt.Sprintf("the circuit breaker of %s is closed, so its requests are proxied again", breaker.pool))
+	}
+}
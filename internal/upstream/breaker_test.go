@@ -0,0 +1,78 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/magnus-bb/cache-me-ousside/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestBreaker returns the Breaker of a Pool with the settings and a clock that only moves with the returned function.
+func newTestBreaker(t *testing.T, settings config.CircuitBreaker) (*Breaker, func(time.Duration)) {
+	breaker := newTestPool(t, "users", config.Upstream{Url: "http://a.example.com", CircuitBreaker: settings}).Breaker
+
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+
+	return breaker, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestBreakerOpensAndCloses(t *testing.T) {
+	assert := assert.New(t)
+
+	breaker, advance := newTestBreaker(t, config.CircuitBreaker{FailureThreshold: 2, OpenTimeout: config.Duration(time.Minute), HalfOpenRequests: 2})
+
+	assert.NoError(breaker.Allow())
+	breaker.Record(true)
+	assert.NoError(breaker.Allow())
+	breaker.Record(false)
+	assert.NoError(breaker.Allow())
+	breaker.Record(true)
+	assert.Equal(BreakerClosed, breaker.State(), "Expected a success to reset the failures in a row")
+
+	assert.NoError(breaker.Allow())
+	breaker.Record(true)
+	assert.Equal(BreakerOpen, breaker.State(), "Expected the breaker to open after FailureThreshold failures in a row")
+	assert.ErrorIs(breaker.Allow(), ErrCircuitOpen, "Expected requests to fail fast while the breaker is open")
+
+	advance(time.Minute)
+	assert.Equal(BreakerHalfOpen, breaker.State(), "Expected the breaker to half-open after the OpenTimeout")
+	assert.NoError(breaker.Allow())
+	assert.NoError(breaker.Allow())
+	assert.ErrorIs(breaker.Allow(), ErrCircuitOpen, "Expected only HalfOpenRequests requests to try the API while the breaker is half-open")
+
+	breaker.Record(false)
+	assert.Equal(BreakerHalfOpen, breaker.State(), "Expected the breaker to stay half-open until all trial requests have succeeded")
+	breaker.Record(false)
+	assert.Equal(BreakerClosed, breaker.State(), "Expected the breaker to close when all trial requests have succeeded")
+}
+
+func TestBreakerReopens(t *testing.T) {
+	assert := assert.New(t)
+
+	breaker, advance := newTestBreaker(t, config.CircuitBreaker{FailureThreshold: 1, WhileOpen: "stale"})
+
+	breaker.Allow()
+	breaker.Record(true)
+	assert.True(breaker.ServesStale(), "Expected an open breaker set to stale to serve stale entries")
+
+	advance(config.DefaultBreakerOpenTimeout.Std())
+	assert.False(breaker.ServesStale(), "Expected a half-open breaker not to serve stale entries")
+	assert.NoError(breaker.Allow())
+	breaker.Record(true)
+	assert.Equal(BreakerOpen, breaker.State(), "Expected a failed trial request to open the breaker again")
+	assert.ErrorIs(breaker.Allow(), ErrCircuitOpen)
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	breaker, _ := newTestBreaker(t, config.CircuitBreaker{})
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, breaker.Allow())
+		breaker.Record(true)
+	}
+
+	assert.Equal(t, BreakerClosed, breaker.State(), "Expected a breaker without a FailureThreshold to never open")
+	assert.False(t, breaker.ServesStale())
+}
@@ -0,0 +1,129 @@
+package upstream
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/magnus-bb/cache-me-ousside/internal/logger"
+	"github.com/valyala/fasthttp"
+)
+
+// checkResult is the outcome of a health check of a replica.
+type checkResult struct {
+	time time.Time
+	err  error // nil if the check succeeded
+}
+
+// Start runs the health checks of every replica in the background until Stop is called.
+// It does nothing if health checks are disabled for the upstream.
+func (pool *Pool) Start() {
+	if !pool.check.Enabled() {
+		return
+	}
+
+	for _, replica := range pool.replicas {
+		go pool.runChecks(replica)
+	}
+}
+
+// Stop stops the health checks and closes the idle connections to the replicas. It is safe to call more than once.
+func (pool *Pool) Stop() {
+	pool.stopOnce.Do(func() {
+		close(pool.stop)
+	})
+	pool.client.CloseIdleConnections()
+}
+
+// String describes the upstream of the Pool in log messages, e.g. "the upstream users".
+func (pool *Pool) String() string {
+	if pool.Name == "" {
+		return "the default upstream"
+	}
+
+	return "the upstream " + pool.Name
+}
+
+// runChecks checks the replica right away and then every Interval until the Pool is stopped.
+func (pool *Pool) runChecks(replica *Replica) {
+	ticker := time.NewTicker(pool.check.Interval.Std())
+	defer ticker.Stop()
+
+	for {
+		pool.record(replica, pool.checkReplica(replica))
+
+		select {
+		case <-pool.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkReplica requests the health check path of the replica. The check fails if the replica does not respond
+// within the Timeout or responds with a status code outside of 200-399.
+func (pool *Pool) checkReplica(replica *Replica) error {
+	request := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(request)
+	response := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(response)
+
+	request.SetRequestURI(replica.URL + pool.check.Path)
+	request.Header.SetMethod(fasthttp.MethodGet)
+
+	// Use the client of the Pool, so checks connect with the same TLS settings as proxied requests
+	if err := pool.client.DoTimeout(request, response, pool.check.Timeout.Std()); err != nil {
+		return err
+	}
+
+	if status := response.StatusCode(); status < 200 || status >= 400 {
+		return fmt.Errorf("responded with status %d", status)
+	}
+
+	return nil
+}
+
+// record counts the outcome of a health check of the replica and takes it out of rotation or puts it back
+// when it has failed or succeeded enough checks in a row. Both changes are logged.
+func (pool *Pool) record(replica *Replica, err error) {
+	replica.mu.Lock()
+	defer replica.mu.Unlock()
+
+	replica.lastCheck = &checkResult{time.Now(), err}
+
+	if err != nil {
+		replica.consecutiveSuccesses = 0
+		replica.consecutiveFailures++
+
+		if replica.Healthy() && replica.consecutiveFailures >= pool.check.UnhealthyThreshold {
+			atomic.StoreInt32(&replica.healthy, 0)
+			logger.Warn(fmt.Sprintf("%s of %s failed %d health checks in a row and is taken out of rotation: %v", replica.URL, pool, replica.consecutiveFailures, err))
+
+			if pool.healthyCount() == 0 {
+				logger.Error(fmt.Errorf("all servers of %s are unhealthy, so its requests fail until one of them recovers", pool))
+			}
+		}
+
+		return
+	}
+
+	replica.consecutiveFailures = 0
+	replica.consecutiveSuccesses++
+
+	if !replica.Healthy() && replica.consecutiveSuccesses >= pool.check.HealthyThreshold {
+		atomic.StoreInt32(&replica.healthy, 1)
+		logger.Info(fmt.Sprintf("%s of %s passed %d health checks in a row and is put back into rotation", replica.URL, pool, replica.consecutiveSuccesses))
+	}
+}
+
+// healthyCount returns the number of replicas that are in rotation.
+func (pool *Pool) healthyCount() int {
+	count := 0
+	for _, replica := range pool.replicas {
+		if replica.Healthy() {
+			count++
+		}
+	}
+
+	return count
+}
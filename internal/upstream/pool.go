@@ -0,0 +1,250 @@
+// Package upstream balances requests across the servers of an API, checks which of them are healthy,
+// and stops requests from being proxied to an API that keeps failing.
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/magnus-bb/cache-me-ousside/internal/certs"
+	"github.com/magnus-bb/cache-me-ousside/internal/config"
+	"github.com/magnus-bb/cache-me-ousside/internal/logger"
+	"github.com/valyala/fasthttp"
+)
+
+// Balancing strategies, which are the valid values of the Balance of an upstream.
+const (
+	RoundRobin       = "round-robin"       // every replica gets a request in turn
+	LeastConnections = "least-connections" // the replica with the fewest requests in flight gets the request
+	ConsistentHash   = "consistent-hash"   // requests with the same path always go to the same replica while it is healthy
+)
+
+// ringReplicaPoints is how many points every replica gets on the hash ring of a consistent-hash Pool.
+// More points spread the paths more evenly across the replicas.
+const ringReplicaPoints = 100
+
+// ErrNoHealthyReplicas is returned by Pick when all replicas of a Pool have been taken out of rotation by the health checks.
+var ErrNoHealthyReplicas = errors.New("no healthy replicas")
+
+// Pool is the servers (replicas) of an upstream, which requests are balanced across with the balancing strategy of the upstream.
+// Replicas that fail their health checks are not picked until they recover.
+type Pool struct {
+	// Name is the name of the upstream, or an empty string for the ApiUrl.
+	Name string
+	// Breaker decides if requests to the upstream are proxied or fail fast.
+	Breaker *Breaker
+
+	balance  string
+	replicas []*Replica
+	check    config.HealthCheck
+	proxy    config.ProxySettings
+	client   *fasthttp.Client
+
+	// next counts the requests, so round-robin (and least-connections, on ties) can take turns.
+	next uint64
+	// ring holds the points of the replicas on the hash ring, sorted by hash. It is only used for consistent-hash.
+	ring []ringPoint
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Replica is one of the servers of a Pool.
+type Replica struct {
+	URL string
+
+	// active is the number of requests in flight to the replica.
+	active int64
+	// healthy is 1 while the replica is in rotation and 0 when it has been taken out by the health checks.
+	healthy int32
+
+	// mu guards the state of the health checks.
+	mu                   sync.Mutex
+	consecutiveSuccesses uint
+	consecutiveFailures  uint
+	lastCheck            *checkResult
+}
+
+// ringPoint is a point on the hash ring that belongs to the replica at index replica.
+type ringPoint struct {
+	hash    uint32
+	replica int
+}
+
+// NewPool returns a Pool with the Url and Replicas of upstream, which all start out healthy, a closed Breaker,
+// and a client with the Proxy and TLS settings of upstream. An error is returned if the TLS files cannot be loaded.
+// Health checks do not run until Start is called.
+func NewPool(name string, upstream config.Upstream) (*Pool, error) {
+	pool := &Pool{
+		Name:    name,
+		balance: upstream.BalanceOrDefault(),
+		check:   upstream.HealthCheck.WithDefaults(),
+		proxy:   upstream.Proxy.WithDefaults(),
+		stop:    make(chan struct{}),
+	}
+	pool.Breaker = newBreaker(pool, upstream.CircuitBreaker)
+
+	tlsConfig, err := certs.ClientConfig(upstream.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("could not set up TLS for %s: %w", pool, err)
+	}
+	if upstream.TLS.InsecureSkipVerify {
+		logger.Warn(fmt.Sprintf("the certificates of %s are not verified, which should only be done during development", pool))
+	}
+	pool.client = newClient(pool.proxy, tlsConfig)
+
+	for _, url := range upstream.URLs() {
+		pool.replicas = append(pool.replicas, &Replica{URL: url, healthy: 1})
+	}
+
+	if pool.balance == ConsistentHash {
+		for i, replica := range pool.replicas {
+			for point := 0; point < ringReplicaPoints; point++ {
+				pool.ring = append(pool.ring, ringPoint{crc32.ChecksumIEEE([]byte(replica.URL + "#" + strconv.Itoa(point))), i})
+			}
+		}
+		sort.Slice(pool.ring, func(i, j int) bool {
+			return pool.ring[i].hash < pool.ring[j].hash
+		})
+	}
+
+	return pool, nil
+}
+
+// Pick returns the healthy replica that a request to path should be proxied to and counts the request as in flight.
+// Done must be called on the replica when the request has finished. ErrNoHealthyReplicas is returned if no replica is healthy.
+func (pool *Pool) Pick(path string) (*Replica, error) {
+	var picked *Replica
+
+	switch pool.balance {
+	case LeastConnections:
+		picked = pool.pickLeastConnections()
+	case ConsistentHash:
+		picked = pool.pickConsistentHash(path)
+	default:
+		picked = pool.pickRoundRobin()
+	}
+
+	if picked == nil {
+		return nil, ErrNoHealthyReplicas
+	}
+
+	atomic.AddInt64(&picked.active, 1)
+
+	return picked, nil
+}
+
+// pickRoundRobin returns the next healthy replica in turn, or nil if there is none.
+func (pool *Pool) pickRoundRobin() *Replica {
+	start := int((atomic.AddUint64(&pool.next, 1) - 1) % uint64(len(pool.replicas)))
+
+	for i := range pool.replicas {
+		replica := pool.replicas[(start+i)%len(pool.replicas)]
+		if replica.Healthy() {
+			return replica
+		}
+	}
+
+	return nil
+}
+
+// pickLeastConnections returns the healthy replica with the fewest requests in flight, or nil if there is none.
+// Ties are broken in turn, so idle replicas share the requests like with round-robin.
+func (pool *Pool) pickLeastConnections() *Replica {
+	start := int((atomic.AddUint64(&pool.next, 1) - 1) % uint64(len(pool.replicas)))
+
+	var picked *Replica
+	for i := range pool.replicas {
+		replica := pool.replicas[(start+i)%len(pool.replicas)]
+		if replica.Healthy() && (picked == nil || replica.Active() < picked.Active()) {
+			picked = replica
+		}
+	}
+
+	return picked
+}
+
+// pickConsistentHash returns the healthy replica that owns path on the hash ring, or nil if there is none.
+// If the owner is not healthy, the next healthy replica on the ring is used, so only the paths of the failing replica move.
+func (pool *Pool) pickConsistentHash(path string) *Replica {
+	hash := crc32.ChecksumIEEE([]byte(path))
+	start := sort.Search(len(pool.ring), func(i int) bool {
+		return pool.ring[i].hash >= hash
+	})
+
+	for i := range pool.ring {
+		replica := pool.replicas[pool.ring[(start+i)%len(pool.ring)].replica]
+		if replica.Healthy() {
+			return replica
+		}
+	}
+
+	return nil
+}
+
+// Replicas returns the replicas of the Pool in the order of the Url and Replicas of the upstream.
+func (pool *Pool) Replicas() []*Replica {
+	return pool.replicas
+}
+
+// Done marks a request that was picked for the replica as finished.
+func (replica *Replica) Done() {
+	atomic.AddInt64(&replica.active, -1)
+}
+
+// Healthy returns true if the replica is in rotation.
+func (replica *Replica) Healthy() bool {
+	return atomic.LoadInt32(&replica.healthy) == 1
+}
+
+// Active returns the number of requests in flight to the replica.
+func (replica *Replica) Active() int64 {
+	return atomic.LoadInt64(&replica.active)
+}
+
+// Pools are the Pools of all upstreams by name, where the empty name is the ApiUrl.
+type Pools map[string]*Pool
+
+// NewPools returns a Pool for the ApiUrl and for every upstream of conf, or the first error from NewPool.
+func NewPools(conf *config.Config) (Pools, error) {
+	pools := make(Pools)
+	for _, name := range append([]string{""}, conf.UpstreamNames()...) {
+		pool, err := NewPool(name, conf.Upstream(name))
+		if err != nil {
+			return nil, err
+		}
+		pools[name] = pool
+	}
+
+	return pools, nil
+}
+
+// Start starts the health checks of all Pools.
+func (pools Pools) Start() {
+	for _, pool := range pools {
+		pool.Start()
+	}
+}
+
+// Stop stops the health checks of all Pools and closes their idle connections.
+func (pools Pools) Stop() {
+	for _, pool := range pools {
+		pool.Stop()
+	}
+}
+
+// names returns the names of the Pools in alphabetical order, which puts the ApiUrl first.
+func (pools Pools) names() []string {
+	names := make([]string, 0, len(pools))
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,199 @@
+package upstream
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/magnus-bb/cache-me-ousside/internal/config"
+	"github.com/magnus-bb/cache-me-ousside/internal/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	logger.Initialize("", logger.RotationConfig{})
+}
+
+func newTestUpstream(balance string) config.Upstream {
+	return config.Upstream{
+		Url:      "http://a.example.com",
+		Replicas: []string{"http://b.example.com", "http://c.example.com"},
+		Balance:  balance,
+	}
+}
+
+// newTestPool returns a Pool for the upstream and fails the test if it cannot be built.
+func newTestPool(t *testing.T, name string, upstream config.Upstream) *Pool {
+	pool, err := NewPool(name, upstream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pool
+}
+
+// pickURL picks a replica for path and marks the request as done right away.
+func pickURL(t *testing.T, pool *Pool, path string) string {
+	replica, err := pool.Pick(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica.Done()
+
+	return replica.URL
+}
+
+func TestRoundRobin(t *testing.T) {
+	assert := assert.New(t)
+
+	pool := newTestPool(t, "", newTestUpstream(""))
+
+	picked := []string{pickURL(t, pool, "/"), pickURL(t, pool, "/"), pickURL(t, pool, "/"), pickURL(t, pool, "/")}
+	assert.Equal([]string{"http://a.example.com", "http://b.example.com", "http://c.example.com", "http://a.example.com"}, picked, "Expected round-robin to be the default and pick every replica in turn")
+
+	atomic.StoreInt32(&pool.replicas[1].healthy, 0)
+
+	picked = []string{pickURL(t, pool, "/"), pickURL(t, pool, "/"), pickURL(t, pool, "/")}
+	assert.NotContains(picked, "http://b.example.com", "Expected unhealthy replicas to be skipped")
+
+	for _, replica := range pool.replicas {
+		atomic.StoreInt32(&replica.healthy, 0)
+	}
+
+	_, err := pool.Pick("/")
+	assert.ErrorIs(err, ErrNoHealthyReplicas, "Expected an error when all replicas are unhealthy")
+}
+
+func TestLeastConnections(t *testing.T) {
+	assert := assert.New(t)
+
+	pool := newTestPool(t, "", newTestUpstream(LeastConnections))
+
+	first, _ := pool.Pick("/")
+	second, _ := pool.Pick("/")
+	assert.NotEqual(first.URL, second.URL, "Expected a replica with a request in flight not to be picked while others are idle")
+
+	third, _ := pool.Pick("/")
+	assert.NotContains([]string{first.URL, second.URL}, third.URL, "Expected the idle replica to be picked")
+
+	second.Done()
+	assert.Equal(second.URL, pickURL(t, pool, "/"), "Expected the replica with the fewest requests in flight to be picked")
+	assert.Equal(int64(1), first.Active(), "Expected requests to be counted until they are done")
+}
+
+func TestConsistentHash(t *testing.T) {
+	assert := assert.New(t)
+
+	pool := newTestPool(t, "", newTestUpstream(ConsistentHash))
+
+	owners := make(map[string]string)
+	for _, path := range []string{"/posts/1", "/posts/2", "/users/1", "/users/2", "/comments"} {
+		owners[path] = pickURL(t, pool, path)
+		assert.Equal(owners[path], pickURL(t, pool, path), "Expected the same path to always go to the same replica")
+	}
+
+	failing := owners["/posts/1"]
+	for _, replica := range pool.replicas {
+		if replica.URL == failing {
+			atomic.StoreInt32(&replica.healthy, 0)
+		}
+	}
+
+	assert.NotEqual(failing, pickURL(t, pool, "/posts/1"), "Expected the paths of an unhealthy replica to move to another replica")
+	for path, owner := range owners {
+		if owner != failing {
+			assert.Equal(owner, pickURL(t, pool, path), "Expected the paths of healthy replicas not to move")
+		}
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	assert := assert.New(t)
+
+	var healthy int32 = 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" || atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	pool := newTestPool(t, "users", config.Upstream{
+		Url: server.URL,
+		HealthCheck: config.HealthCheck{
+			Path:               "/health",
+			Interval:           config.Duration(10 * time.Millisecond),
+			UnhealthyThreshold: 2,
+		},
+	})
+	pool.Start()
+	defer pool.Stop()
+
+	replica := pool.Replicas()[0]
+
+	atomic.StoreInt32(&healthy, 0)
+	assert.Eventually(func() bool { return !replica.Healthy() }, time.Second, 5*time.Millisecond, "Expected a failing replica to be taken out of rotation")
+
+	stats := pool.Stats()
+	assert.Equal(0, stats.Healthy)
+	assert.Contains(stats.Replicas[0].LastCheckError, "500", "Expected the stats to show why the last check failed")
+
+	atomic.StoreInt32(&healthy, 1)
+	assert.Eventually(func() bool { return replica.Healthy() }, time.Second, 5*time.Millisecond, "Expected a recovered replica to be put back into rotation")
+}
+
+func TestHealthChecksTLS(t *testing.T) {
+	assert := assert.New(t)
+
+	var checks int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&checks, 1)
+	}))
+	defer server.Close()
+
+	// The server has a certificate from a CA that is only trusted through the CAFile of the upstream
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600)
+
+	pool := newTestPool(t, "users", config.Upstream{
+		Url: server.URL,
+		TLS: config.UpstreamTLS{CAFile: caFile},
+		HealthCheck: config.HealthCheck{
+			Path:               "/health",
+			Interval:           config.Duration(10 * time.Millisecond),
+			UnhealthyThreshold: 1,
+		},
+	})
+	pool.Start()
+	defer pool.Stop()
+
+	assert.Eventually(func() bool { return atomic.LoadInt32(&checks) >= 2 }, time.Second, 5*time.Millisecond,
+		"Expected health checks to connect with the TLS settings of the upstream")
+	assert.True(pool.Replicas()[0].Healthy(), "Expected a replica that passes its checks over TLS to stay in rotation")
+	assert.Empty(pool.Stats().Replicas[0].LastCheckError)
+}
+
+func TestPoolsStats(t *testing.T) {
+	conf := config.New()
+	conf.ApiUrl = "http://api.example.com"
+	conf.Upstreams = config.UpstreamMap{"users": newTestUpstream(LeastConnections)}
+
+	pools, err := NewPools(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := pools.Stats()
+
+	if assert.Len(t, stats, 2) {
+		assert.Equal(t, "", stats[0].Name, "Expected the default upstream to come first")
+		assert.Equal(t, RoundRobin, stats[0].Balance)
+		assert.Equal(t, "users", stats[1].Name)
+		assert.Len(t, stats[1].Replicas, 3)
+		assert.Nil(t, stats[1].Replicas[0].LastCheck, "Expected replicas without health checks to have no last check")
+	}
+}
@@ -0,0 +1,126 @@
+package upstream
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/magnus-bb/cache-me-ousside/internal/config"
+	"github.com/magnus-bb/cache-me-ousside/internal/logger"
+	"github.com/valyala/fasthttp"
+)
+
+// newClient returns the client that a Pool proxies requests with, so every upstream has its own timeouts, connections, and TLS settings.
+func newClient(settings config.ProxySettings, tlsConfig *tls.Config) *fasthttp.Client {
+	dialTimeout := settings.DialTimeout.Std()
+
+	return &fasthttp.Client{
+		// Same as the client of the fiber proxy middleware
+		NoDefaultUserAgentHeader: true,
+		DisablePathNormalizing:   true,
+
+		Dial: func(addr string) (net.Conn, error) {
+			return fasthttp.DialTimeout(addr, dialTimeout)
+		},
+		ReadTimeout:         settings.ReadTimeout.Std(),
+		WriteTimeout:        settings.WriteTimeout.Std(),
+		MaxConnsPerHost:     int(settings.MaxConnsPerHost),
+		MaxIdleConnDuration: settings.IdleConnTimeout.Std(),
+		// Wait for a connection to free up when MaxConnsPerHost is reached, instead of failing right away
+		MaxConnWaitTimeout: dialTimeout,
+		// Retries are done by Proxy instead, so they can go to another replica and show up in the logs
+		MaxIdemponentCallAttempts: 1,
+
+		TLSConfig: tlsConfig,
+	}
+}
+
+// Proxy sends req to a replica of the Pool and writes the API response to resp.
+// GET and HEAD requests that fail with an error or a 502, 503, or 504 status are retried up to the Retries of the upstream,
+// with a backoff that doubles for every retry. Each retry picks a replica again, so it can go to another server.
+// It returns ErrNoHealthyReplicas if no replica could be picked, otherwise the error of the last attempt.
+func (pool *Pool) Proxy(req *fasthttp.Request, resp *fasthttp.Response) error {
+	path := string(req.URI().Path())
+	requestURI := string(req.RequestURI())
+	defer req.SetRequestURI(requestURI) // the request is still used by the cache after it has been proxied
+
+	attempts := uint(1)
+	if req.Header.IsGet() || req.Header.IsHead() {
+		attempts += pool.proxy.Retries
+	}
+
+	for attempt := uint(1); ; attempt++ {
+		err := pool.do(req, resp, path, requestURI)
+		if errors.Is(err, ErrNoHealthyReplicas) || (err == nil && !retryStatus(resp.StatusCode())) || attempt == attempts {
+			return err
+		}
+
+		reason := fmt.Sprintf("status %d", resp.StatusCode())
+		if err != nil {
+			reason = err.Error()
+		}
+
+		backoff := pool.proxy.Backoff(attempt)
+		logger.Warn(fmt.Sprintf("request to %s for %s failed with %s, retrying in %s (attempt %d of %d)", pool, requestURI, reason, backoff, attempt+1, attempts))
+		time.Sleep(backoff)
+	}
+}
+
+// do picks a replica and proxies req to it once. Errors are prefixed with the URL of the replica.
+func (pool *Pool) do(req *fasthttp.Request, resp *fasthttp.Response, path, requestURI string) error {
+	replica, err := pool.Pick(path)
+	if err != nil {
+		return err
+	}
+	defer replica.Done()
+
+	req.SetRequestURI(replica.URL + requestURI)
+	// Requests to the cache server over HTTPS default to the https scheme, so set the scheme of the replica explicitly
+	if scheme, _, found := strings.Cut(replica.URL, "://"); found {
+		req.URI().SetScheme(scheme)
+	}
+	req.Header.Del(fasthttp.HeaderConnection)
+
+	if err := pool.client.Do(req, resp); err != nil {
+		// With TLS 1.3, a server rejects a client certificate after the handshake, which fasthttp reports as a closed connection
+		if errors.Is(err, fasthttp.ErrConnectionClosed) && strings.HasPrefix(replica.URL, "https://") {
+			return fmt.Errorf("%s: the server closed the connection, possibly because it did not accept the client certificate: %w", replica.URL, err)
+		}
+
+		return fmt.Errorf("%s: %w", replica.URL, err)
+	}
+
+	resp.Header.Del(fasthttp.HeaderConnection)
+
+	return nil
+}
+
+// retryStatus returns true for the statuses that a GET or HEAD request is retried on.
+func retryStatus(status int) bool {
+	return status == fasthttp.StatusBadGateway || status == fasthttp.StatusServiceUnavailable || status == fasthttp.StatusGatewayTimeout
+}
+
+// IsTimeout returns true if err is from a request that timed out while connecting to, writing to, or reading from a server.
+func IsTimeout(err error) bool {
+	var timeoutErr interface{ Timeout() bool } // both net.Error and fasthttp.ErrTimeout
+	return errors.Is(err, fasthttp.ErrDialTimeout) || errors.Is(err, fasthttp.ErrTLSHandshakeTimeout) || (errors.As(err, &timeoutErr) && timeoutErr.Timeout())
+}
+
+// IsTLSError returns true if err is from a failed TLS handshake with a server,
+// e.g. because its certificate is not trusted or it did not accept the client certificate.
+func IsTLSError(err error) bool {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		invalidCert      x509.CertificateInvalidError
+		wrongHostname    x509.HostnameError
+		recordHeader     tls.RecordHeaderError
+	)
+
+	// Alerts from the server, e.g. "remote error: tls: bad certificate", are not exported, so they can only be told apart by their message
+	return errors.As(err, &unknownAuthority) || errors.As(err, &invalidCert) || errors.As(err, &wrongHostname) ||
+		errors.As(err, &recordHeader) || strings.Contains(err.Error(), "tls: ")
+}
@@ -0,0 +1,120 @@
+package upstream
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/magnus-bb/cache-me-ousside/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+// proxyRequest proxies a request with the method and URI through the Pool and returns the response.
+func proxyRequest(pool *Pool, method, requestURI string) (*fasthttp.Response, error) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.Header.SetMethod(method)
+	req.SetRequestURI(requestURI)
+
+	resp := &fasthttp.Response{}
+	err := pool.Proxy(req, resp)
+
+	return resp, err
+}
+
+func TestProxyRetries(t *testing.T) {
+	assert := assert.New(t)
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail every request but the third
+		if atomic.AddInt32(&requests, 1) != 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(r.URL.RequestURI()))
+	}))
+	defer server.Close()
+
+	pool := newTestPool(t, "", config.Upstream{Url: server.URL, Proxy: config.ProxySettings{Retries: 2, RetryBackoff: config.Duration(time.Millisecond)}})
+	defer pool.Stop()
+
+	resp, err := proxyRequest(pool, fasthttp.MethodGet, "/posts?page=2")
+	if assert.NoError(err) {
+		assert.Equal(fasthttp.StatusOK, resp.StatusCode(), "Expected a GET request to be retried until it succeeds")
+		assert.Equal("/posts?page=2", string(resp.Body()), "Expected the path and query to be proxied")
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	resp, err = proxyRequest(pool, fasthttp.MethodPost, "/posts")
+	if assert.NoError(err) {
+		assert.Equal(fasthttp.StatusServiceUnavailable, resp.StatusCode(), "Expected a POST request not to be retried")
+		assert.Equal(int32(1), atomic.LoadInt32(&requests))
+	}
+}
+
+func TestProxyTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	pool := newTestPool(t, "", config.Upstream{Url: server.URL, Proxy: config.ProxySettings{ReadTimeout: config.Duration(10 * time.Millisecond)}})
+	defer pool.Stop()
+
+	_, err := proxyRequest(pool, fasthttp.MethodGet, "/posts")
+	assert.True(t, IsTimeout(err), "Expected a request to a slow server to time out, got: %v", err)
+}
+
+func TestProxyTLS(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	// With TLS 1.3, a missing client certificate is only noticed after the handshake, so it cannot be reported as a TLS error
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	// The certificate of the test server is used both as the CA and as the client certificate
+	dir := t.TempDir()
+	certificate := server.TLS.Certificates[0]
+	key, _ := x509.MarshalPKCS8PrivateKey(certificate.PrivateKey)
+	os.WriteFile(filepath.Join(dir, "cert.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]}), 0o600)
+	os.WriteFile(filepath.Join(dir, "key.pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600)
+
+	tests := []struct {
+		settings config.UpstreamTLS
+		tlsError bool
+		message  string
+	}{
+		{config.UpstreamTLS{}, true, "Expected a server with a certificate from an unknown CA to fail the handshake"},
+		{config.UpstreamTLS{CAFile: filepath.Join(dir, "cert.pem")}, true, "Expected a server that requires a client certificate to fail the handshake without one"},
+		{config.UpstreamTLS{CAFile: filepath.Join(dir, "cert.pem"), CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem"), ServerName: "wrong.example.org"}, true, "Expected a server name that is not in the certificate to fail the handshake"},
+		{config.UpstreamTLS{CAFile: filepath.Join(dir, "cert.pem"), CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem"), ServerName: "example.com"}, false, "Expected a handshake with a trusted CA, a client certificate, and a valid server name to succeed"},
+		{config.UpstreamTLS{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem"), InsecureSkipVerify: true}, false, "Expected any server certificate to be accepted when verification is skipped"},
+	}
+
+	for _, tt := range tests {
+		pool := newTestPool(t, "", config.Upstream{Url: server.URL, TLS: tt.settings})
+
+		_, err := proxyRequest(pool, fasthttp.MethodGet, "/posts")
+		if tt.tlsError {
+			assert.True(err != nil && IsTLSError(err), "%s, got: %v", tt.message, err)
+		} else {
+			assert.NoError(err, tt.message)
+		}
+
+		pool.Stop()
+	}
+
+	_, err := NewPool("", config.Upstream{Url: server.URL, TLS: config.UpstreamTLS{CAFile: filepath.Join(dir, "key.pem")}})
+	assert.Error(err, "Expected an error when the CA file has no certificates")
+}
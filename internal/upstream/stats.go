@@ -0,0 +1,71 @@
+package upstream
+
+import "time"
+
+// PoolStats is the state of a Pool, as it is shown on the stats path of the cache server.
+type PoolStats struct {
+	// Name is the name of the upstream, or an empty string for the ApiUrl.
+	Name           string         `json:"name"`
+	Balance        string         `json:"balance"`
+	HealthCheck    bool           `json:"healthCheck"`              // whether the replicas are health checked
+	Healthy        int            `json:"healthy"`                  // the number of replicas in rotation
+	CircuitBreaker string         `json:"circuitBreaker,omitempty"` // the state of the Breaker, or empty if it is disabled
+	Replicas       []ReplicaStats `json:"replicas"`
+}
+
+// ReplicaStats is the state of a Replica.
+type ReplicaStats struct {
+	URL            string `json:"url"`
+	Healthy        bool   `json:"healthy"`
+	ActiveRequests int64  `json:"activeRequests"`
+	// LastCheck is when the replica was last health checked, or nil if it has not been checked.
+	LastCheck *time.Time `json:"lastCheck,omitempty"`
+	// LastCheckError is why the last health check failed, or an empty string if it succeeded.
+	LastCheckError string `json:"lastCheckError,omitempty"`
+}
+
+// Stats returns the current state of the Pool and its replicas.
+func (pool *Pool) Stats() PoolStats {
+	stats := PoolStats{
+		Name:        pool.Name,
+		Balance:     pool.balance,
+		HealthCheck: pool.check.Enabled(),
+		Healthy:     pool.healthyCount(),
+		Replicas:    make([]ReplicaStats, 0, len(pool.replicas)),
+	}
+	if pool.Breaker.settings.Enabled() {
+		stats.CircuitBreaker = pool.Breaker.State()
+	}
+
+	for _, replica := range pool.replicas {
+		replicaStats := ReplicaStats{
+			URL:            replica.URL,
+			Healthy:        replica.Healthy(),
+			ActiveRequests: replica.Active(),
+		}
+
+		replica.mu.Lock()
+		if replica.lastCheck != nil {
+			checkTime := replica.lastCheck.time
+			replicaStats.LastCheck = &checkTime
+			if replica.lastCheck.err != nil {
+				replicaStats.LastCheckError = replica.lastCheck.err.Error()
+			}
+		}
+		replica.mu.Unlock()
+
+		stats.Replicas = append(stats.Replicas, replicaStats)
+	}
+
+	return stats
+}
+
+// Stats returns the state of all Pools, ordered by name, which puts the ApiUrl first.
+func (pools Pools) Stats() []PoolStats {
+	stats := make([]PoolStats, 0, len(pools))
+	for _, name := range pools.names() {
+		stats = append(stats, pools[name].Stats())
+	}
+
+	return stats
+}
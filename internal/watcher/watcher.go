@@ -0,0 +1,128 @@
+// Package watcher notices changes to files by polling them,
+// which works the same on every platform and for files on mounted volumes.
+package watcher
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultInterval is how often files are checked for changes, if no other interval is given.
+const DefaultInterval = 2 * time.Second
+
+// Watcher polls a set of files and calls a function whenever one of them has changed.
+type Watcher struct {
+	interval time.Duration
+	onChange func()
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mutex sync.Mutex
+	files map[string]fileState // the last seen state of every watched file
+}
+
+// fileState is what is compared between polls to find out if a file has changed.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+// Watch starts polling the files at paths every interval and calls onChange when any of them
+// have been modified, created, or removed since the last poll.
+// onChange is never called more than once per poll, and never concurrently.
+func Watch(interval time.Duration, onChange func(), paths ...string) *Watcher {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	watcher := &Watcher{
+		interval: interval,
+		onChange: onChange,
+		stop:     make(chan struct{}),
+	}
+
+	watcher.SetPaths(paths...)
+
+	go watcher.poll()
+
+	return watcher
+}
+
+// SetPaths replaces the watched files with the files at paths.
+// This is useful when the set of files to watch is only known after reading the watched files.
+// Files that were already watched keep their last seen state, so a change that has not been polled yet is not missed.
+func (watcher *Watcher) SetPaths(paths ...string) {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	files := make(map[string]fileState, len(paths))
+	for _, path := range paths {
+		if state, watched := watcher.files[path]; watched {
+			files[path] = state
+			continue
+		}
+
+		files[path] = stat(path)
+	}
+
+	watcher.files = files
+}
+
+// Stop stops polling the files. It is safe to call Stop more than once.
+func (watcher *Watcher) Stop() {
+	watcher.stopOnce.Do(func() {
+		close(watcher.stop)
+	})
+}
+
+// poll checks the files for changes every interval until the Watcher is stopped.
+func (watcher *Watcher) poll() {
+	ticker := time.NewTicker(watcher.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-watcher.stop:
+			return
+		case <-ticker.C:
+			if watcher.changed() {
+				watcher.onChange()
+			}
+		}
+	}
+}
+
+// changed returns true if any of the files have changed since the last poll, and remembers their new state.
+func (watcher *Watcher) changed() bool {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	changed := false
+
+	for path, lastState := range watcher.files {
+		state := stat(path)
+
+		if state != lastState {
+			watcher.files[path] = state
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// stat returns the current state of the file at path.
+func stat(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+
+	return fileState{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		exists:  true,
+	}
+}
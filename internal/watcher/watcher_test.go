@@ -0,0 +1,40 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchCallsOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watched.json")
+	os.WriteFile(path, []byte("{}"), 0666)
+
+	changes := make(chan struct{}, 10)
+
+	watcher := Watch(10*time.Millisecond, func() { changes <- struct{}{} }, path)
+	defer watcher.Stop()
+
+	select {
+	case <-changes:
+		t.Fatal("Expected Watch to not call onChange before the file has changed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	os.WriteFile(path, []byte(`{"capacity": 5}`), 0666)
+
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Watch to call onChange after the file has changed")
+	}
+}
+
+func TestStatMissingFile(t *testing.T) {
+	state := stat(filepath.Join(t.TempDir(), "missing.json"))
+
+	assert.False(t, state.exists, "Expected a missing file to be recorded as not existing, so its creation counts as a change")
+}
@@ -1,7 +1,14 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/magnus-bb/cache-me-ousside/cache"
+	"github.com/magnus-bb/cache-me-ousside/internal/certs"
 	commandline "github.com/magnus-bb/cache-me-ousside/internal/cli"
 	"github.com/magnus-bb/cache-me-ousside/internal/logger"
 	"github.com/magnus-bb/cache-me-ousside/internal/router"
@@ -9,13 +16,16 @@ import (
 
 func main() {
 	// Initialize logger in terminal mode to log any startup errors to stdout before a potential log file is provided
-	logger.Initialize("") // we want all startup errors etc to be logged to terminal, then we will log to file later if one is provided
+	logger.Initialize("", logger.RotationConfig{}) // we want all startup errors etc to be logged to terminal, then we will log to file later if one is provided
 
 	// Get configuration struct from CLI (which might read a config file, if provided)
 	conf, err := commandline.CreateConfFromCli()
 	if err != nil {
 		logger.Fatal(err)
 	}
+	if conf == nil {
+		return // a command like print or --help was run instead of the cache server
+	}
 
 	// Create the actual cache to hold entries
 	dataCache, err := cache.New(conf.Capacity, conf.CapacityUnit)
@@ -23,20 +33,113 @@ func main() {
 		logger.Fatal(err)
 	}
 
+	// Load the TLS certificate before anything else is started, so a missing certificate stops the server right away
+	var serverTLS *certs.Server
+	if conf.TLS.Enabled() {
+		serverTLS, err = certs.NewServer(conf.TLS)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
+
 	// Setup the router
-	app := router.New(conf, dataCache)
+	app, err := router.New(conf, dataCache)
+	if err != nil {
+		logger.Fatal(err)
+	}
 
 	// Say hello in terminal
 	logger.HiMom(conf.String(), conf.Address())
 
 	// Set logger to use log file if any is provided
 	if conf.LogFilePath != "" {
-		logFile := logger.Initialize(conf.LogFilePath)
+		logFile := logger.Initialize(conf.LogFilePath, conf.LogRotation())
 		if logFile != nil {
 			defer logFile.Close()
 		}
 	}
 
+	// Write a line for every request to the access log, if one is provided
+	if conf.AccessLogPath != "" {
+		accessLogFile, err := logger.InitializeAccessLog(conf.AccessLogPath, conf.AccessLogFormat, conf.LogRotation())
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if accessLogFile != nil {
+			defer accessLogFile.Close()
+		}
+	}
+
+	// Rebuild the routes from the config file (or the files it extends and includes) when it changes, while keeping the cache
+	configReloader := &reloader{conf: conf, started: conf, router: app}
+	if conf.WatchConfig && commandline.ConfigPath() != "" {
+		configWatcher := configReloader.watch()
+		defer configWatcher.Stop()
+	}
+
+	// Reload the TLS certificate when it is renewed
+	if serverTLS != nil {
+		serverTLS.Watch()
+		defer serverTLS.Stop()
+	}
+
+	// Reopen the log files and reload the configuration on SIGHUP, so external tools like logrotate can move the log files
+	go handleHangups(configReloader, serverTLS)
+
+	// Stop accepting connections and let in-flight requests finish on SIGINT and SIGTERM
+	shutdownDone := make(chan struct{})
+	go shutdownOnSignal(app, conf.ShutdownTimeout.Std(), shutdownDone)
+
 	// Start the server
-	logger.Panic(app.Listen(conf.Address()))
+	if serverTLS != nil {
+		err = app.ListenTLS(conf.Address(), serverTLS.Config())
+	} else {
+		err = app.Listen(conf.Address())
+	}
+	if err != nil {
+		logger.Panic(err)
+	}
+
+	// Listen returns as soon as the server stops accepting connections, so wait for the in-flight requests
+	// before the deferred functions stop the config watcher and flush and close the log files
+	<-shutdownDone
+	logger.Info("the cache server has been shut down")
+}
+
+// shutdownOnSignal blocks until the process receives SIGINT or SIGTERM and then shuts down the server gracefully,
+// giving in-flight requests up to timeout to finish. done is closed when the server has been shut down.
+func shutdownOnSignal(app *router.Router, timeout time.Duration, done chan<- struct{}) {
+	defer close(done)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-signals
+	logger.Info(fmt.Sprintf("received %s, shutting down and waiting for in-flight requests to finish", sig))
+
+	// Stop listening for signals, so a second SIGINT will kill the process if the shutdown takes too long
+	signal.Stop(signals)
+
+	if err := app.Shutdown(timeout); err != nil {
+		logger.Error(fmt.Errorf("the cache server was not shut down gracefully: %w", err))
+	}
+}
+
+// handleHangups blocks and reopens the log files every time the process receives SIGHUP.
+// If the configuration was read from a file, the configuration is reloaded as well, and so is the TLS certificate if serverTLS is not nil.
+func handleHangups(configReloader *reloader, serverTLS *certs.Server) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	for range hangups {
+		logger.Reopen()
+
+		if commandline.ConfigPath() != "" {
+			configReloader.reload()
+		}
+
+		if serverTLS != nil {
+			serverTLS.Reload()
+		}
+	}
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	commandline "github.com/magnus-bb/cache-me-ousside/internal/cli"
+	"github.com/magnus-bb/cache-me-ousside/internal/config"
+	"github.com/magnus-bb/cache-me-ousside/internal/logger"
+	"github.com/magnus-bb/cache-me-ousside/internal/router"
+	"github.com/magnus-bb/cache-me-ousside/internal/watcher"
+)
+
+// reloader creates the Config again and rebuilds the router's routes from it,
+// when the config file changes or the process receives SIGHUP.
+type reloader struct {
+	mutex   sync.Mutex // makes sure a file change and a SIGHUP do not reload at the same time
+	conf    *config.Config
+	started *config.Config // the configuration the server was started with, which settings that need a restart are compared with
+	router  *router.Router
+	watcher *watcher.Watcher // nil if the config file is not watched
+}
+
+// watch starts reloading the configuration whenever one of the config files it was loaded from changes.
+// The returned Watcher must be stopped when the configuration should no longer be reloaded.
+func (r *reloader) watch() *watcher.Watcher {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.watcher = watcher.Watch(watcher.DefaultInterval, r.reload, r.conf.Sources()...)
+
+	return r.watcher
+}
+
+// reload re-reads the configuration and swaps the router's routes if the new configuration is valid.
+// An invalid configuration is logged and ignored, so the current configuration keeps serving.
+func (r *reloader) reload() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	newConf, err := commandline.ReloadConf()
+	if err != nil {
+		logger.Error(fmt.Errorf("the configuration was not reloaded, the current configuration will be kept: %w", err))
+		return
+	}
+
+	// Compare with the configuration the server was started with, since the settings were never applied if they were changed by an earlier reload
+	if changed := r.started.RestartRequiredChanges(newConf); len(changed) > 0 {
+		logger.Warn(fmt.Sprintf("changes to the following settings will not take effect until the cache server is restarted: %s", strings.Join(changed, ", ")))
+	}
+
+	if err := r.router.Reload(newConf); err != nil {
+		logger.Error(fmt.Errorf("the configuration was not reloaded, the current configuration will be kept: %w", err))
+		return
+	}
+	r.conf = newConf
+
+	// The config file might extend or include other files now
+	if r.watcher != nil {
+		r.watcher.SetPaths(newConf.Sources()...)
+	}
+
+	logger.Info("the configuration has been reloaded from " + commandline.ConfigPath())
+}